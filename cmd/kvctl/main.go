@@ -0,0 +1,262 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/rupeshx80/consistent-hashing/pkg/backup"
+	"github.com/rupeshx80/consistent-hashing/pkg/bulk"
+	"github.com/rupeshx80/consistent-hashing/pkg/storage"
+)
+
+const usage = `usage: kvctl <command> [flags]
+
+commands:
+  backup   -dir DIR   write every token range of the cluster to DIR
+  restore  -dir DIR   re-ingest a backup through the cluster's ring
+  import   -file F    load JSON Lines or CSV records into the cluster
+  export   -file F    dump live keys as JSON Lines or CSV
+  migrate  up|down|status   move a sql table between schema versions
+
+run "kvctl <command> -h" for the flags of a command`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "backup":
+		err = runBackup(os.Args[2:])
+	case "restore":
+		err = runRestore(os.Args[2:])
+	case "import":
+		err = runImport(os.Args[2:])
+	case "export":
+		err = runExport(os.Args[2:])
+	case "migrate":
+		err = runMigrate(os.Args[2:])
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatalf("[KVCTL] %s failed: %v", os.Args[1], err)
+	}
+}
+
+func runBackup(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	server := fs.String("server", "http://127.0.0.1:5000", "main server of the cluster to back up")
+	nodeHost := fs.String("node-host", "http://127.0.0.1", "host the ring's node addresses are reached on")
+	dir := fs.String("dir", "", "directory to write the backup to, must not hold one already")
+	minOwners := fs.Int("min-owners", 2, "owners of a range that must answer (R keeps every acknowledged write)")
+	fs.Parse(args)
+
+	if *dir == "" {
+		return fmt.Errorf("-dir is required")
+	}
+
+	manifest, err := backup.Backup(backup.Config{
+		Server:    *server,
+		NodeHost:  *nodeHost,
+		Dir:       *dir,
+		MinOwners: *minOwners,
+	})
+	if err != nil {
+		return err
+	}
+
+	keys, versions := 0, 0
+	for _, rm := range manifest.Ranges {
+		keys += rm.Keys
+		versions += rm.Versions
+	}
+	log.Printf("[KVCTL] Backed up %d ranges, %d keys, %d versions to %s", len(manifest.Ranges), keys, versions, *dir)
+	return nil
+}
+
+func runRestore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	server := fs.String("server", "http://127.0.0.1:5000", "main server of the cluster to restore into")
+	dir := fs.String("dir", "", "directory holding the backup")
+	batch := fs.Int("batch", backup.DefaultRestoreBatch, "versions sent per /ingest request")
+	secret := fs.String("admin-secret", os.Getenv("ADMIN_SECRET"), "the cluster's ADMIN_SECRET, /ingest refuses restores without it")
+	fs.Parse(args)
+
+	if *dir == "" {
+		return fmt.Errorf("-dir is required")
+	}
+
+	stats, err := backup.Restore(*server, *secret, *dir, *batch)
+	log.Printf("[KVCTL] Restored %d ranges, %d versions, %d failed", stats.Ranges, stats.Versions, stats.Failed)
+	if err != nil {
+		return err
+	}
+	if stats.Failed > 0 {
+		return fmt.Errorf("%d versions were not restored", stats.Failed)
+	}
+	return nil
+}
+
+//-format wins, otherwise the file extension decides
+func fileFormat(flagValue, path string) (string, error) {
+	if flagValue == "" {
+		return bulk.FormatFromPath(path), nil
+	}
+	return bulk.ParseFormat(flagValue)
+}
+
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	server := fs.String("server", "http://127.0.0.1:5000", "main server of the cluster to load into")
+	file := fs.String("file", "", "file to import, - reads stdin")
+	format := fs.String("format", "", "jsonl or csv, picked from the file extension when empty")
+	chunk := fs.Int("chunk", bulk.DefaultChunkSize, "records sent per /import request")
+	fs.Parse(args)
+
+	if *file == "" {
+		return fmt.Errorf("-file is required")
+	}
+	f, err := fileFormat(*format, *file)
+	if err != nil {
+		return err
+	}
+
+	in := os.Stdin
+	if *file != "-" {
+		if in, err = os.Open(*file); err != nil {
+			return err
+		}
+		defer in.Close()
+	}
+
+	report, err := bulk.Import(*server, f, in, *chunk, func(r bulk.Report) {
+		log.Printf("[KVCTL] %d lines, %d stored, %d failed", r.Lines, r.Stored, r.Failed)
+	})
+	if report != nil {
+		for _, e := range report.Errors {
+			log.Printf("[KVCTL] line %d key='%s': %s", e.Line, e.Key, e.Error)
+		}
+		if report.ErrorsTruncated {
+			log.Printf("[KVCTL] only the first %d errors are listed", len(report.Errors))
+		}
+		log.Printf("[KVCTL] Imported %d of %d lines, %d failed", report.Stored, report.Lines, report.Failed)
+	}
+	if err != nil {
+		return err
+	}
+	if report.Failed > 0 {
+		return fmt.Errorf("%d lines were not imported", report.Failed)
+	}
+	return nil
+}
+
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	server := fs.String("server", "http://127.0.0.1:5000", "main server of the cluster to dump")
+	file := fs.String("file", "", "file to write, - writes stdout")
+	format := fs.String("format", "", "jsonl or csv, picked from the file extension when empty")
+	prefix := fs.String("prefix", "", "only export keys with this prefix")
+	fs.Parse(args)
+
+	if *file == "" {
+		return fmt.Errorf("-file is required")
+	}
+	f, err := fileFormat(*format, *file)
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if *file != "-" {
+		if out, err = os.Create(*file); err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+
+	count, err := bulk.Export(*server, f, *prefix, out)
+	if err != nil {
+		return err
+	}
+	log.Printf("[KVCTL] Exported %d versions to %s", count, *file)
+	return nil
+}
+
+func runMigrate(args []string) error {
+	cfg := storage.ConfigFromEnv()
+
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	engine := fs.String("engine", cfg.Engine, "postgres or sqlite, STORAGE_ENGINE by default")
+	path := fs.String("path", cfg.Path, "sqlite file, STORAGE_PATH by default")
+	table := fs.String("table", "", "postgres table, the shared key_values table when empty")
+	to := fs.Int("to", -1, "target version, up defaults to the latest and down to one step back")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: kvctl migrate [flags] up|down|status")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	m, closeDB, err := storage.OpenSchemaMigrator(storage.Config{Engine: *engine, Path: *path, Table: *table})
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	current, err := m.Current()
+	if err != nil {
+		return err
+	}
+
+	switch fs.Arg(0) {
+	case "up":
+		target := *to
+		if target < 0 {
+			target = storage.LatestSchemaVersion()
+		}
+		err = m.Up(target)
+	case "down":
+		target := *to
+		if target < 0 {
+			target = current - 1
+		}
+		err = m.Down(target)
+	case "status":
+		statuses, err := m.Status()
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			applied := "pending"
+			if !s.AppliedAt.IsZero() {
+				applied = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%3d  %-28s %s\n", s.Version, s.Name, applied)
+		}
+		return nil
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		return err
+	}
+
+	now, err := m.Current()
+	if err != nil {
+		return err
+	}
+	log.Printf("[KVCTL] Schema at version %d (was %d, latest %d)", now, current, storage.LatestSchemaVersion())
+	return nil
+}
@@ -1,85 +1,196 @@
 package main
 
 import (
+	"fmt"
 	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/rupeshx80/consistent-hashing/pkg/cache"
-	"github.com/rupeshx80/consistent-hashing/pkg/db"
+	"github.com/rupeshx80/consistent-hashing/pkg/changelog"
 	"github.com/rupeshx80/consistent-hashing/pkg/hash-ring"
 	"github.com/rupeshx80/consistent-hashing/pkg/mainserver"
-	"github.com/rupeshx80/consistent-hashing/pkg/model"
+	"github.com/rupeshx80/consistent-hashing/pkg/nodeid"
 	"github.com/rupeshx80/consistent-hashing/pkg/quorum"
+	"github.com/rupeshx80/consistent-hashing/pkg/storage"
+	"github.com/rupeshx80/consistent-hashing/pkg/webhook"
 )
 
 func main() {
-	// Connect to database
-	db.Connect()
-
-	// Note: fix this later, remove unique index
-	err := db.RJ.Migrator().DropIndex(&model.KeyValue{}, "idx_key_values_key")
+	// Open the storage engine picked by STORAGE_ENGINE (postgres, sqlite, memory or lsm)
+	store, err := storage.Open(storage.ConfigFromEnv())
 	if err != nil {
-		log.Printf("Warning: Could not drop unique index: %v", err)
+		log.Fatal("Failed to open storage:", err)
 	}
+	defer store.Close()
 
-	err = db.RJ.AutoMigrate(&model.KeyValue{})
-	if err != nil {
-		log.Fatal("Failed to migrate:", err)
+	dataDir := os.Getenv("DATA_DIR")
+	if dataDir == "" {
+		dataDir = "data"
 	}
 
-	log.Println("Database migrated successfully")
+	cacheNodes := []string{":6001", ":6002", ":6003", ":6004"}
+
+	hashTags, err := hashTagsFromEnv(store, dataDir)
+	if err != nil {
+		log.Fatalf("Failed to set up key placement: %v", err)
+	}
 
-	// Initialize hash ring with 4 nodes
+	// Initialize hash ring with 4 nodes, each one keeps a persisted id in its
+	// data dir so moving it to another port doesn't change its identity
 	ring := hashring.NewHashRing(3, 3)
-	ring.AddNode(":6001", 1)
-	ring.AddNode(":6002", 1)
-	ring.AddNode(":6003", 1)
-	ring.AddNode(":6004", 1)
+	for i, addr := range cacheNodes {
+		id, err := nodeid.LoadOrCreate(filepath.Join(nodeDir(dataDir, i), "node-id"))
+		if err != nil {
+			log.Fatalf("Failed to load node id for %s: %v", addr, err)
+		}
+		ring.AddNodeWithID(id, addr, 1)
+	}
+	if hashTags {
+		ring.EnableHashTags()
+		log.Printf("Placing keys by hash tag, {user42}:a and {user42}:b share a preference list")
+	}
+
+	//the coordinator has a persisted id too, it tells its paxos ballots apart
+	coordinatorID, err := nodeid.LoadOrCreate(filepath.Join(dataDir, "main", "node-id"))
+	if err != nil {
+		log.Fatalf("Failed to load coordinator node id: %v", err)
+	}
 
 	// Initialize repository and quorum manager
-	repo := mainserver.NewKeyValueRepository()
+	repo := mainserver.NewKeyValueRepository(store)
 	qConfig := quorum.NewQuorumConfig(3, 2, 2) // N=3, W=2, R=2 (follows Dynamo paper)
 	qManager := quorum.NewQuorumManager(qConfig)
 
-	// Start cache servers on each node
-	go func() {
-		log.Println("[CACHE-1] Cache server running on :6001")
-		if err := cache.SetupRouter().Run(":6001"); err != nil {
-			log.Fatalf("[CACHE-1] Failed to start: %v", err)
-		}
-	}()
+	// Start cache servers on each node, memory bounds come from CACHE_* env vars
+	// and every node logs its writes under its own data dir. Each node also owns
+	// durable storage (NODE_STORAGE_ENGINE) holding the replicas it is sent
+	cacheConfig := cache.ConfigFromEnv()
+	nodeStorage := storage.NodeConfigFromEnv()
+	for i, addr := range cacheNodes {
+		nodeConfig := cacheConfig
+		nodeConfig.DataDir = filepath.Join(nodeDir(dataDir, i), "cache")
 
-	go func() {
-		log.Println("[CACHE-2] Cache server running on :6002")
-		if err := cache.SetupRouter().Run(":6002"); err != nil {
-			log.Fatalf("[CACHE-2] Failed to start: %v", err)
+		nodeStore, err := storage.Open(nodeStorage.ForNode(fmt.Sprintf("node-%d", i+1), nodeDir(dataDir, i)))
+		if err != nil {
+			log.Fatalf("Failed to open storage for %s: %v", addr, err)
 		}
-	}()
+		defer nodeStore.Close()
 
-	go func() {
-		log.Println("[CACHE-3] Cache server running on :6003")
-		if err := cache.SetupRouter().Run(":6003"); err != nil {
-			log.Fatalf("[CACHE-3] Failed to start: %v", err)
-		}
-	}()
+		go func(name, addr string, config cache.Config, store storage.Storage) {
+			router, err := cache.SetupRouter(config, store)
+			if err != nil {
+				log.Fatalf("[%s] Failed to restore: %v", name, err)
+			}
 
-	go func() {
-		log.Println("[CACHE-4] Cache server running on :6004")
-		if err := cache.SetupRouter().Run(":6004"); err != nil {
-			log.Fatalf("[CACHE-4] Failed to start: %v", err)
-		}
-	}()
+			log.Printf("[%s] Cache server running on %s", name, addr)
+			if err := router.Run(addr); err != nil {
+				log.Fatalf("[%s] Failed to start: %v", name, err)
+			}
+		}(fmt.Sprintf("CACHE-%d", i+1), addr, nodeConfig, nodeStore)
+	}
 
 	//give cache servers time to start
 	time.Sleep(2 * time.Second)
 
-	//cache client pointing to first cache node
-	//add load balancer 
-	cacheClient := cache.NewCacheClient("http://127.0.0.1:6001")
+	//cache client picks the owning cache node per key from the ring
+	cacheClient := cache.NewCacheClient(ring, "http://127.0.0.1")
+
+	//every accepted write and delete is appended here for /changes
+	changes, err := changelog.Open(changelog.ConfigFromEnv())
+	if err != nil {
+		log.Fatalf("[MAIN] Failed to open change log: %v", err)
+	}
+	defer changes.Close()
+
+	//registered webhooks with their delivery offsets and dead letters
+	hooks, err := webhook.OpenStore(webhook.ConfigFromEnv().Dir)
+	if err != nil {
+		log.Fatalf("[MAIN] Failed to open webhook store: %v", err)
+	}
 
 	// Start main coordinator server
-	log.Println("[MAIN] Main server running on :5000")
-	if err := mainserver.SetupRouter(ring, repo, qManager, cacheClient).Run(":5000"); err != nil {
+	router, err := mainserver.SetupRouter(coordinatorID, ring, repo, qManager, cacheClient, changes, hooks)
+	if err != nil {
+		log.Fatalf("[MAIN] Failed to recover: %v", err)
+	}
+	log.Printf("[MAIN] Main server running on %s", mainserver.ListenAddr)
+	if err := router.Run(mainserver.ListenAddr); err != nil {
 		log.Fatalf("[MAIN] Failed to start: %v", err)
 	}
-}
\ No newline at end of file
+}
+
+func nodeDir(dataDir string, i int) string {
+	return filepath.Join(dataDir, fmt.Sprintf("node-%d", i+1))
+}
+
+// hashTagsKey records the placement in the main store, it may be a shared
+// postgres that outlives the data dir
+const hashTagsKey = storage.SystemPrefix + "ring/hash-tags"
+
+// hashTagsFromEnv reads HASH_TAGS (off by default). Turning it on or off moves
+// every key holding a {tag} to another preference list, so the setting a cluster
+// was created with is recorded in the main store and in the data dir, and a
+// different one refuses to start. To switch, back up the cluster (kvctl backup),
+// start with an empty store and data dir and the new setting and restore into
+// it (kvctl restore), restore places keys by the new ring
+func hashTagsFromEnv(store storage.Storage, dataDir string) (bool, error) {
+	want := os.Getenv("HASH_TAGS") == "true"
+	path := filepath.Join(dataDir, "hash-tags")
+
+	inStore, storeOK, err := storage.LatestValue(store, hashTagsKey)
+	if err != nil {
+		return false, err
+	}
+	inDir, err := os.ReadFile(path)
+	dirOK := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return false, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	for _, r := range []struct {
+		where    string
+		value    string
+		recorded bool
+	}{
+		{"the main store", inStore, storeOK},
+		{"data dir " + dataDir, string(inDir), dirOK},
+	} {
+		if r.recorded && (strings.TrimSpace(r.value) == "true") != want {
+			return false, fmt.Errorf("%s was set up with HASH_TAGS=%t, changing it moves keys: back up and restore into an empty cluster instead", r.where, !want)
+		}
+	}
+
+	//a cluster from before the setting was recorded placed every key by its full name
+	if want && !storeOK && !dirOK {
+		keys, err := mainserver.NewKeyValueRepository(store).ScanKeys("", "", 1)
+		if err != nil {
+			return false, fmt.Errorf("failed to check the main store for keys: %w", err)
+		}
+		if len(keys) > 0 {
+			return false, fmt.Errorf("the main store already holds keys placed without hash tags: back up and restore into an empty cluster to turn HASH_TAGS on")
+		}
+		if _, err := os.Stat(filepath.Join(dataDir, "main", "node-id")); err == nil {
+			return false, fmt.Errorf("data dir %s already holds keys placed without hash tags: back up and restore into an empty cluster to turn HASH_TAGS on", dataDir)
+		}
+	}
+
+	setting := strconv.FormatBool(want)
+	if !storeOK {
+		if err := storage.ReplaceValue(store, hashTagsKey, setting); err != nil {
+			return false, fmt.Errorf("failed to record HASH_TAGS in the main store: %w", err)
+		}
+	}
+	if !dirOK {
+		if err := os.MkdirAll(dataDir, 0o755); err != nil {
+			return false, fmt.Errorf("failed to create %s: %w", dataDir, err)
+		}
+		if err := os.WriteFile(path, []byte(setting+"\n"), 0o644); err != nil {
+			return false, fmt.Errorf("failed to record HASH_TAGS in %s: %w", path, err)
+		}
+	}
+	return want, nil
+}
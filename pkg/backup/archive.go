@@ -0,0 +1,80 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	ManifestFile  = "manifest.json"
+	formatVersion = 1
+)
+
+// Manifest describes a finished backup, it is written last so a directory
+// without one is an interrupted backup and is never restored
+type Manifest struct {
+	Version   int             `json:"version"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Source    string          `json:"source"`
+	Ranges    []RangeManifest `json:"ranges"`
+}
+
+// RangeManifest is one token range of the source ring and the file holding it
+type RangeManifest struct {
+	Start    int      `json:"start"`
+	End      int      `json:"end"`
+	Owners   []string `json:"owners"`
+	ReadFrom []string `json:"readFrom"` //owners that answered, the file is the union of their versions
+	File     string   `json:"file"`
+	Keys     int      `json:"keys"`
+	Versions int      `json:"versions"`
+	SHA256   string   `json:"sha256"`
+}
+
+func rangeFile(i int) string {
+	return fmt.Sprintf("range-%05d.jsonl", i)
+}
+
+func readManifest(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest: %w", err)
+	}
+	if m.Version != formatVersion {
+		return nil, fmt.Errorf("unsupported backup format version %d", m.Version)
+	}
+	return &m, nil
+}
+
+//write to a temp file and rename, a crash never leaves half a file under the real name
+func writeFileAtomic(path string, write func(f *os.File) error) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,157 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rupeshx80/consistent-hashing/pkg/cache"
+	"github.com/rupeshx80/consistent-hashing/pkg/hash-ring"
+)
+
+type Config struct {
+	Server    string //main coordinator, e.g. http://127.0.0.1:5000
+	NodeHost  string //prefix of the node addresses the ring reports, e.g. http://127.0.0.1
+	Dir       string
+	MinOwners int //owners of a range that must answer, R keeps every acknowledged write in the archive
+}
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// Backup walks every token range of the ring and writes one file per range
+// holding all versions its owners store, siblings, clocks and tombstones included.
+// Each range is read from its owners in one pass so it is consistent on its own,
+// ranges are not consistent with each other
+func Backup(cfg Config) (*Manifest, error) {
+	if _, err := os.Stat(filepath.Join(cfg.Dir, ManifestFile)); err == nil {
+		return nil, fmt.Errorf("%s already holds a backup", cfg.Dir)
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create backup dir: %w", err)
+	}
+
+	ranges, err := fetchRanges(cfg.Server)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[BACKUP] Backing up %d token ranges from %s into %s", len(ranges), cfg.Server, cfg.Dir)
+
+	client := cache.NewCacheClient(nil, cfg.NodeHost)
+	manifest := &Manifest{Version: formatVersion, CreatedAt: time.Now().UTC(), Source: cfg.Server}
+
+	for i, tokens := range ranges {
+		rm, err := backupRange(client, tokens, filepath.Join(cfg.Dir, rangeFile(i)), cfg.MinOwners)
+		if err != nil {
+			return nil, fmt.Errorf("range (%d, %d]: %w", tokens.Start, tokens.End, err)
+		}
+		rm.File = rangeFile(i)
+		manifest.Ranges = append(manifest.Ranges, *rm)
+		log.Printf("[BACKUP] Range %d/%d (%d, %d]: %d keys, %d versions from %v",
+			i+1, len(ranges), tokens.Start, tokens.End, rm.Keys, rm.Versions, rm.ReadFrom)
+	}
+
+	err = writeFileAtomic(filepath.Join(cfg.Dir, ManifestFile), func(f *os.File) error {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		return enc.Encode(manifest)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return manifest, nil
+}
+
+func fetchRanges(server string) ([]hashring.TokenRange, error) {
+	resp, err := httpClient.Get(server + "/ring/ranges")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ring ranges: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to read ring ranges: status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Ranges []hashring.TokenRange `json:"ranges"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to decode ring ranges: %w", err)
+	}
+	if len(body.Ranges) == 0 {
+		return nil, fmt.Errorf("ring has no nodes")
+	}
+	return body.Ranges, nil
+}
+
+//merges the versions of every owner that answers, a version two owners hold is kept once
+func backupRange(client *cache.CacheClient, tokens hashring.TokenRange, path string, minOwners int) (*RangeManifest, error) {
+	versions := make(map[string][]cache.RangeEntry)
+	seen := make(map[string]bool)
+	rm := &RangeManifest{Start: tokens.Start, End: tokens.End, Owners: tokens.Owners}
+
+	for _, owner := range tokens.Owners {
+		var fromOwner []cache.RangeEntry
+		err := client.ScanRange(owner, tokens, func(e cache.RangeEntry) error {
+			fromOwner = append(fromOwner, e)
+			return nil
+		})
+		if err != nil {
+			log.Printf("[BACKUP] Skipping owner %s of range (%d, %d]: %v", owner, tokens.Start, tokens.End, err)
+			continue
+		}
+
+		rm.ReadFrom = append(rm.ReadFrom, owner)
+		for _, e := range fromOwner {
+			id := strings.Join([]string{e.Key, e.VectorClock, e.Value, fmt.Sprint(e.Tombstone)}, "\x00")
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			versions[e.Key] = append(versions[e.Key], e)
+		}
+	}
+
+	if len(rm.ReadFrom) < minOwners {
+		return nil, fmt.Errorf("only %d of %d owners answered, need %d", len(rm.ReadFrom), len(tokens.Owners), minOwners)
+	}
+
+	keys := make([]string, 0, len(versions))
+	for key := range versions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	err := writeFileAtomic(path, func(f *os.File) error {
+		enc := json.NewEncoder(io.MultiWriter(f, h))
+		for _, key := range keys {
+			//oldest first, restore replays them in this order
+			vs := versions[key]
+			sort.SliceStable(vs, func(a, b int) bool { return vs[a].CreatedAt.Before(vs[b].CreatedAt) })
+			for _, e := range vs {
+				if err := enc.Encode(e); err != nil {
+					return err
+				}
+				rm.Versions++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	rm.Keys = len(keys)
+	rm.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return rm, nil
+}
@@ -0,0 +1,161 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/rupeshx80/consistent-hashing/pkg/mainserver"
+)
+
+const DefaultRestoreBatch = 500
+
+// RestoreStats counts what a restore sent, Failed versions were logged one by one
+type RestoreStats struct {
+	Ranges   int
+	Versions int
+	Failed   int
+}
+
+// Restore replays a backup into the cluster behind server. Versions go through
+// /ingest and so through the target's ring, its topology doesn't have to match
+// the source. Clocks are kept so siblings stay siblings and tombstones keep
+// shadowing what they deleted. secret is the cluster's ADMIN_SECRET, /ingest
+// refuses requests without it
+func Restore(server, secret, dir string, batchSize int) (RestoreStats, error) {
+	var stats RestoreStats
+	if batchSize <= 0 || batchSize > mainserver.MaxBatchSize {
+		batchSize = DefaultRestoreBatch
+	}
+
+	manifest, err := readManifest(dir)
+	if err != nil {
+		return stats, err
+	}
+
+	//check every file before writing anything, a corrupt archive restores nothing
+	for _, rm := range manifest.Ranges {
+		if err := verifyRange(dir, rm); err != nil {
+			return stats, err
+		}
+	}
+
+	log.Printf("[RESTORE] Restoring %d ranges from %s (taken %s) into %s", len(manifest.Ranges), dir, manifest.CreatedAt, server)
+	for _, rm := range manifest.Ranges {
+		sent, failed, err := restoreRange(server, secret, filepath.Join(dir, rm.File), batchSize)
+		stats.Versions += sent
+		stats.Failed += failed
+		if err != nil {
+			return stats, fmt.Errorf("%s: %w", rm.File, err)
+		}
+		stats.Ranges++
+		log.Printf("[RESTORE] %s: %d versions, %d failed", rm.File, sent, failed)
+	}
+	return stats, nil
+}
+
+func verifyRange(dir string, rm RangeManifest) error {
+	f, err := os.Open(filepath.Join(dir, rm.File))
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", rm.File, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return fmt.Errorf("failed to read %s: %w", rm.File, err)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != rm.SHA256 {
+		return fmt.Errorf("%s is corrupt: checksum %s, manifest has %s", rm.File, sum, rm.SHA256)
+	}
+	return nil
+}
+
+func restoreRange(server, secret, path string, batchSize int) (sent, failed int, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	batch := make([]mainserver.IngestItem, 0, batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		n, err := Ingest(server, secret, batch)
+		sent += len(batch)
+		failed += n
+		batch = batch[:0]
+		return err
+	}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var item mainserver.IngestItem
+		if err := json.Unmarshal(scanner.Bytes(), &item); err != nil {
+			return sent, failed, fmt.Errorf("failed to decode version: %w", err)
+		}
+		batch = append(batch, item)
+		if len(batch) == batchSize {
+			if err := flush(); err != nil {
+				return sent, failed, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return sent, failed, err
+	}
+	return sent, failed, flush()
+}
+
+// Ingest posts one batch of raw versions and returns how many of them the
+// cluster rejected, each rejection is logged with its key
+func Ingest(server, secret string, items []mainserver.IngestItem) (int, error) {
+	payload, err := json.Marshal(map[string]interface{}{"items": items})
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal batch: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, server+"/ingest", bytes.NewReader(payload))
+	if err != nil {
+		return 0, fmt.Errorf("ingest failed: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(mainserver.AdminSecretHeader, secret)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("ingest failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("ingest failed with status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Results []mainserver.BatchResult `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return 0, fmt.Errorf("failed to decode ingest response: %w", err)
+	}
+
+	failed := 0
+	for _, r := range body.Results {
+		if r.Error != "" {
+			failed++
+			log.Printf("[INGEST] Key='%s' not restored: %s", r.Key, r.Error)
+		}
+	}
+	return failed, nil
+}
@@ -0,0 +1,155 @@
+package bulk
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const DefaultChunkSize = 5000
+
+var httpClient = &http.Client{Timeout: 5 * time.Minute}
+
+// Progress is called after every chunk with the totals so far
+type Progress func(report Report)
+
+// Import parses r locally and posts it to server in chunks of chunkSize records.
+// Every chunk travels as JSON Lines so the lines in the report are the lines of r
+func Import(server, format string, r io.Reader, chunkSize int, progress Progress) (*Report, error) {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	reader, err := NewReader(format, r)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{Errors: []ImportError{}}
+	var chunk []Record
+	var lines []int
+
+	flush := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		if err := postChunk(server, chunk, lines, report); err != nil {
+			return err
+		}
+		chunk, lines = chunk[:0], lines[:0]
+		if progress != nil {
+			progress(*report)
+		}
+		return nil
+	}
+
+	for {
+		rec, line, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		var lineErr *LineError
+		if errors.As(err, &lineErr) {
+			report.Lines++
+			report.AddError(ImportError{Line: line, Error: lineErr.Err.Error()})
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+
+		chunk = append(chunk, rec)
+		lines = append(lines, line)
+		if len(chunk) == chunkSize {
+			if err := flush(); err != nil {
+				return report, err
+			}
+		}
+	}
+	err = flush()
+
+	//parse errors are found here before the server reports its own, list them in file order
+	sort.SliceStable(report.Errors, func(a, b int) bool { return report.Errors[a].Line < report.Errors[b].Line })
+	return report, err
+}
+
+func postChunk(server string, chunk []Record, lines []int, report *Report) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, rec := range chunk {
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+
+	resp, err := httpClient.Post(server+"/import?format="+FormatJSONL, "application/x-ndjson", &body)
+	if err != nil {
+		return fmt.Errorf("import failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("import failed with status %d: %s", resp.StatusCode, msg)
+	}
+
+	var chunkReport Report
+	if err := json.NewDecoder(resp.Body).Decode(&chunkReport); err != nil {
+		return fmt.Errorf("failed to decode import report: %w", err)
+	}
+
+	//the server numbers the lines of the chunk, one record each
+	report.Lines += chunkReport.Lines
+	report.Stored += chunkReport.Stored
+	for _, e := range chunkReport.Errors {
+		if e.Line >= 1 && e.Line <= len(lines) {
+			e.Line = lines[e.Line-1]
+		}
+		report.AddError(e)
+	}
+	//failures past the server's error cap are counted but not listed
+	if missing := chunkReport.Failed - len(chunkReport.Errors); missing > 0 {
+		report.Failed += missing
+		report.ErrorsTruncated = true
+	}
+	return nil
+}
+
+// Export copies the server's dump of prefix into w and returns how many
+// versions it holds, a dump that broke off midway is an error
+func Export(server, format, prefix string, w io.Writer) (int, error) {
+	query := url.Values{}
+	query.Set("format", format)
+	query.Set("prefix", prefix)
+
+	//no client timeout, an export of a big keyspace runs as long as it needs
+	resp, err := http.Get(server + "/export?" + query.Encode())
+	if err != nil {
+		return 0, fmt.Errorf("export failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return 0, fmt.Errorf("export failed with status %d: %s", resp.StatusCode, msg)
+	}
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return 0, fmt.Errorf("export interrupted: %w", err)
+	}
+
+	//trailers are only there once the body was read to the end
+	count, _ := strconv.Atoi(resp.Trailer.Get("X-Export-Count"))
+	if msg := resp.Trailer.Get("X-Export-Error"); msg != "" {
+		return count, fmt.Errorf("export failed after %d versions: %s", count, msg)
+	}
+	return count, nil
+}
@@ -0,0 +1,240 @@
+package bulk
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	FormatJSONL = "jsonl"
+	FormatCSV   = "csv"
+)
+
+// Record is one line of an import or export. A record with a vector clock is
+// stored as that exact version, one without is a plain write that gets a new clock
+type Record struct {
+	Key         string `json:"key"`
+	Value       string `json:"value"`
+	VectorClock string `json:"vectorClock,omitempty"`
+	TTL         string `json:"ttl,omitempty"`
+	ExpiresAt   string `json:"expiresAt,omitempty"`
+	Tombstone   bool   `json:"tombstone,omitempty"`
+}
+
+//columns an exported csv has, an import only needs key
+var csvColumns = []string{"key", "value", "vectorClock", "ttl", "expiresAt", "tombstone"}
+
+// LineError is a line that could not be parsed, the reader moves on past it
+type LineError struct {
+	Line int
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+func ParseFormat(s string) (string, error) {
+	switch strings.ToLower(s) {
+	case "", FormatJSONL, "json", "ndjson":
+		return FormatJSONL, nil
+	case FormatCSV:
+		return FormatCSV, nil
+	}
+	return "", fmt.Errorf("unsupported format %q, use jsonl or csv", s)
+}
+
+// FormatFromPath picks csv for .csv files and jsonl for everything else
+func FormatFromPath(path string) string {
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return FormatCSV
+	}
+	return FormatJSONL
+}
+
+// Reader streams records, Read reports the line each record started on
+type Reader struct {
+	format  string
+	lines   *bufio.Scanner
+	csv     *csv.Reader
+	columns map[string]int
+	line    int
+}
+
+// NewReader reads the header right away for csv
+func NewReader(format string, r io.Reader) (*Reader, error) {
+	rd := &Reader{format: format}
+
+	if format != FormatCSV {
+		rd.lines = bufio.NewScanner(r)
+		rd.lines.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+		return rd, nil
+	}
+
+	rd.csv = csv.NewReader(r)
+	rd.csv.FieldsPerRecord = -1
+	header, err := rd.csv.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("csv is empty, a header line is required")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read csv header: %w", err)
+	}
+
+	rd.columns = make(map[string]int, len(header))
+	for i, name := range header {
+		rd.columns[strings.TrimSpace(name)] = i
+	}
+	if _, ok := rd.columns["key"]; !ok {
+		return nil, fmt.Errorf("csv header has no key column")
+	}
+	return rd, nil
+}
+
+// Read returns io.EOF at the end and a *LineError for a line it skipped
+func (r *Reader) Read() (Record, int, error) {
+	if r.csv != nil {
+		return r.readCSV()
+	}
+
+	for r.lines.Scan() {
+		r.line++
+		text := strings.TrimSpace(r.lines.Text())
+		if text == "" {
+			continue
+		}
+
+		var rec Record
+		if err := json.Unmarshal([]byte(text), &rec); err != nil {
+			return Record{}, r.line, &LineError{Line: r.line, Err: err}
+		}
+		return rec, r.line, nil
+	}
+	if err := r.lines.Err(); err != nil {
+		return Record{}, r.line, err
+	}
+	return Record{}, r.line, io.EOF
+}
+
+func (r *Reader) readCSV() (Record, int, error) {
+	fields, err := r.csv.Read()
+	if err == io.EOF {
+		return Record{}, r.line, io.EOF
+	}
+
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		r.line = parseErr.Line
+		return Record{}, parseErr.Line, &LineError{Line: parseErr.Line, Err: parseErr.Err}
+	}
+	if err != nil {
+		return Record{}, r.line, err
+	}
+
+	//a quoted value may span lines, the record starts where its first field does
+	line, _ := r.csv.FieldPos(0)
+	r.line = line
+
+	column := func(name string) string {
+		if i, ok := r.columns[name]; ok && i < len(fields) {
+			return fields[i]
+		}
+		return ""
+	}
+
+	rec := Record{
+		Key:         column("key"),
+		Value:       column("value"),
+		VectorClock: column("vectorClock"),
+		TTL:         column("ttl"),
+		ExpiresAt:   column("expiresAt"),
+	}
+	if v := column("tombstone"); v != "" {
+		if rec.Tombstone, err = strconv.ParseBool(v); err != nil {
+			return Record{}, line, &LineError{Line: line, Err: fmt.Errorf("invalid tombstone %q", v)}
+		}
+	}
+	return rec, line, nil
+}
+
+// Writer writes records in either format, Flush must be called at the end
+type Writer struct {
+	json *json.Encoder
+	csv  *csv.Writer
+	buf  *bufio.Writer
+}
+
+// NewWriter writes the csv header right away
+func NewWriter(format string, w io.Writer) (*Writer, error) {
+	buf := bufio.NewWriter(w)
+	if format != FormatCSV {
+		return &Writer{json: json.NewEncoder(buf), buf: buf}, nil
+	}
+
+	cw := csv.NewWriter(buf)
+	if err := cw.Write(csvColumns); err != nil {
+		return nil, err
+	}
+	return &Writer{csv: cw, buf: buf}, nil
+}
+
+func (w *Writer) Write(rec Record) error {
+	if w.csv == nil {
+		return w.json.Encode(rec)
+	}
+
+	tombstone := ""
+	if rec.Tombstone {
+		tombstone = "true"
+	}
+	return w.csv.Write([]string{rec.Key, rec.Value, rec.VectorClock, rec.TTL, rec.ExpiresAt, tombstone})
+}
+
+func (w *Writer) Flush() error {
+	if w.csv != nil {
+		w.csv.Flush()
+		if err := w.csv.Error(); err != nil {
+			return err
+		}
+	}
+	return w.buf.Flush()
+}
+
+// ImportError is a record that was not stored, Line counts from 1
+type ImportError struct {
+	Line  int    `json:"line"`
+	Key   string `json:"key,omitempty"`
+	Error string `json:"error"`
+}
+
+// Report is the outcome of an import, only the first MaxReportedErrors
+// errors are listed but every failure is counted
+type Report struct {
+	Lines           int           `json:"lines"`
+	Stored          int           `json:"stored"`
+	Failed          int           `json:"failed"`
+	Errors          []ImportError `json:"errors"`
+	ErrorsTruncated bool          `json:"errorsTruncated,omitempty"`
+}
+
+const MaxReportedErrors = 1000
+
+func (r *Report) AddError(e ImportError) {
+	r.Failed++
+	if len(r.Errors) >= MaxReportedErrors {
+		r.ErrorsTruncated = true
+		return
+	}
+	r.Errors = append(r.Errors, e)
+}
@@ -7,28 +7,53 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/rupeshx80/consistent-hashing/pkg/hash-ring"
 )
 
+// CacheClient routes every key to the cache nodes that own it on the hash ring,
+// the preference list order is the fallback order when a node is down
 type CacheClient struct {
-	baseURL string
+	ring *hashring.HashRing
+	host string
 }
 
-func NewCacheClient(baseURL string) *CacheClient {
+func NewCacheClient(ring *hashring.HashRing, host string) *CacheClient {
 	return &CacheClient{
-		baseURL: baseURL,
+		ring: ring,
+		host: host,
+	}
+}
+
+func (c *CacheClient) owners(key string) []string {
+	if c.ring == nil {
+		return nil
 	}
+
+	nodes := c.ring.GetPreferenceList(key)
+	urls := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		urls = append(urls, c.host+n)
+	}
+	return urls
 }
 
-func (c *CacheClient) WriteToCache(key, value, vectorClock string) error {
+func (c *CacheClient) WriteToCache(key, value, vectorClock, expiresAt string, tombstone bool) error {
 
-	if c.baseURL == "" {
+	owners := c.owners(key)
+	if len(owners) == 0 {
 		return nil 
 	}
 
-	payload := map[string]string{	
+	payload := map[string]interface{}{
 		"key":         key,
 		"value":       value,
 		"vectorClock": vectorClock,
+		"expiresAt":   expiresAt,
+		"tombstone":   tombstone,
 	}
 
 	jsonData, err := json.Marshal(payload)
@@ -37,7 +62,20 @@ func (c *CacheClient) WriteToCache(key, value, vectorClock string) error {
 		return fmt.Errorf("failed to marshal cache payload: %w", err)
 	}
 
-	resp, err := http.Post(c.baseURL+"/set", "application/json", bytes.NewBuffer(jsonData))
+	var lastErr error
+	for i, baseURL := range owners {
+		if lastErr = c.writeTo(baseURL, jsonData); lastErr == nil {
+			log.Printf("[CACHE-CLIENT] Successfully wrote key='%s' to cache %s (owner #%d)", key, baseURL, i+1)
+			return nil
+		}
+		log.Printf("[CACHE-CLIENT] Falling back to next owner for key='%s' after %s failed", key, baseURL)
+	}
+
+	return lastErr
+}
+
+func (c *CacheClient) writeTo(baseURL string, jsonData []byte) error {
+	resp, err := http.Post(baseURL+"/set", "application/json", bytes.NewBuffer(jsonData))
 
 	if err != nil {
 		log.Printf("[CACHE-CLIENT] Warning: failed to write to cache: %v", err)
@@ -50,18 +88,33 @@ func (c *CacheClient) WriteToCache(key, value, vectorClock string) error {
 		log.Printf("[CACHE-CLIENT] Warning: cache returned status %d, body: %s", resp.StatusCode, string(body))
 		return fmt.Errorf("cache write failed with status %d", resp.StatusCode)
 	}
-
-	log.Printf("[CACHE-CLIENT] Successfully wrote key='%s' to cache", key)
 	return nil
 }
 
+// ReadFromCache asks the owners in order, a miss on the first owner still falls
+// through since a write may have landed on the next one while it was down
 func (c *CacheClient) ReadFromCache(key string) ([]CacheVersionedValue, error) {
 
-	if c.baseURL == "" {
+	owners := c.owners(key)
+	if len(owners) == 0 {
 		return nil, fmt.Errorf("cache not configured")
 	}
 
-	resp, err := http.Get(c.baseURL + "/get/" + key)
+	var lastErr error
+	for _, baseURL := range owners {
+		versions, err := c.readFrom(baseURL, key)
+		if err == nil {
+			log.Printf("[CACHE-CLIENT] Cache HIT for key='%s' on %s, found %d versions", key, baseURL, len(versions))
+			return versions, nil
+		}
+		lastErr = err
+	}
+
+	return nil, lastErr
+}
+
+func (c *CacheClient) readFrom(baseURL, key string) ([]CacheVersionedValue, error) {
+	resp, err := http.Get(baseURL + "/get/" + key)
 
 	if err != nil {
 		return nil, fmt.Errorf("cache request failed: %w", err)
@@ -84,13 +137,161 @@ func (c *CacheClient) ReadFromCache(key string) ([]CacheVersionedValue, error) {
 		return nil, fmt.Errorf("failed to unmarshal cache response: %w", err)
 	}
 
-	log.Printf("[CACHE-CLIENT] Cache HIT for key='%s', found %d versions", key, len(versions))
 	return versions, nil
 }
 
+// WriteBatchToCache writes items that share one preference list in a single request,
+// falling back through the owners like WriteToCache. Keys a node rejected are returned
+func (c *CacheClient) WriteBatchToCache(items []KeyVal) (map[string]string, error) {
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	owners := c.owners(items[0].Key)
+	if len(owners) == 0 {
+		return nil, nil
+	}
+
+	jsonData, err := json.Marshal(map[string]interface{}{"items": items})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal cache payload: %w", err)
+	}
+
+	var lastErr error
+	for i, baseURL := range owners {
+		resp, err := http.Post(baseURL+"/mset", "application/json", bytes.NewBuffer(jsonData))
+		if err != nil {
+			lastErr = err
+			log.Printf("[CACHE-CLIENT] Falling back to next owner for batch of %d keys after %s failed: %v", len(items), baseURL, err)
+			continue
+		}
+
+		var body struct {
+			Errors map[string]string `json:"errors"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || err != nil {
+			lastErr = fmt.Errorf("cache batch write failed with status %d", resp.StatusCode)
+			log.Printf("[CACHE-CLIENT] Falling back to next owner for batch of %d keys after %s failed", len(items), baseURL)
+			continue
+		}
+
+		log.Printf("[CACHE-CLIENT] Wrote batch of %d keys to cache %s (owner #%d), %d rejected", len(items), baseURL, i+1, len(body.Errors))
+		return body.Errors, nil
+	}
+
+	return nil, lastErr
+}
+
+// ScanKeys asks one node for up to limit of its keys with prefix after "after"
+func (c *CacheClient) ScanKeys(node, prefix, after string, limit int) ([]string, error) {
+	query := url.Values{}
+	query.Set("prefix", prefix)
+	query.Set("after", after)
+	query.Set("limit", strconv.Itoa(limit))
+
+	resp, err := http.Get(c.host + node + "/keys?" + query.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("key scan on %s failed: %w", node, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("key scan on %s failed with status %d", node, resp.StatusCode)
+	}
+
+	var body struct {
+		Keys []string `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to decode key scan from %s: %w", node, err)
+	}
+	return body.Keys, nil
+}
+
+// ScanRange reads the dump of one token range from node, fn sees the versions in
+// the node's storage order
+func (c *CacheClient) ScanRange(node string, tokens hashring.TokenRange, fn func(e RangeEntry) error) error {
+	query := url.Values{}
+	query.Set("start", strconv.Itoa(tokens.Start))
+	query.Set("end", strconv.Itoa(tokens.End))
+	if tokens.HashTags {
+		query.Set("hashTags", "true")
+	}
+
+	resp, err := http.Get(c.host + node + "/range?" + query.Encode())
+	if err != nil {
+		return fmt.Errorf("range scan on %s failed: %w", node, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("range scan on %s failed with status %d", node, resp.StatusCode)
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var line struct {
+			RangeEntry
+			Error string `json:"error"`
+		}
+		if err := dec.Decode(&line); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to decode range scan from %s: %w", node, err)
+		}
+
+		if line.Error != "" {
+			return fmt.Errorf("range scan on %s failed: %s", node, line.Error)
+		}
+		if err := fn(line.RangeEntry); err != nil {
+			return err
+		}
+	}
+}
+
+// SyncPoint is the oldest "last write" across the cache nodes, ok is false when
+// a node is unreachable or came up empty, then only a full rehydration is safe
+func (c *CacheClient) SyncPoint() (time.Time, bool) {
+	if c.ring == nil {
+		return time.Time{}, false
+	}
+
+	var oldest time.Time
+	for _, node := range c.ring.Nodes() {
+		resp, err := http.Get(c.host + node + "/sync-point")
+		if err != nil {
+			log.Printf("[CACHE-CLIENT] Sync point unavailable on %s: %v", node, err)
+			return time.Time{}, false
+		}
+
+		var body struct {
+			LastWrite string `json:"lastWrite"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if err != nil || body.LastWrite == "" {
+			return time.Time{}, false
+		}
+
+		lastWrite, err := time.Parse(time.RFC3339Nano, body.LastWrite)
+		if err != nil {
+			return time.Time{}, false
+		}
+		if oldest.IsZero() || lastWrite.Before(oldest) {
+			oldest = lastWrite
+		}
+	}
+
+	return oldest, !oldest.IsZero()
+}
+
 type CacheVersionedValue struct {
 	Value       string `json:"value"`
 	VectorClock string `json:"vectorClock"`
 	CreatedAt   string `json:"createdAt"`
+	ExpiresAt   string `json:"expiresAt,omitempty"`
+	Tombstone   bool   `json:"tombstone,omitempty"`
 }
 
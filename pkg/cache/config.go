@@ -0,0 +1,62 @@
+package cache
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config bounds a cache node's memory, zero limits mean unbounded
+type Config struct {
+	MaxEntries     int
+	MaxBytes       int64
+	EvictionPolicy string // lru, lfu or wtinylfu
+
+	DataDir          string //where the wal and snapshots live, empty keeps the node in memory only
+	SnapshotInterval time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{EvictionPolicy: PolicyLRU, SnapshotInterval: 5 * time.Minute}
+}
+
+// ConfigFromEnv reads CACHE_MAX_ENTRIES, CACHE_MAX_BYTES, CACHE_EVICTION_POLICY
+// and CACHE_SNAPSHOT_INTERVAL, DataDir is per node so the caller sets it
+func ConfigFromEnv() Config {
+	cfg := DefaultConfig()
+
+	if v := os.Getenv("CACHE_MAX_ENTRIES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			log.Printf("[CACHE-CONFIG] Ignoring invalid CACHE_MAX_ENTRIES=%s", v)
+		} else {
+			cfg.MaxEntries = n
+		}
+	}
+
+	if v := os.Getenv("CACHE_MAX_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			log.Printf("[CACHE-CONFIG] Ignoring invalid CACHE_MAX_BYTES=%s", v)
+		} else {
+			cfg.MaxBytes = n
+		}
+	}
+
+	if v := os.Getenv("CACHE_EVICTION_POLICY"); v != "" {
+		cfg.EvictionPolicy = strings.ToLower(v)
+	}
+
+	if v := os.Getenv("CACHE_SNAPSHOT_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Printf("[CACHE-CONFIG] Ignoring invalid CACHE_SNAPSHOT_INTERVAL=%s", v)
+		} else {
+			cfg.SnapshotInterval = d
+		}
+	}
+
+	return cfg
+}
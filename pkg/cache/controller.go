@@ -1,9 +1,14 @@
 package cache
 
 import (
+	"encoding/json"
 	"net/http"
+	"strconv"
+	"time"
 	"log"
 	"github.com/gin-gonic/gin"
+	"github.com/rupeshx80/consistent-hashing/pkg/hash-ring"
+	"github.com/rupeshx80/consistent-hashing/pkg/model"
 )
 
 type CacheController struct {
@@ -14,6 +19,8 @@ type KeyVal struct {
 	Key         string `json:"key"`
 	Value       string `json:"value"`
 	VectorClock string `json:"vectorClock"`
+	ExpiresAt   string `json:"expiresAt"`
+	Tombstone   bool   `json:"tombstone"`
 }
 
 func NewCacheController(service *CacheService) *CacheController {
@@ -35,7 +42,16 @@ func (cc *CacheController) Set(ctx *gin.Context) {
 		return
 	}
 	
-	cc.service.SetKey(req.Key, req.Value, req.VectorClock)
+	expiresAt, err := model.ParseExpiry(req.ExpiresAt)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := cc.service.SetKey(req.Key, req.Value, req.VectorClock, expiresAt, req.Tombstone); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
 	log.Printf("[CACHE-CONTROLLER] Stored key='%s' value='%s' vc='%s'", req.Key, req.Value, req.VectorClock)
 	ctx.JSON(http.StatusOK, gin.H{"message": "stored successfully"})
@@ -60,24 +76,86 @@ func (cc *CacheController) Get(ctx *gin.Context) {
 		return
 	}
 	
-	//deduplicate by vector clock - keep only the first occurrence of each vector clock
-	seenVectorClocks := make(map[string]bool)
-	var uniqueVersions []map[string]string
-	
+	uniqueVersions := formatVersions(versions)
+	ctx.JSON(http.StatusOK, uniqueVersions)
+	log.Printf("[CACHE-CONTROLLER] Returned %d unique versions (from %d total) for key='%s'", 
+		len(uniqueVersions), len(versions), key)
+}
+
+//deduplicate by vector clock and value - keep only the first occurrence of each version
+func formatVersions(versions []VersionedValue) []map[string]interface{} {
+	seenVersions := make(map[string]bool)
+	var uniqueVersions []map[string]interface{}
+
 	for _, v := range versions {
-		if !seenVectorClocks[v.VectorClock] {
-			seenVectorClocks[v.VectorClock] = true
-			uniqueVersions = append(uniqueVersions, map[string]string{
+		id := v.VectorClock + "|" + v.Value + "|" + strconv.FormatBool(v.Tombstone)
+		if !seenVersions[id] {
+			seenVersions[id] = true
+			uniqueVersions = append(uniqueVersions, map[string]interface{}{
 				"value":       v.Value,
 				"vectorClock": v.VectorClock,
 				"createdAt":   v.CreatedAt.Format("2006-01-02 15:04:05.999999999 -0700 MST"),
+				"expiresAt":   model.FormatExpiry(v.ExpiresAt),
+				"tombstone":   v.Tombstone,
 			})
 		}
 	}
-	
-	ctx.JSON(http.StatusOK, uniqueVersions)
-	log.Printf("[CACHE-CONTROLLER] Returned %d unique versions (from %d total) for key='%s'", 
-		len(uniqueVersions), len(versions), key)
+	return uniqueVersions
+}
+
+type batchGetRequest struct {
+	Keys []string `json:"keys"`
+}
+
+type batchSetRequest struct {
+	Items []KeyVal `json:"items"`
+}
+
+// MGet returns the versions of every requested key the node holds, missing keys are left out
+func (cc *CacheController) MGet(ctx *gin.Context) {
+	var req batchGetRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	results := make(map[string][]map[string]interface{}, len(req.Keys))
+	for _, key := range req.Keys {
+		versions, err := cc.service.GetAllVersions(key)
+		if err != nil || len(versions) == 0 {
+			continue
+		}
+		results[key] = formatVersions(versions)
+	}
+
+	log.Printf("[CACHE-CONTROLLER] Batch get found %d of %d keys", len(results), len(req.Keys))
+	ctx.JSON(http.StatusOK, gin.H{"results": results})
+}
+
+// MSet stores every item and reports the keys that failed, one bad item doesn't fail the batch
+func (cc *CacheController) MSet(ctx *gin.Context) {
+	var req batchSetRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	errs := make(map[string]string)
+	for _, item := range req.Items {
+		if item.Key == "" {
+			continue
+		}
+		expiresAt, err := model.ParseExpiry(item.ExpiresAt)
+		if err == nil {
+			err = cc.service.SetKey(item.Key, item.Value, item.VectorClock, expiresAt, item.Tombstone)
+		}
+		if err != nil {
+			errs[item.Key] = err.Error()
+		}
+	}
+
+	log.Printf("[CACHE-CONTROLLER] Batch set stored %d of %d items", len(req.Items)-len(errs), len(req.Items))
+	ctx.JSON(http.StatusOK, gin.H{"errors": errs})
 }
 
 func (cc *CacheController) Delete(ctx *gin.Context) {
@@ -88,6 +166,75 @@ func (cc *CacheController) Delete(ctx *gin.Context) {
 		return
 	}
 
-	cc.service.DeleteKey(key)
+	if err := cc.service.DeleteKey(key); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 	ctx.JSON(http.StatusOK, gin.H{"message": "deleted successfully"})
 }
+
+// Keys lists the keys this node holds, the coordinator merges the answers of every node
+func (cc *CacheController) Keys(ctx *gin.Context) {
+	limit := 0
+	if v := ctx.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+		limit = n
+	}
+
+	keys, err := cc.service.ScanKeys(ctx.Query("prefix"), ctx.Query("after"), limit)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if keys == nil {
+		keys = []string{}
+	}
+	ctx.JSON(http.StatusOK, gin.H{"keys": keys})
+}
+
+// Range streams every version in the token range (start, end] as JSON lines,
+// backups read each range from its owners this way
+func (cc *CacheController) Range(ctx *gin.Context) {
+	start, errStart := strconv.Atoi(ctx.Query("start"))
+	end, errEnd := strconv.Atoi(ctx.Query("end"))
+	if errStart != nil || errEnd != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "start and end tokens are required"})
+		return
+	}
+
+	ctx.Header("Content-Type", "application/x-ndjson")
+	ctx.Status(http.StatusOK)
+
+	enc := json.NewEncoder(ctx.Writer)
+	count := 0
+	tokens := hashring.TokenRange{Start: start, End: end, HashTags: ctx.Query("hashTags") == "true"}
+	err := cc.service.ScanRange(tokens, func(e RangeEntry) error {
+		count++
+		return enc.Encode(e)
+	})
+	if err != nil {
+		//headers are gone already, a line the client can't parse marks the dump as broken
+		log.Printf("[CACHE-CONTROLLER] Range (%d, %d] scan failed after %d versions: %v", start, end, count, err)
+		enc.Encode(gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("[CACHE-CONTROLLER] Streamed %d versions of range (%d, %d]", count, start, end)
+}
+
+func (cc *CacheController) Stats(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, cc.service.Stats())
+}
+
+// SyncPoint tells the coordinator how far this node got before it went down
+func (cc *CacheController) SyncPoint(ctx *gin.Context) {
+	lastWrite := cc.service.LastWrite()
+	if lastWrite.IsZero() {
+		ctx.JSON(http.StatusOK, gin.H{"lastWrite": ""})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"lastWrite": lastWrite.UTC().Format(time.RFC3339Nano)})
+}
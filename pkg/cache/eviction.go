@@ -0,0 +1,158 @@
+package cache
+
+import (
+	"container/list"
+	"log"
+)
+
+const (
+	PolicyLRU      = "lru"
+	PolicyLFU      = "lfu"
+	PolicyWTinyLFU = "wtinylfu"
+)
+
+// EvictionPolicy tracks keys (not versions) and picks which one to drop
+// when the repository is over its limits, callers hold the repository lock.
+// Reads call Access under the read lock and serialize on accessMu instead
+type EvictionPolicy interface {
+	Add(key string)
+	Access(key string)
+	Remove(key string)
+	Victim() (string, bool)
+}
+
+func NewEvictionPolicy(name string) EvictionPolicy {
+	switch name {
+	case PolicyLFU:
+		return newLFUPolicy()
+	case PolicyWTinyLFU:
+		return newWTinyLFUPolicy()
+	case PolicyLRU, "":
+		return newLRUPolicy()
+	}
+	log.Printf("[CACHE-EVICTION] Unknown policy '%s', falling back to lru", name)
+	return newLRUPolicy()
+}
+
+type lruPolicy struct {
+	order *list.List
+	items map[string]*list.Element
+}
+
+func newLRUPolicy() *lruPolicy {
+	return &lruPolicy{order: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (p *lruPolicy) Add(key string) {
+	if el, ok := p.items[key]; ok {
+		p.order.MoveToFront(el)
+		return
+	}
+	p.items[key] = p.order.PushFront(key)
+}
+
+func (p *lruPolicy) Access(key string) {
+	if el, ok := p.items[key]; ok {
+		p.order.MoveToFront(el)
+	}
+}
+
+func (p *lruPolicy) Remove(key string) {
+	if el, ok := p.items[key]; ok {
+		p.order.Remove(el)
+		delete(p.items, key)
+	}
+}
+
+func (p *lruPolicy) Victim() (string, bool) {
+	el := p.order.Back()
+	if el == nil {
+		return "", false
+	}
+	return el.Value.(string), true
+}
+
+// lfuPolicy is the O(1) frequency bucket LFU, ties inside a bucket go to the least recent key
+type lfuPolicy struct {
+	buckets map[int]*list.List
+	items   map[string]*lfuEntry
+	minFreq int
+}
+
+type lfuEntry struct {
+	freq int
+	el   *list.Element
+}
+
+func newLFUPolicy() *lfuPolicy {
+	return &lfuPolicy{buckets: make(map[int]*list.List), items: make(map[string]*lfuEntry)}
+}
+
+func (p *lfuPolicy) bucket(freq int) *list.List {
+	b, ok := p.buckets[freq]
+	if !ok {
+		b = list.New()
+		p.buckets[freq] = b
+	}
+	return b
+}
+
+func (p *lfuPolicy) Add(key string) {
+	if _, ok := p.items[key]; ok {
+		p.Access(key)
+		return
+	}
+	p.items[key] = &lfuEntry{freq: 1, el: p.bucket(1).PushFront(key)}
+	p.minFreq = 1
+}
+
+func (p *lfuPolicy) Access(key string) {
+	e, ok := p.items[key]
+	if !ok {
+		return
+	}
+
+	old := p.buckets[e.freq]
+	old.Remove(e.el)
+	if old.Len() == 0 {
+		delete(p.buckets, e.freq)
+		if p.minFreq == e.freq {
+			p.minFreq = e.freq + 1
+		}
+	}
+
+	e.freq++
+	e.el = p.bucket(e.freq).PushFront(key)
+}
+
+func (p *lfuPolicy) Remove(key string) {
+	e, ok := p.items[key]
+	if !ok {
+		return
+	}
+
+	b := p.buckets[e.freq]
+	b.Remove(e.el)
+	if b.Len() == 0 {
+		delete(p.buckets, e.freq)
+	}
+	delete(p.items, key)
+
+	//recompute lazily, removals are rare compared to accesses
+	if _, ok := p.buckets[p.minFreq]; !ok {
+		p.minFreq = 0
+		for freq := range p.buckets {
+			if p.minFreq == 0 || freq < p.minFreq {
+				p.minFreq = freq
+			}
+		}
+	}
+}
+
+func (p *lfuPolicy) Victim() (string, bool) {
+	b, ok := p.buckets[p.minFreq]
+	if !ok || b.Len() == 0 {
+		return "", false
+	}
+	return b.Back().Value.(string), true
+}
@@ -0,0 +1,343 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	walFile      = "cache.wal"
+	snapshotFile = "cache.snapshot"
+)
+
+type walRecord struct {
+	Seq         uint64     `json:"seq"`
+	Op          string     `json:"op"` // set, delete, evict or expire (purge at CreatedAt)
+	Key         string     `json:"key"`
+	Value       string     `json:"value,omitempty"`
+	VectorClock string     `json:"vectorClock,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt,omitempty"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+	Tombstone   bool       `json:"tombstone,omitempty"`
+}
+
+type snapshot struct {
+	Seq  uint64                      `json:"seq"` //last wal record included in the snapshot
+	Data map[string][]VersionedValue `json:"data"`
+}
+
+// Persistence is the cache node's append-only log plus periodic snapshots,
+// every record is fsynced before the write is acknowledged. A snapshot first
+// rotates the log to cache.wal.<seq>, writes continue in a fresh log while the
+// snapshot is written and the rotated logs go once the snapshot covers them
+type Persistence struct {
+	dir    string
+	wal    *os.File
+	seq    uint64
+	offset int64 //end of the last complete record in wal
+}
+
+func OpenPersistence(dir string) (*Persistence, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create cache data dir: %w", err)
+	}
+	return &Persistence{dir: dir}, nil
+}
+
+// Recover returns the last snapshot and the log records written after it, rotated
+// logs first. A torn record at the tail (crash mid write) is cut off and the log
+// reopened for appends
+func (p *Persistence) Recover() (map[string][]VersionedValue, []walRecord, error) {
+	snap, err := p.loadSnapshot()
+	if err != nil {
+		return nil, nil, err
+	}
+	p.seq = snap.Seq
+
+	rotated, err := p.rotatedLogs()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var records []walRecord
+	for _, r := range rotated {
+		f, err := os.Open(r.path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open rotated wal: %w", err)
+		}
+		_, err = p.readLog(f, snap.Seq, &records)
+		f.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	path := filepath.Join(p.dir, walFile)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open wal: %w", err)
+	}
+
+	goodOffset, err := p.readLog(f, snap.Seq, &records)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	if err := f.Truncate(goodOffset); err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("failed to truncate wal: %w", err)
+	}
+	if _, err := f.Seek(goodOffset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("failed to seek wal: %w", err)
+	}
+	p.wal = f
+	p.offset = goodOffset
+
+	log.Printf("[CACHE-WAL] Recovered %d keys from snapshot and %d log records in %s", len(snap.Data), len(records), p.dir)
+	return snap.Data, records, nil
+}
+
+// readLog appends the records of f newer than after to records and returns the
+// offset after the last good one, a corrupt record ends the file
+func (p *Persistence) readLog(f *os.File, after uint64, records *[]walRecord) (int64, error) {
+	var goodOffset int64
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			if line != "" {
+				log.Printf("[CACHE-WAL] Dropping torn record in %s at offset %d", f.Name(), goodOffset)
+			}
+			return goodOffset, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to read wal: %w", err)
+		}
+
+		rec, ok := decodeWALLine(line)
+		if !ok {
+			log.Printf("[CACHE-WAL] Corrupt record in %s at offset %d, ignoring the rest of the file", f.Name(), goodOffset)
+			return goodOffset, nil
+		}
+		goodOffset += int64(len(line))
+
+		//records already folded into the snapshot
+		if rec.Seq <= after {
+			continue
+		}
+		*records = append(*records, rec)
+		p.seq = rec.Seq
+	}
+}
+
+// Append writes and fsyncs one record, a failed write is cut back off so the
+// next record doesn't land behind a partial line
+func (p *Persistence) Append(rec walRecord) error {
+	rec.Seq = p.seq + 1
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal wal record: %w", err)
+	}
+
+	line := fmt.Sprintf("%08x %s\n", crc32.ChecksumIEEE(data), data)
+	if _, err := p.wal.WriteString(line); err != nil {
+		p.rollback()
+		return fmt.Errorf("failed to append wal record: %w", err)
+	}
+	if err := p.wal.Sync(); err != nil {
+		p.rollback()
+		return fmt.Errorf("failed to sync wal record: %w", err)
+	}
+
+	p.seq = rec.Seq
+	p.offset += int64(len(line))
+	return nil
+}
+
+func (p *Persistence) rollback() {
+	if err := p.wal.Truncate(p.offset); err != nil {
+		log.Printf("[CACHE-WAL] Failed to cut back a partial record: %v", err)
+	}
+	if _, err := p.wal.Seek(p.offset, io.SeekStart); err != nil {
+		log.Printf("[CACHE-WAL] Failed to seek wal: %v", err)
+	}
+}
+
+// Rotate moves the current log aside and starts an empty one, it returns the
+// sequence number a snapshot of the current state covers. Callers hold the
+// repository lock so no record is appended meanwhile
+func (p *Persistence) Rotate() (uint64, error) {
+	//nothing was logged since the last rotation
+	if p.offset == 0 {
+		return p.seq, nil
+	}
+
+	path := filepath.Join(p.dir, walFile)
+	rotated := fmt.Sprintf("%s.%016x", path, p.seq)
+
+	//the open handle follows the rename, if the new log can't be created
+	//appends keep going to the rotated file, which recovery reads as well
+	if err := os.Rename(path, rotated); err != nil {
+		return 0, fmt.Errorf("failed to rotate wal: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open wal: %w", err)
+	}
+	p.wal.Close()
+	p.wal = f
+	p.offset = 0
+
+	if err := syncDir(p.dir); err != nil {
+		return 0, err
+	}
+	return p.seq, nil
+}
+
+// Snapshot atomically writes data as of seq and then removes the rotated logs it
+// covers, if we crash in between the sequence numbers keep the replay from
+// applying records twice. It doesn't touch the live log, so it runs without the
+// repository lock
+func (p *Persistence) Snapshot(seq uint64, data map[string][]VersionedValue) error {
+	encoded, err := json.Marshal(snapshot{Seq: seq, Data: data})
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+
+	path := filepath.Join(p.dir, snapshotFile)
+	tmp := path + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot: %w", err)
+	}
+	if _, err := f.Write(encoded); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync snapshot: %w", err)
+	}
+	f.Close()
+
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to install snapshot: %w", err)
+	}
+	//the rename has to be durable before the logs it replaces are removed
+	if err := syncDir(p.dir); err != nil {
+		return err
+	}
+
+	rotated, err := p.rotatedLogs()
+	if err != nil {
+		return err
+	}
+	for _, r := range rotated {
+		if r.seq > seq {
+			continue
+		}
+		if err := os.Remove(r.path); err != nil {
+			return fmt.Errorf("failed to remove rotated wal: %w", err)
+		}
+	}
+
+	log.Printf("[CACHE-WAL] Snapshot of %d keys written at seq=%d", len(data), seq)
+	return nil
+}
+
+type rotatedLog struct {
+	path string
+	seq  uint64 //last record in the file
+}
+
+//rotatedLogs lists cache.wal.<seq> files oldest first
+func (p *Persistence) rotatedLogs() ([]rotatedLog, error) {
+	entries, err := os.ReadDir(p.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cache data dir: %w", err)
+	}
+
+	var out []rotatedLog
+	for _, e := range entries {
+		suffix, ok := strings.CutPrefix(e.Name(), walFile+".")
+		if !ok {
+			continue
+		}
+		var seq uint64
+		if _, err := fmt.Sscanf(suffix, "%016x", &seq); err != nil || len(suffix) != 16 {
+			continue
+		}
+		out = append(out, rotatedLog{path: filepath.Join(p.dir, e.Name()), seq: seq})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].seq < out[j].seq })
+	return out, nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open dir for sync: %w", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync dir: %w", err)
+	}
+	return nil
+}
+
+func (p *Persistence) Close() error {
+	if p.wal == nil {
+		return nil
+	}
+	return p.wal.Close()
+}
+
+func (p *Persistence) loadSnapshot() (*snapshot, error) {
+	snap := &snapshot{Data: make(map[string][]VersionedValue)}
+
+	data, err := os.ReadFile(filepath.Join(p.dir, snapshotFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return snap, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	if err := json.Unmarshal(data, snap); err != nil {
+		return nil, fmt.Errorf("corrupt snapshot: %w", err)
+	}
+	if snap.Data == nil {
+		snap.Data = make(map[string][]VersionedValue)
+	}
+	return snap, nil
+}
+
+func decodeWALLine(line string) (walRecord, bool) {
+	var rec walRecord
+
+	checksum, data, ok := strings.Cut(strings.TrimSuffix(line, "\n"), " ")
+	if !ok {
+		return rec, false
+	}
+	if fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(data))) != checksum {
+		return rec, false
+	}
+	if err := json.Unmarshal([]byte(data), &rec); err != nil {
+		return rec, false
+	}
+	return rec, true
+}
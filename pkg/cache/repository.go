@@ -1,57 +1,491 @@
 package cache
 
 import (
+	"log"
+	"sort"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/rupeshx80/consistent-hashing/pkg/model"
+	"github.com/rupeshx80/consistent-hashing/pkg/vclock"
 )
 
 type VersionedValue struct {
 	Value       string
 	VectorClock string
 	CreatedAt   time.Time
+	ExpiresAt   *time.Time
+	Tombstone   bool
+}
+
+//rough fixed cost of one stored version (time, string and slice headers)
+const versionOverhead = 64
+
+func versionSize(key string, v VersionedValue) int64 {
+	return int64(len(key)+len(v.Value)+len(v.VectorClock)) + versionOverhead
+}
+
+type CacheStats struct {
+	Entries   int    `json:"entries"`
+	Versions  int    `json:"versions"`
+	Bytes     int64  `json:"bytes"`
+	Evictions uint64 `json:"evictions"`
+	Expired   uint64 `json:"expired"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
 }
 
 type CacheRepository struct {
 	data map[string][]VersionedValue
 	mu   sync.RWMutex
+
+	config   Config
+	policy   EvictionPolicy
+	keyBytes map[string]int64 //all sibling versions of a key count together
+	bytes    int64
+	versions int
+	stats    CacheStats
+	accessMu sync.Mutex //policy.Access and the hit counters, reads only hold mu.RLock
+
+	persistence *Persistence //nil when the node runs memory only
+	snapshotMu  sync.Mutex   //one snapshot at a time
+	lastWrite   time.Time
 }
 
-func NewCacheRepository() *CacheRepository {
-	return &CacheRepository{data: make(map[string][]VersionedValue)}
+func NewCacheRepository(config Config) *CacheRepository {
+	return &CacheRepository{
+		data:     make(map[string][]VersionedValue),
+		config:   config,
+		policy:   NewEvictionPolicy(config.EvictionPolicy),
+		keyBytes: make(map[string]int64),
+	}
 }
 
-func (r *CacheRepository) Set(key, value string,vectorClock string) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	
-	newVersion := VersionedValue{
+// OpenCacheRepository restores the node from its data dir when one is configured
+// and keeps snapshotting it in the background
+func OpenCacheRepository(config Config) (*CacheRepository, error) {
+	r := NewCacheRepository(config)
+	if config.DataDir == "" {
+		return r, nil
+	}
+
+	persistence, err := OpenPersistence(config.DataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	data, records, err := persistence.Recover()
+	if err != nil {
+		return nil, err
+	}
+
+	for key, versions := range data {
+		for _, v := range versions {
+			r.setVersionLocked(key, v)
+		}
+	}
+	for _, rec := range records {
+		switch rec.Op {
+		case "set":
+			r.setVersionLocked(rec.Key, VersionedValue{
+				Value:       rec.Value,
+				VectorClock: rec.VectorClock,
+				CreatedAt:   rec.CreatedAt,
+				ExpiresAt:   rec.ExpiresAt,
+				Tombstone:   rec.Tombstone,
+			})
+		case "delete", "evict":
+			r.removeLocked(rec.Key)
+		case "expire":
+			r.purgeExpiredLocked(rec.Key, rec.CreatedAt)
+		}
+	}
+
+	r.persistence = persistence
+	if config.SnapshotInterval > 0 {
+		r.startSnapshots(config.SnapshotInterval)
+	}
+
+	log.Printf("[CACHE-REPO] Restored %d keys (%d versions) from %s", len(r.data), r.versions, config.DataDir)
+	return r, nil
+}
+
+func (r *CacheRepository) Set(key, value string,vectorClock string, expiresAt *time.Time) error {
+	return r.SetVersion(key, VersionedValue{
 		Value:       value,
 		VectorClock: vectorClock,
 		CreatedAt:   time.Now(),
+		ExpiresAt:   expiresAt,
+	})
+}
+
+// SetVersion stores a version stamped by the caller, the node's durable store
+// and the cache then agree on when the version was written
+func (r *CacheRepository) SetVersion(key string, newVersion VersionedValue) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.logAndSetLocked(key, newVersion)
+}
+
+// SetVersionIfResident is SetVersion for a key that is in memory, false means it
+// isn't and nothing was stored. A node with a durable store then loads the key's
+// stored versions instead, an entry built from the new version alone would hide
+// siblings the cache evicted
+func (r *CacheRepository) SetVersionIfResident(key string, newVersion VersionedValue) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.data[key]; !ok {
+		return false, nil
+	}
+	return true, r.logAndSetLocked(key, newVersion)
+}
+
+func (r *CacheRepository) logAndSetLocked(key string, newVersion VersionedValue) error {
+	//log first, memory second, so an acknowledged write is always on disk
+	if r.persistence != nil {
+		err := r.persistence.Append(walRecord{
+			Op:          "set",
+			Key:         key,
+			Value:       newVersion.Value,
+			VectorClock: newVersion.VectorClock,
+			CreatedAt:   newVersion.CreatedAt,
+			ExpiresAt:   newVersion.ExpiresAt,
+			Tombstone:   newVersion.Tombstone,
+		})
+		if err != nil {
+			return err
+		}
 	}
 	
-	r.data[key] = append(r.data[key], newVersion)
+	r.setVersionLocked(key, newVersion)
+	return nil
+}
+
+// setVersionLocked is idempotent, rehydration and log replay may deliver a
+// version the node already holds. Versions the new one supersedes are dropped
+// and a version that arrives already superseded is not kept, so a key only
+// holds its current siblings
+func (r *CacheRepository) setVersionLocked(key string, newVersion VersionedValue) {
+	before := len(r.data[key])
+	kept := make([]VersionedValue, 0, before+1)
+	var freed int64
+	for _, v := range r.data[key] {
+		if v.VectorClock == newVersion.VectorClock && v.Value == newVersion.Value && v.Tombstone == newVersion.Tombstone {
+			return
+		}
+		if vclock.Supersedes(v.VectorClock, newVersion.VectorClock) {
+			return
+		}
+		if vclock.Supersedes(newVersion.VectorClock, v.VectorClock) {
+			freed += versionSize(key, v)
+			continue
+		}
+		kept = append(kept, v)
+	}
+
+	r.data[key] = append(kept, newVersion)
+
+	size := versionSize(key, newVersion)
+	r.keyBytes[key] += size - freed
+	r.bytes += size - freed
+	r.versions += len(r.data[key]) - before
+	r.policy.Add(key)
+	if newVersion.CreatedAt.After(r.lastWrite) {
+		r.lastWrite = newVersion.CreatedAt
+	}
+
+	r.evictLocked()
+}
+
+// Load puts versions read from the node's durable store back into memory,
+// they are already on disk so nothing is logged
+func (r *CacheRepository) Load(key string, versions []VersionedValue) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, v := range versions {
+		r.setVersionLocked(key, v)
+	}
 }
 
 func (r *CacheRepository) GetAllVersions(key string) ([]VersionedValue, bool) {
+	now := time.Now()
+
 	r.mu.RLock()
-	defer r.mu.RUnlock()
-	
 	versions, ok := r.data[key]
+	if ok && hasExpired(versions, now) {
+		//lazy expiry, an expired key is purged the moment someone asks for it. Only
+		//then does a read need the write lock
+		r.mu.RUnlock()
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		r.purgeExpiredLocked(key, now)
+		versions, ok = r.data[key]
+	} else {
+		defer r.mu.RUnlock()
+	}
 
+	r.recordAccess(key, ok)
 	if !ok {
 		return nil, false
 	}
-	
+
 	//copy of the slice to prevent external mutation of the internal data
 	result := make([]VersionedValue, len(versions))
 	copy(result, versions)
 	return result, true
 }
 
-func (r *CacheRepository) Delete(key string) {
+func (r *CacheRepository) Delete(key string) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+
+	if r.persistence != nil {
+		if err := r.persistence.Append(walRecord{Op: "delete", Key: key}); err != nil {
+			return err
+		}
+	}
+	r.removeLocked(key)
+	return nil
+}
+
+//recordAccess counts the hit or miss and tells the eviction policy, readers
+//share the read lock so they serialize on accessMu
+func (r *CacheRepository) recordAccess(key string, hit bool) {
+	r.accessMu.Lock()
+	defer r.accessMu.Unlock()
+
+	if !hit {
+		r.stats.Misses++
+		return
+	}
+	r.stats.Hits++
+	r.policy.Access(key)
+}
+
+func hasExpired(versions []VersionedValue, now time.Time) bool {
+	for _, v := range versions {
+		if model.IsExpired(v.ExpiresAt, now) {
+			return true
+		}
+	}
+	return false
+}
+
+// Keys returns up to limit live keys with prefix sorted after "after", for nodes
+// without durable storage the cache is all there is to list
+func (r *CacheRepository) Keys(prefix, after string, limit int) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := time.Now()
+	var keys []string
+	for key, versions := range r.data {
+		if !strings.HasPrefix(key, prefix) || key <= after || !isLive(versions, now) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+	return keys
+}
+
+// Entries copies every key with all its versions, used to dump a memory only node
+func (r *CacheRepository) Entries() map[string][]VersionedValue {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	out := make(map[string][]VersionedValue, len(r.data))
+	for key, versions := range r.data {
+		out[key] = append([]VersionedValue(nil), versions...)
+	}
+	return out
+}
+
+// isLive is true when a version no other one on this node supersedes is neither
+// a delete nor expired. It only says the key may be live, another node can hold
+// the delete that replaced it, the coordinator decides across nodes
+func isLive(versions []VersionedValue, now time.Time) bool {
+	gone := purgeable(versions, now)
+	clocks := make([]string, len(versions))
+	for i, v := range versions {
+		clocks[i] = v.VectorClock
+	}
+
+	distinct := func(a, b int) bool { return false }
+	for i, v := range versions {
+		if !v.Tombstone && !gone[i] && !vclock.Dominated(clocks, i, distinct) {
+			return true
+		}
+	}
+	return false
+}
+
+// LastWrite is the newest version this node holds, the coordinator only
+// rehydrates what was written after it
+func (r *CacheRepository) LastWrite() time.Time {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.lastWrite
+}
+
+// Snapshot copies the data and rotates the log under the lock, the slow part,
+// encoding and syncing the copy, runs while writes go on
+func (r *CacheRepository) Snapshot() error {
+	if r.persistence == nil {
+		return nil
+	}
+	r.snapshotMu.Lock()
+	defer r.snapshotMu.Unlock()
+
+	r.mu.Lock()
+	data := make(map[string][]VersionedValue, len(r.data))
+	for key, versions := range r.data {
+		data[key] = append([]VersionedValue(nil), versions...)
+	}
+	seq, err := r.persistence.Rotate()
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return r.persistence.Snapshot(seq, data)
+}
+
+//logRemoval records an eviction or expiry so a restart doesn't bring the versions
+//back, the removal already happened in memory so a failure is only logged
+func (r *CacheRepository) logRemoval(rec walRecord) {
+	if r.persistence == nil {
+		return
+	}
+	if err := r.persistence.Append(rec); err != nil {
+		log.Printf("[CACHE-REPO] Failed to log %s of key='%s': %v", rec.Op, rec.Key, err)
+	}
+}
+
+func (r *CacheRepository) startSnapshots(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := r.Snapshot(); err != nil {
+				log.Printf("[CACHE-REPO] Snapshot failed: %v", err)
+			}
+		}
+	}()
+}
+
+func (r *CacheRepository) Stats() CacheStats {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	r.accessMu.Lock()
+	defer r.accessMu.Unlock()
+
+	stats := r.stats
+	stats.Entries = len(r.data)
+	stats.Versions = r.versions
+	stats.Bytes = r.bytes
+	return stats
+}
+
+func (r *CacheRepository) removeLocked(key string) {
+	versions, ok := r.data[key]
+	if !ok {
+		return
+	}
+
+	r.bytes -= r.keyBytes[key]
+	r.versions -= len(versions)
+	delete(r.keyBytes, key)
 	delete(r.data, key)
+	r.policy.Remove(key)
 }
 
+func (r *CacheRepository) overLimitLocked() bool {
+	if r.config.MaxEntries > 0 && len(r.data) > r.config.MaxEntries {
+		return true
+	}
+	return r.config.MaxBytes > 0 && r.bytes > r.config.MaxBytes
+}
+
+// evictLocked drops whole keys, never single versions, so a key is either
+// cached with all its siblings or not cached at all
+func (r *CacheRepository) evictLocked() {
+	for r.overLimitLocked() {
+		victim, ok := r.policy.Victim()
+		if !ok {
+			return
+		}
+
+		freed := r.keyBytes[victim]
+		r.removeLocked(victim)
+		r.logRemoval(walRecord{Op: "evict", Key: victim})
+		r.stats.Evictions++
+		log.Printf("[CACHE-REPO] Evicted key='%s' freed=%d bytes, entries=%d bytes=%d", victim, freed, len(r.data), r.bytes)
+	}
+}
+
+// purgeExpiredLocked drops expired versions of key and the versions they superseded,
+// those must not become visible again. See vclock.Purgeable
+func (r *CacheRepository) purgeExpiredLocked(key string, now time.Time) {
+	versions, ok := r.data[key]
+	if !ok || !hasExpired(versions, now) {
+		return
+	}
+
+	purge := purgeable(versions, now)
+	kept := make([]VersionedValue, 0, len(versions))
+	var freed int64
+	for i, v := range versions {
+		if purge[i] {
+			freed += versionSize(key, v)
+			continue
+		}
+		kept = append(kept, v)
+	}
+
+	dropped := len(versions) - len(kept)
+	r.stats.Expired += uint64(dropped)
+	r.logRemoval(walRecord{Op: "expire", Key: key, CreatedAt: now})
+
+	if len(kept) == 0 {
+		r.removeLocked(key)
+		log.Printf("[CACHE-REPO] Expired key='%s'", key)
+		return
+	}
+
+	r.data[key] = kept
+	r.keyBytes[key] -= freed
+	r.bytes -= freed
+	r.versions -= dropped
+	log.Printf("[CACHE-REPO] Expired %d versions of key='%s'", dropped, key)
+}
+
+func purgeable(versions []VersionedValue, now time.Time) []bool {
+	clocks := make([]string, len(versions))
+	expired := make([]bool, len(versions))
+	for i, v := range versions {
+		clocks[i] = v.VectorClock
+		expired[i] = model.IsExpired(v.ExpiresAt, now)
+	}
+	return vclock.Purgeable(clocks, expired)
+}
+
+// PurgeExpired is the active side of expiry, it walks every key
+func (r *CacheRepository) PurgeExpired() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for key := range r.data {
+		r.purgeExpiredLocked(key, now)
+	}
+}
@@ -1,18 +1,55 @@
 package cache
 
-import "github.com/gin-gonic/gin"
+import (
+	"time"
 
-func SetupRouter() *gin.Engine {
+	"github.com/gin-gonic/gin"
+	"github.com/rupeshx80/consistent-hashing/pkg/paxos"
+	"github.com/rupeshx80/consistent-hashing/pkg/storage"
+	"github.com/rupeshx80/consistent-hashing/pkg/txn"
+)
+
+// SetupRouter starts a ring node, store is the node's own durable storage
+// and may be nil for a node that only caches
+func SetupRouter(config Config, store storage.Storage) (*gin.Engine, error) {
 	r := gin.Default()
 
-	repo := NewCacheRepository()
-	
-	svc := NewCacheService(repo)
+	repo, err := OpenCacheRepository(config)
+	if err != nil {
+		return nil, err
+	}
+
+	svc := NewCacheService(repo, store)
+	svc.StartJanitor(time.Minute)
 	ctrl := NewCacheController(svc)
 
 	r.POST("/set", ctrl.Set)
 	r.GET("/get/:key", ctrl.Get)
 	r.DELETE("/delete/:key", ctrl.Delete)
+	r.GET("/keys", ctrl.Keys)
+	r.POST("/mget", ctrl.MGet)
+	r.POST("/mset", ctrl.MSet)
+	r.GET("/range", ctrl.Range)
+	r.GET("/stats", ctrl.Stats)
+	r.GET("/sync-point", ctrl.SyncPoint)
+
+	//paxos acceptor for linearizable (lwt) keys, its state is kept in the node's store
+	acceptor := paxos.NewAcceptorController(paxos.NewAcceptor(store))
+	r.POST("/paxos/prepare", acceptor.Prepare)
+	r.POST("/paxos/accept", acceptor.Accept)
+	r.POST("/paxos/commit", acceptor.Commit)
+
+	//two-phase commit participant for multi-key transactions, prepared writes are
+	//kept in the node's store and recovered with their locks
+	p, err := txn.NewParticipant(store, svc.SetKey)
+	if err != nil {
+		return nil, err
+	}
+	p.Start(5 * time.Second)
+	participant := txn.NewParticipantController(p)
+	r.POST("/txn/prepare", participant.Prepare)
+	r.POST("/txn/commit", participant.Commit)
+	r.POST("/txn/abort", participant.Abort)
 
-	return r
+	return r, nil
 }
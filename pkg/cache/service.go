@@ -3,26 +3,115 @@ package cache
 import (
 	"errors"
 	"log"
+	"sync"
+	"time"
+
+	"github.com/rupeshx80/consistent-hashing/pkg/hash-ring"
+	"github.com/rupeshx80/consistent-hashing/pkg/model"
+	"github.com/rupeshx80/consistent-hashing/pkg/storage"
 )
 
+// CacheService fronts the node's durable store with the in memory cache,
+// store is nil when the node only keeps data in memory
 type CacheService struct {
-	repo *CacheRepository
+	repo  *CacheRepository
+	store storage.Storage
+	mu    sync.Mutex //keeps the duplicate check and the disk write together
 }
 
-func NewCacheService(repo *CacheRepository) *CacheService {
+func NewCacheService(repo *CacheRepository, store storage.Storage) *CacheService {
 	return &CacheService{
-		repo: repo,
+		repo:  repo,
+		store: store,
 	}
 }
 
-func (s *CacheService) SetKey(key, value, vectorClock string) {
-	s.repo.Set(key, value, vectorClock)
+// ErrSystemKey is a client key in the range the node keeps its own state in
+var ErrSystemKey = errors.New("key is in the range reserved for node state")
+
+func (s *CacheService) SetKey(key, value, vectorClock string, expiresAt *time.Time, tombstone bool) error {
+	if storage.IsSystemKey(key) {
+		return ErrSystemKey
+	}
+
+	version := VersionedValue{
+		Value:       value,
+		VectorClock: vectorClock,
+		CreatedAt:   time.Now(),
+		ExpiresAt:   expiresAt,
+		Tombstone:   tombstone,
+	}
+
+	//the durable copy is the replica, the cache only speeds up reads
+	if s.store != nil {
+		stored, err := s.persist(key, version)
+		if err != nil {
+			log.Printf("[CACHE-SERVICE] Failed to store key='%s' on disk: %v", key, err)
+			return err
+		}
+		if !stored {
+			log.Printf("[CACHE-SERVICE] Key='%s' vc='%s' already stored, skipping", key, vectorClock)
+			return nil
+		}
+
+		//an evicted key is read back whole, the new version is on disk with its siblings
+		resident, err := s.repo.SetVersionIfResident(key, version)
+		if err != nil {
+			log.Printf("[CACHE-SERVICE] Failed to persist key='%s': %v", key, err)
+			return err
+		}
+		if !resident {
+			s.loadFromStore(key)
+		}
+		log.Printf("[CACHE-SERVICE] Stored key='%s' value='%s' vc='%s'", key, value, vectorClock)
+		return nil
+	}
+
+	if err := s.repo.SetVersion(key, version); err != nil {
+		log.Printf("[CACHE-SERVICE] Failed to persist key='%s': %v", key, err)
+		return err
+	}
 	log.Printf("[CACHE-SERVICE] Stored key='%s' value='%s' vc='%s'", key, value, vectorClock)
+	return nil
+}
+
+// persist skips versions the node already holds, the coordinator's cache write
+// and rehydration can both deliver a version a quorum write brought earlier
+func (s *CacheService) persist(key string, v VersionedValue) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, err := s.store.GetAllVersions(key)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return false, err
+	}
+	for _, kv := range existing {
+		if kv.VectorClock == v.VectorClock && kv.Value == v.Value && kv.Tombstone == v.Tombstone {
+			return false, nil
+		}
+	}
+
+	err = s.store.PutVersion(model.KeyValue{
+		Key:         key,
+		Value:       v.Value,
+		VectorClock: v.VectorClock,
+		CreatedAt:   v.CreatedAt,
+		ExpiresAt:   v.ExpiresAt,
+		Tombstone:   v.Tombstone,
+	})
+	return err == nil, err
 }
 
 func (s *CacheService) GetAllVersions(key string) ([]VersionedValue, error) {
+	if storage.IsSystemKey(key) {
+		return nil, ErrSystemKey
+	}
+
 	versions, ok := s.repo.GetAllVersions(key)
-	
+	if !ok && s.store != nil {
+		versions, ok = s.loadFromStore(key)
+	}
+
 	if !ok || len(versions) == 0 {
 		log.Printf("[CACHE-SERVICE] Key not found in cache: '%s'", key)
 		return nil, errors.New("key not found in cache")
@@ -32,7 +121,180 @@ func (s *CacheService) GetAllVersions(key string) ([]VersionedValue, error) {
 	return versions, nil
 }
 
-func (s *CacheService) DeleteKey(key string) {
-	s.repo.Delete(key)
+// loadFromStore reads a key the cache evicted or never held back from disk
+func (s *CacheService) loadFromStore(key string) ([]VersionedValue, bool) {
+	stored, err := s.store.GetAllVersions(key)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("[CACHE-SERVICE] Failed to read key='%s' from disk: %v", key, err)
+		}
+		return nil, false
+	}
+
+	all := make([]VersionedValue, 0, len(stored))
+	for _, kv := range stored {
+		all = append(all, VersionedValue{
+			Value:       kv.Value,
+			VectorClock: kv.VectorClock,
+			CreatedAt:   kv.CreatedAt,
+			ExpiresAt:   kv.ExpiresAt,
+			Tombstone:   kv.Tombstone,
+		})
+	}
+
+	//an expired write still supersedes what it replaced
+	purge := purgeable(all, time.Now())
+	versions := make([]VersionedValue, 0, len(all))
+	for i, v := range all {
+		if !purge[i] {
+			versions = append(versions, v)
+		}
+	}
+	if len(versions) == 0 {
+		return nil, false
+	}
+
+	s.repo.Load(key, versions)
+	log.Printf("[CACHE-SERVICE] Loaded %d versions of key='%s' from disk", len(versions), key)
+	return versions, true
+}
+
+// ScanKeys lists up to limit live keys with prefix after "after" in key order,
+// keys whose newest version is a tombstone are skipped
+func (s *CacheService) ScanKeys(prefix, after string, limit int) ([]string, error) {
+	if s.store == nil {
+		return s.repo.Keys(prefix, after, limit), nil
+	}
+
+	now := time.Now()
+	var keys []string
+	for {
+		batch, err := s.store.ScanKeys(prefix, after, limit)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, key := range batch {
+			if storage.IsSystemKey(key) {
+				continue
+			}
+			stored, err := s.store.GetAllVersions(key)
+			if err != nil {
+				continue
+			}
+			versions := make([]VersionedValue, 0, len(stored))
+			for _, kv := range stored {
+				versions = append(versions, VersionedValue{VectorClock: kv.VectorClock, CreatedAt: kv.CreatedAt, ExpiresAt: kv.ExpiresAt, Tombstone: kv.Tombstone})
+			}
+			if !isLive(versions, now) {
+				continue
+			}
+
+			keys = append(keys, key)
+			if limit > 0 && len(keys) == limit {
+				return keys, nil
+			}
+		}
+
+		if limit <= 0 || len(batch) < limit {
+			return keys, nil
+		}
+		after = batch[len(batch)-1]
+	}
+}
+
+// RangeEntry is one stored version of a key, the unit a token range is dumped in
+type RangeEntry struct {
+	Key         string     `json:"key"`
+	Value       string     `json:"value"`
+	VectorClock string     `json:"vectorClock"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+	Tombstone   bool       `json:"tombstone,omitempty"`
+}
+
+// ScanRange calls fn for every version, tombstones and expired ones included, of
+// every key hashing into tokens. Keys aren't stored in hash order so the whole
+// node is walked
+func (s *CacheService) ScanRange(tokens hashring.TokenRange, fn func(e RangeEntry) error) error {
+	if s.store == nil {
+		for key, versions := range s.repo.Entries() {
+			if !tokens.ContainsKey(key) {
+				continue
+			}
+			for _, v := range versions {
+				err := fn(RangeEntry{
+					Key:         key,
+					Value:       v.Value,
+					VectorClock: v.VectorClock,
+					CreatedAt:   v.CreatedAt,
+					ExpiresAt:   v.ExpiresAt,
+					Tombstone:   v.Tombstone,
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	return s.store.IterateRange("", "", func(kv model.KeyValue) error {
+		if storage.IsSystemKey(kv.Key) {
+			return nil
+		}
+		if !tokens.ContainsKey(kv.Key) {
+			return nil
+		}
+		return fn(RangeEntry{
+			Key:         kv.Key,
+			Value:       kv.Value,
+			VectorClock: kv.VectorClock,
+			CreatedAt:   kv.CreatedAt,
+			ExpiresAt:   kv.ExpiresAt,
+			Tombstone:   kv.Tombstone,
+		})
+	})
+}
+
+func (s *CacheService) DeleteKey(key string) error {
+	if s.store != nil {
+		if err := s.store.Delete(key); err != nil {
+			log.Printf("[CACHE-SERVICE] Failed to delete key='%s' on disk: %v", key, err)
+			return err
+		}
+	}
+	if err := s.repo.Delete(key); err != nil {
+		log.Printf("[CACHE-SERVICE] Failed to persist delete of key='%s': %v", key, err)
+		return err
+	}
 	log.Printf("[CACHE-SERVICE] Deleted key='%s'", key)
-}
\ No newline at end of file
+	return nil
+}
+
+// StartJanitor purges expired versions from memory and disk every interval
+func (s *CacheService) StartJanitor(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.repo.PurgeExpired()
+			if s.store == nil {
+				continue
+			}
+			if purged, err := s.store.PurgeExpired(time.Now()); err != nil {
+				log.Printf("[CACHE-SERVICE] Failed to purge expired versions on disk: %v", err)
+			} else if purged > 0 {
+				log.Printf("[CACHE-SERVICE] Purged %d expired versions on disk", purged)
+			}
+		}
+	}()
+}
+
+func (s *CacheService) Stats() CacheStats {
+	return s.repo.Stats()
+}
+
+func (s *CacheService) LastWrite() time.Time {
+	return s.repo.LastWrite()
+}
@@ -0,0 +1,221 @@
+package cache
+
+import (
+	"container/list"
+	"hash/fnv"
+)
+
+// countMinSketch estimates key frequencies in a few bytes per counter,
+// counters are halved periodically so old popularity fades out
+type countMinSketch struct {
+	rows    [4][]uint8
+	mask    uint64
+	added   int
+	resetAt int
+}
+
+func newCountMinSketch(width int) *countMinSketch {
+	size := 1
+	for size < width {
+		size <<= 1
+	}
+
+	s := &countMinSketch{mask: uint64(size - 1), resetAt: size * 10}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, size)
+	}
+	return s
+}
+
+func (s *countMinSketch) indexes(key string) [4]uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+
+	//derive the row hashes from one 64 bit hash (kirsch-mitzenmacher)
+	lo, hi := sum&0xffffffff, sum>>32
+	var idx [4]uint64
+	for i := range idx {
+		idx[i] = (lo + uint64(i)*hi) & s.mask
+	}
+	return idx
+}
+
+func (s *countMinSketch) Increment(key string) {
+	for i, idx := range s.indexes(key) {
+		if s.rows[i][idx] < 15 {
+			s.rows[i][idx]++
+		}
+	}
+
+	s.added++
+	if s.added >= s.resetAt {
+		s.reset()
+	}
+}
+
+func (s *countMinSketch) Estimate(key string) uint8 {
+	min := uint8(15)
+	for i, idx := range s.indexes(key) {
+		if s.rows[i][idx] < min {
+			min = s.rows[i][idx]
+		}
+	}
+	return min
+}
+
+func (s *countMinSketch) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.added /= 2
+}
+
+type tinyLFUSegment int
+
+const (
+	segmentWindow tinyLFUSegment = iota
+	segmentProbation
+	segmentProtected
+)
+
+type tinyLFUEntry struct {
+	segment tinyLFUSegment
+	el      *list.Element
+}
+
+// wTinyLFUPolicy keeps new keys in a small LRU window, keys leaving the window
+// only get into the main segmented LRU if they are used more often than the
+// key they would push out
+type wTinyLFUPolicy struct {
+	sketch    *countMinSketch
+	window    *list.List
+	probation *list.List
+	protected *list.List
+	items     map[string]*tinyLFUEntry
+	candidate string // last key moved out of the window, still waiting for admission
+}
+
+func newWTinyLFUPolicy() *wTinyLFUPolicy {
+	return &wTinyLFUPolicy{
+		sketch:    newCountMinSketch(1024),
+		window:    list.New(),
+		probation: list.New(),
+		protected: list.New(),
+		items:     make(map[string]*tinyLFUEntry),
+	}
+}
+
+func (p *wTinyLFUPolicy) segment(s tinyLFUSegment) *list.List {
+	switch s {
+	case segmentWindow:
+		return p.window
+	case segmentProbation:
+		return p.probation
+	}
+	return p.protected
+}
+
+//window is ~1% of the keys and protected ~80% of the main space, like caffeine
+func (p *wTinyLFUPolicy) windowSize() int {
+	if n := len(p.items) / 100; n > 1 {
+		return n
+	}
+	return 1
+}
+
+func (p *wTinyLFUPolicy) protectedSize() int {
+	if n := (len(p.items) - p.window.Len()) * 80 / 100; n > 1 {
+		return n
+	}
+	return 1
+}
+
+func (p *wTinyLFUPolicy) Add(key string) {
+	p.sketch.Increment(key)
+
+	if _, ok := p.items[key]; ok {
+		p.touch(key)
+		return
+	}
+
+	p.items[key] = &tinyLFUEntry{segment: segmentWindow, el: p.window.PushFront(key)}
+
+	if p.window.Len() > p.windowSize() {
+		oldest := p.window.Back()
+		moved := oldest.Value.(string)
+		p.window.Remove(oldest)
+		p.items[moved] = &tinyLFUEntry{segment: segmentProbation, el: p.probation.PushFront(moved)}
+		p.candidate = moved
+	}
+}
+
+func (p *wTinyLFUPolicy) Access(key string) {
+	if _, ok := p.items[key]; !ok {
+		return
+	}
+	p.sketch.Increment(key)
+	p.touch(key)
+}
+
+func (p *wTinyLFUPolicy) touch(key string) {
+	e := p.items[key]
+
+	switch e.segment {
+	case segmentWindow, segmentProtected:
+		p.segment(e.segment).MoveToFront(e.el)
+
+	case segmentProbation:
+		//a second hit promotes the key, the protected tail is demoted to make room
+		p.probation.Remove(e.el)
+		e.segment = segmentProtected
+		e.el = p.protected.PushFront(key)
+		if key == p.candidate {
+			p.candidate = ""
+		}
+
+		if p.protected.Len() > p.protectedSize() {
+			tail := p.protected.Back()
+			demoted := tail.Value.(string)
+			p.protected.Remove(tail)
+			p.items[demoted] = &tinyLFUEntry{segment: segmentProbation, el: p.probation.PushFront(demoted)}
+		}
+	}
+}
+
+func (p *wTinyLFUPolicy) Remove(key string) {
+	e, ok := p.items[key]
+	if !ok {
+		return
+	}
+	p.segment(e.segment).Remove(e.el)
+	delete(p.items, key)
+	if key == p.candidate {
+		p.candidate = ""
+	}
+}
+
+func (p *wTinyLFUPolicy) Victim() (string, bool) {
+	//admission: the window's reject fights the probation tail on frequency
+	if p.candidate != "" {
+		candidate := p.candidate
+		p.candidate = ""
+
+		if tail := p.probation.Back(); tail != nil && tail.Value.(string) != candidate {
+			victim := tail.Value.(string)
+			if p.sketch.Estimate(candidate) > p.sketch.Estimate(victim) {
+				return victim, true
+			}
+			return candidate, true
+		}
+	}
+
+	for _, l := range []*list.List{p.probation, p.protected, p.window} {
+		if tail := l.Back(); tail != nil {
+			return tail.Value.(string), true
+		}
+	}
+	return "", false
+}
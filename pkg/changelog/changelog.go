@@ -0,0 +1,345 @@
+package changelog
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const logFile = "changes.log"
+
+const (
+	OpSet    = "set"
+	OpDelete = "delete"
+	OpCAS    = "cas"    //an applied lwt compare-and-set, it carries no vector clock
+	OpRepair = "repair" //read repair wrote an existing version to a node that lacked it
+)
+
+// ErrTruncated means the requested offset was dropped by retention, the consumer
+// has to start over from the oldest change still kept
+var ErrTruncated = errors.New("offset is older than the retained change log")
+
+// Change is one accepted write or delete, offsets start at 1 and have no gaps
+type Change struct {
+	Offset      uint64    `json:"offset"`
+	Op          string    `json:"op"`
+	Key         string    `json:"key"`
+	Value       string    `json:"value,omitempty"`
+	VectorClock string    `json:"vectorClock,omitempty"`
+	ExpiresAt   string    `json:"expiresAt,omitempty"`
+	Timestamp   time.Time `json:"timestamp"`
+	Origin      string    `json:"origin,omitempty"` //the site a replicated change came from, empty for local writes
+}
+
+type Config struct {
+	Dir       string
+	Retention int //changes kept, older ones are dropped when the log is compacted
+}
+
+// ConfigFromEnv reads CHANGELOG_DIR (data/changes) and CHANGELOG_RETENTION (100000)
+func ConfigFromEnv() Config {
+	cfg := Config{Dir: "data/changes", Retention: 100000}
+
+	if v := os.Getenv("CHANGELOG_DIR"); v != "" {
+		cfg.Dir = v
+	}
+	if v := os.Getenv("CHANGELOG_RETENTION"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			log.Printf("[CHANGELOG] Ignoring invalid CHANGELOG_RETENTION=%s", v)
+		} else {
+			cfg.Retention = n
+		}
+	}
+	return cfg
+}
+
+// Log is the coordinator's ordered record of changes, appended and fsynced on
+// every accepted write and kept in memory up to the retention for readers
+type Log struct {
+	mu        sync.RWMutex
+	dir       string
+	retention int
+	file      *os.File
+	size      int64    //end of the last complete record, a failed append is cut back to it
+	changes   []Change //oldest first, offsets are contiguous
+	next      uint64
+	notify    chan struct{} //closed and replaced on every append
+}
+
+// Open recovers the log in cfg.Dir. A torn record at the tail is cut off, a
+// corrupt one anywhere else fails Open: cutting there would drop accepted
+// changes and hand their offsets out again
+func Open(cfg Config) (*Log, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create change log dir: %w", err)
+	}
+
+	f, err := os.OpenFile(filepath.Join(cfg.Dir, logFile), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open change log: %w", err)
+	}
+
+	l := &Log{dir: cfg.Dir, retention: cfg.Retention, next: 1, notify: make(chan struct{})}
+
+	var goodOffset int64
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			if line != "" {
+				log.Printf("[CHANGELOG] Dropping torn record at offset %d", goodOffset)
+			}
+			break
+		}
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to read change log: %w", err)
+		}
+
+		c, ok := decodeLine(line)
+		if !ok {
+			if _, err := reader.Peek(1); err != io.EOF {
+				f.Close()
+				return nil, fmt.Errorf("corrupt change log record at byte %d of %s, records follow it", goodOffset, logFile)
+			}
+			log.Printf("[CHANGELOG] Dropping torn record at offset %d", goodOffset)
+			break
+		}
+		goodOffset += int64(len(line))
+		l.changes = append(l.changes, c)
+		l.next = c.Offset + 1
+	}
+
+	if err := f.Truncate(goodOffset); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to truncate change log: %w", err)
+	}
+	if _, err := f.Seek(goodOffset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to seek change log: %w", err)
+	}
+	l.file = f
+	l.size = goodOffset
+
+	if len(l.changes) > l.retention {
+		l.changes = append([]Change(nil), l.changes[len(l.changes)-l.retention:]...)
+	}
+
+	log.Printf("[CHANGELOG] Recovered %d changes from %s, next offset %d", len(l.changes), cfg.Dir, l.next)
+	return l, nil
+}
+
+// Append assigns c the next offset, it is on disk before Append returns. A failed
+// append leaves no partial record behind, the offset is handed out again
+func (l *Log) Append(c Change) (Change, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c.Offset = l.next
+	if c.Timestamp.IsZero() {
+		c.Timestamp = time.Now().UTC()
+	}
+
+	line, err := encodeLine(c)
+	if err != nil {
+		return c, err
+	}
+	if _, err := l.file.WriteString(line); err != nil {
+		return c, l.rollbackLocked(fmt.Errorf("failed to append change: %w", err))
+	}
+	if err := l.file.Sync(); err != nil {
+		return c, l.rollbackLocked(fmt.Errorf("failed to sync change log: %w", err))
+	}
+
+	l.size += int64(len(line))
+	l.next++
+	l.changes = append(l.changes, c)
+
+	//rewriting the file at twice the retention keeps compaction rare
+	if len(l.changes) > 2*l.retention {
+		if err := l.compactLocked(); err != nil {
+			log.Printf("[CHANGELOG] Compaction failed: %v", err)
+		}
+	}
+
+	close(l.notify)
+	l.notify = make(chan struct{})
+	return c, nil
+}
+
+//cuts the file back to the last complete record so the next append doesn't land
+//behind a torn one, recovery would drop everything after it
+func (l *Log) rollbackLocked(cause error) error {
+	if err := l.file.Truncate(l.size); err != nil {
+		return fmt.Errorf("%w, and rolling back failed: %v", cause, err)
+	}
+	if _, err := l.file.Seek(l.size, io.SeekStart); err != nil {
+		return fmt.Errorf("%w, and rolling back failed: %v", cause, err)
+	}
+	return cause
+}
+
+// Read returns up to limit changes after offset "after" whose key has prefix,
+// next is the offset to pass as after on the following call. Changes the prefix
+// filters out still move next forward
+func (l *Log) Read(after uint64, prefix string, limit int) (changes []Change, next uint64, err error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	next = after
+	if len(l.changes) == 0 {
+		if after+1 < l.next {
+			return nil, after, ErrTruncated
+		}
+		return nil, after, nil
+	}
+
+	oldest := l.changes[0].Offset
+	if after+1 < oldest {
+		return nil, after, ErrTruncated
+	}
+
+	for i := int(after + 1 - oldest); i < len(l.changes); i++ {
+		c := l.changes[i]
+		next = c.Offset
+		if !strings.HasPrefix(c.Key, prefix) {
+			continue
+		}
+		changes = append(changes, c)
+		if limit > 0 && len(changes) == limit {
+			break
+		}
+	}
+	return changes, next, nil
+}
+
+// Wait returns a channel closed by the next append, take it before Read so a
+// change landing in between isn't missed
+func (l *Log) Wait() <-chan struct{} {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.notify
+}
+
+// Oldest is the first offset still kept, Last the newest one written
+func (l *Log) Oldest() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if len(l.changes) == 0 {
+		return l.next
+	}
+	return l.changes[0].Offset
+}
+
+func (l *Log) Last() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.next - 1
+}
+
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+//keeps the newest retention changes and swaps the file atomically
+func (l *Log) compactLocked() error {
+	kept := append([]Change(nil), l.changes[len(l.changes)-l.retention:]...)
+
+	path := filepath.Join(l.dir, logFile)
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	var size int64
+	for _, c := range kept {
+		line, err := encodeLine(c)
+		if err == nil {
+			_, err = w.WriteString(line)
+			size += int64(len(line))
+		}
+		if err != nil {
+			f.Close()
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	l.file.Close()
+	l.file = f
+	l.size = size
+	l.changes = kept
+
+	//the rename is only durable once the directory is
+	if err := syncDir(l.dir); err != nil {
+		return err
+	}
+	log.Printf("[CHANGELOG] Compacted to %d changes, oldest offset %d", len(kept), kept[0].Offset)
+	return nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open dir for sync: %w", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync dir: %w", err)
+	}
+	return nil
+}
+
+func encodeLine(c Change) (string, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal change: %w", err)
+	}
+	return fmt.Sprintf("%08x %s\n", crc32.ChecksumIEEE(data), data), nil
+}
+
+func decodeLine(line string) (Change, bool) {
+	var c Change
+
+	checksum, data, ok := strings.Cut(strings.TrimSuffix(line, "\n"), " ")
+	if !ok {
+		return c, false
+	}
+	if fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(data))) != checksum {
+		return c, false
+	}
+	if err := json.Unmarshal([]byte(data), &c); err != nil {
+		return c, false
+	}
+	return c, true
+}
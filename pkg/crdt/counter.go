@@ -0,0 +1,114 @@
+package crdt
+
+import "fmt"
+
+// GCounter is a grow only counter, every node only increments its own slot
+type GCounter struct {
+	Counts map[string]uint64 `json:"counts"`
+}
+
+func NewGCounter() *GCounter {
+	return &GCounter{Counts: make(map[string]uint64)}
+}
+
+func (g *GCounter) Type() Type { return TypeGCounter }
+
+func (g *GCounter) Increment(nodeID string, delta uint64) {
+	if g.Counts == nil {
+		g.Counts = make(map[string]uint64)
+	}
+	g.Counts[nodeID] += delta
+}
+
+func (g *GCounter) Total() uint64 {
+	var total uint64
+	for _, c := range g.Counts {
+		total += c
+	}
+	return total
+}
+
+func (g *GCounter) Value() interface{} { return g.Total() }
+
+//merge takes max per node, same idea as vector clocks
+func (g *GCounter) Merge(other CRDT) error {
+	o, ok := other.(*GCounter)
+	if !ok {
+		return typeMismatch(TypeGCounter, other)
+	}
+	if g.Counts == nil {
+		g.Counts = make(map[string]uint64)
+	}
+	for node, c := range o.Counts {
+		if c > g.Counts[node] {
+			g.Counts[node] = c
+		}
+	}
+	return nil
+}
+
+// PNCounter supports decrements by keeping two grow only counters
+type PNCounter struct {
+	P *GCounter `json:"p"`
+	N *GCounter `json:"n"`
+}
+
+func NewPNCounter() *PNCounter {
+	return &PNCounter{P: NewGCounter(), N: NewGCounter()}
+}
+
+func (c *PNCounter) Type() Type { return TypePNCounter }
+
+func (c *PNCounter) Increment(nodeID string, delta int64) {
+	c.init()
+	if delta >= 0 {
+		c.P.Increment(nodeID, uint64(delta))
+		return
+	}
+	c.N.Increment(nodeID, uint64(-delta))
+}
+
+func (c *PNCounter) Total() int64 {
+	c.init()
+	return int64(c.P.Total()) - int64(c.N.Total())
+}
+
+func (c *PNCounter) Value() interface{} { return c.Total() }
+
+func (c *PNCounter) Merge(other CRDT) error {
+	o, ok := other.(*PNCounter)
+	if !ok {
+		return typeMismatch(TypePNCounter, other)
+	}
+	c.init()
+	o.init()
+	if err := c.P.Merge(o.P); err != nil {
+		return err
+	}
+	return c.N.Merge(o.N)
+}
+
+func (c *PNCounter) init() {
+	if c.P == nil {
+		c.P = NewGCounter()
+	}
+	if c.N == nil {
+		c.N = NewGCounter()
+	}
+}
+
+// Increment applies a delta to either counter type, g-counters reject negative deltas
+func Increment(c CRDT, nodeID string, delta int64) error {
+	switch counter := c.(type) {
+	case *GCounter:
+		if delta < 0 {
+			return fmt.Errorf("g-counter cannot be decremented")
+		}
+		counter.Increment(nodeID, uint64(delta))
+	case *PNCounter:
+		counter.Increment(nodeID, delta)
+	default:
+		return fmt.Errorf("%s is not a counter", c.Type())
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package crdt
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+type Type string
+
+const (
+	TypeGCounter    Type = "g-counter"
+	TypePNCounter   Type = "pn-counter"
+	TypeORSet       Type = "or-set"
+	TypeLWWRegister Type = "lww-register"
+	TypeORMap       Type = "or-map"
+)
+
+// CRDT is a state based replicated data type, merging two replicas
+// is commutative, associative and idempotent so siblings never lose updates
+type CRDT interface {
+	Type() Type
+	Merge(other CRDT) error
+	Value() interface{}
+}
+
+//envelope is how a CRDT is stored inside the plain string value of a version
+type envelope struct {
+	CRDT  Type            `json:"crdt"`
+	State json.RawMessage `json:"state"`
+}
+
+func New(t Type) (CRDT, error) {
+	switch t {
+	case TypeGCounter:
+		return NewGCounter(), nil
+	case TypePNCounter:
+		return NewPNCounter(), nil
+	case TypeORSet:
+		return NewORSet(), nil
+	case TypeLWWRegister:
+		return NewLWWRegister(), nil
+	case TypeORMap:
+		return NewORMap(), nil
+	}
+	return nil, fmt.Errorf("unknown crdt type: %s", t)
+}
+
+// Clone copies c by merging it into an empty CRDT of its type, merges copy the
+// other side's state so the copy shares nothing with c
+func Clone(c CRDT) (CRDT, error) {
+	out, err := New(c.Type())
+	if err != nil {
+		return nil, err
+	}
+	if err := out.Merge(c); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func Encode(c CRDT) (string, error) {
+	state, err := json.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal crdt state: %w", err)
+	}
+
+	b, err := json.Marshal(envelope{CRDT: c.Type(), State: state})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal crdt envelope: %w", err)
+	}
+	return string(b), nil
+}
+
+// Decode parses a stored value, ok is false when the value is not a CRDT at all
+func Decode(value string) (c CRDT, ok bool, err error) {
+	var env envelope
+	if json.Unmarshal([]byte(value), &env) != nil || env.CRDT == "" {
+		return nil, false, nil
+	}
+
+	c, err = New(env.CRDT)
+	if err != nil {
+		return nil, true, err
+	}
+
+	if len(env.State) > 0 {
+		if err := json.Unmarshal(env.State, c); err != nil {
+			return nil, true, fmt.Errorf("failed to unmarshal %s state: %w", env.CRDT, err)
+		}
+	}
+	return c, true, nil
+}
+
+// MergeValues merges every sibling value into one CRDT, ok is false when
+// any sibling is not a CRDT so the caller should keep the siblings as they are
+func MergeValues(values []string) (merged CRDT, ok bool, err error) {
+	if len(values) == 0 {
+		return nil, false, nil
+	}
+
+	for _, v := range values {
+		c, isCRDT, err := Decode(v)
+		if !isCRDT {
+			return nil, false, nil
+		}
+		if err != nil {
+			return nil, true, err
+		}
+
+		if merged == nil {
+			merged = c
+			continue
+		}
+		if err := merged.Merge(c); err != nil {
+			return nil, true, err
+		}
+	}
+	return merged, true, nil
+}
+
+func typeMismatch(want Type, got CRDT) error {
+	return fmt.Errorf("cannot merge %s into %s", got.Type(), want)
+}
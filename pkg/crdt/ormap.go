@@ -0,0 +1,148 @@
+package crdt
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ORMap maps fields to nested CRDTs, field presence follows OR-Set semantics
+// and values of the same field are merged with their own CRDT rules
+type ORMap struct {
+	Keys   *ORSet          `json:"keys"`
+	Fields map[string]CRDT `json:"-"`
+}
+
+type orMapState struct {
+	Keys   *ORSet            `json:"keys"`
+	Fields map[string]string `json:"fields"`
+}
+
+func NewORMap() *ORMap {
+	return &ORMap{Keys: NewORSet(), Fields: make(map[string]CRDT)}
+}
+
+func (m *ORMap) Type() Type { return TypeORMap }
+
+// Update returns the CRDT stored under field, creating it with type t when missing,
+// the caller mutates it in place and tag marks the field as present
+func (m *ORMap) Update(field string, t Type, tag string) (CRDT, error) {
+	m.init()
+
+	c, ok := m.Fields[field]
+	if !ok {
+		created, err := New(t)
+		if err != nil {
+			return nil, err
+		}
+		c = created
+		m.Fields[field] = c
+	}
+
+	if c.Type() != t {
+		return nil, fmt.Errorf("field '%s' holds %s, not %s", field, c.Type(), t)
+	}
+
+	m.Keys.Add(field, tag)
+	return c, nil
+}
+
+// Remove drops the field together with its state, so a later Update starts the
+// field over instead of bringing the old counter or register back
+func (m *ORMap) Remove(field string) {
+	m.init()
+	m.Keys.Remove(field)
+	delete(m.Fields, field)
+}
+
+func (m *ORMap) Value() interface{} {
+	m.init()
+	out := make(map[string]interface{})
+	for _, field := range m.Keys.Elements() {
+		if c, ok := m.Fields[field]; ok {
+			out[field] = c.Value()
+		}
+	}
+	return out
+}
+
+func (m *ORMap) Merge(other CRDT) error {
+	o, ok := other.(*ORMap)
+	if !ok {
+		return typeMismatch(TypeORMap, other)
+	}
+	m.init()
+	o.init()
+
+	if err := m.Keys.Merge(o.Keys); err != nil {
+		return err
+	}
+
+	for field, c := range o.Fields {
+		cur, exists := m.Fields[field]
+		if !exists {
+			//a copy, later updates of either map must not show up in the other
+			cloned, err := Clone(c)
+			if err != nil {
+				return fmt.Errorf("field '%s': %w", field, err)
+			}
+			m.Fields[field] = cloned
+			continue
+		}
+		if err := cur.Merge(c); err != nil {
+			return fmt.Errorf("field '%s': %w", field, err)
+		}
+	}
+
+	//a remove that won on either side takes the field state with it
+	for field := range m.Fields {
+		if !m.Keys.Contains(field) {
+			delete(m.Fields, field)
+		}
+	}
+	return nil
+}
+
+//nested values are stored in their own envelope so the map can hold mixed types
+func (m *ORMap) MarshalJSON() ([]byte, error) {
+	m.init()
+	state := orMapState{Keys: m.Keys, Fields: make(map[string]string, len(m.Fields))}
+	for field, c := range m.Fields {
+		encoded, err := Encode(c)
+		if err != nil {
+			return nil, err
+		}
+		state.Fields[field] = encoded
+	}
+	return json.Marshal(state)
+}
+
+func (m *ORMap) UnmarshalJSON(data []byte) error {
+	var state orMapState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+
+	m.Keys = state.Keys
+	m.Fields = make(map[string]CRDT, len(state.Fields))
+	for field, encoded := range state.Fields {
+		c, ok, err := Decode(encoded)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("field '%s' is not a crdt", field)
+		}
+		m.Fields[field] = c
+	}
+	m.init()
+	return nil
+}
+
+func (m *ORMap) init() {
+	if m.Keys == nil {
+		m.Keys = NewORSet()
+	}
+	if m.Fields == nil {
+		m.Fields = make(map[string]CRDT)
+	}
+}
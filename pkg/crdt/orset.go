@@ -0,0 +1,105 @@
+package crdt
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ORSet is an observed-remove set, every add carries a unique tag and a remove
+// only tombstones the tags it has seen, so a concurrent add wins over a remove
+type ORSet struct {
+	Entries map[string]map[string]bool `json:"entries"`
+	Removed map[string]bool            `json:"removed"`
+}
+
+func NewORSet() *ORSet {
+	return &ORSet{
+		Entries: make(map[string]map[string]bool),
+		Removed: make(map[string]bool),
+	}
+}
+
+// NewTag builds a tag unique to this node and moment
+func NewTag(nodeID string) string {
+	return fmt.Sprintf("%s@%d", nodeID, time.Now().UnixNano())
+}
+
+func (s *ORSet) Type() Type { return TypeORSet }
+
+func (s *ORSet) Add(element, tag string) {
+	s.init()
+	if s.Entries[element] == nil {
+		s.Entries[element] = make(map[string]bool)
+	}
+	s.Entries[element][tag] = true
+}
+
+func (s *ORSet) Remove(element string) {
+	s.init()
+	for tag := range s.Entries[element] {
+		s.Removed[tag] = true
+	}
+	delete(s.Entries, element)
+}
+
+func (s *ORSet) Contains(element string) bool {
+	for tag := range s.Entries[element] {
+		if !s.Removed[tag] {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *ORSet) Elements() []string {
+	out := make([]string, 0, len(s.Entries))
+	for element := range s.Entries {
+		if s.Contains(element) {
+			out = append(out, element)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (s *ORSet) Value() interface{} { return s.Elements() }
+
+func (s *ORSet) Merge(other CRDT) error {
+	o, ok := other.(*ORSet)
+	if !ok {
+		return typeMismatch(TypeORSet, other)
+	}
+	s.init()
+
+	for tag := range o.Removed {
+		s.Removed[tag] = true
+	}
+	for element, tags := range o.Entries {
+		for tag := range tags {
+			s.Add(element, tag)
+		}
+	}
+
+	//drop tags both sides agree are removed, tombstones stay so stale adds can't come back
+	for element, tags := range s.Entries {
+		for tag := range tags {
+			if s.Removed[tag] {
+				delete(tags, tag)
+			}
+		}
+		if len(tags) == 0 {
+			delete(s.Entries, element)
+		}
+	}
+	return nil
+}
+
+func (s *ORSet) init() {
+	if s.Entries == nil {
+		s.Entries = make(map[string]map[string]bool)
+	}
+	if s.Removed == nil {
+		s.Removed = make(map[string]bool)
+	}
+}
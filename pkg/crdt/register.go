@@ -0,0 +1,40 @@
+package crdt
+
+// LWWRegister keeps the value with the highest timestamp, node id breaks ties
+type LWWRegister struct {
+	Val       string `json:"value"`
+	Timestamp int64  `json:"timestamp"`
+	NodeID    string `json:"nodeId"`
+}
+
+func NewLWWRegister() *LWWRegister {
+	return &LWWRegister{}
+}
+
+func (r *LWWRegister) Type() Type { return TypeLWWRegister }
+
+func (r *LWWRegister) Set(value string, timestamp int64, nodeID string) {
+	if r.newer(timestamp, nodeID) {
+		r.Val = value
+		r.Timestamp = timestamp
+		r.NodeID = nodeID
+	}
+}
+
+func (r *LWWRegister) Value() interface{} { return r.Val }
+
+func (r *LWWRegister) Merge(other CRDT) error {
+	o, ok := other.(*LWWRegister)
+	if !ok {
+		return typeMismatch(TypeLWWRegister, other)
+	}
+	r.Set(o.Val, o.Timestamp, o.NodeID)
+	return nil
+}
+
+func (r *LWWRegister) newer(timestamp int64, nodeID string) bool {
+	if timestamp != r.Timestamp {
+		return timestamp > r.Timestamp
+	}
+	return nodeID > r.NodeID
+}
@@ -5,19 +5,23 @@ import (
 	"fmt"
 	"log"
 	"sort"
+	"strings"
 )
 
 type HashRing struct {
 	nodes    []int
 	nodeMap  map[int]string
+	nodeIDs  map[string]string // address -> stable node id
 	replicas int
 	N        int // replication factor
+	hashTags bool
 }
 
 func NewHashRing(replicas int, replicationFactor int) *HashRing {
 	return &HashRing{
 		nodes:    []int{},
 		nodeMap:  make(map[int]string),
+		nodeIDs:  make(map[string]string),
 		replicas: replicas,
 		N:        replicationFactor,
 	}
@@ -31,13 +35,62 @@ func Hash(s string) int {
 	return int((uint32(bs[0])<<24 | uint32(bs[1])<<16 | uint32(bs[2])<<8 | uint32(bs[3])))
 }
 
+// HashTag follows the redis rule: the text between the first '{' and the first
+// '}' after it, ok is false when there is none or it is empty ("{}" or "a{")
+func HashTag(key string) (tag string, ok bool) {
+	start := strings.IndexByte(key, '{')
+	if start < 0 {
+		return "", false
+	}
+	end := strings.IndexByte(key[start+1:], '}')
+	if end <= 0 {
+		return "", false
+	}
+	return key[start+1 : start+1+end], true
+}
+
+// Position is the ring position of a key. With hashTags a key like {user42}:profile
+// is placed by its tag alone, so keys sharing a tag share a preference list
+func Position(key string, hashTags bool) int {
+	if hashTags {
+		if tag, ok := HashTag(key); ok {
+			return Hash(tag)
+		}
+	}
+	return Hash(key)
+}
+
+// EnableHashTags places keys by their hash tag from now on. Every key holding a
+// {tag} moves, so it is only safe before the ring holds any of them
+func (r *HashRing) EnableHashTags() {
+	r.hashTags = true
+}
+
+// HashTags reports whether keys are placed by their hash tag
+func (r *HashRing) HashTags() bool {
+	return r.hashTags
+}
+
+// Position is the ring position of key under this ring's placement
+func (r *HashRing) Position(key string) int {
+	return Position(key, r.hashTags)
+}
+
+// AddNode uses the address itself as the node id, kept for nodes without a persisted id
 func (r *HashRing) AddNode(node string, capacity int) {
+	r.AddNodeWithID(node, node, capacity)
+}
+
+// AddNodeWithID places the node's virtual nodes by its stable id, so a node that
+// comes back on another address keeps the same token ranges
+func (r *HashRing) AddNodeWithID(id string, node string, capacity int) {
 
-	h := Hash(node)
-	fmt.Printf("Adding server %s at position %d\n", node, h)
+	h := Hash(id)
+	fmt.Printf("Adding server %s (id=%s) at position %d\n", node, id, h)
+	r.nodeIDs[node] = id
 
 	for i := 0; i < r.replicas*capacity; i++ {
-		vNode := fmt.Sprintf("%s#%d", node, i)
+		vNode := fmt.Sprintf("%s#%d", id, i)
 		vh := Hash(vNode)
 
 		log.Printf("Adding virtual node %s at position %d", vNode, vh)
@@ -54,7 +107,7 @@ func (r *HashRing) GetNode(key string) (string, string) {
 	if len(r.nodes) == 0 {
 		return "", ""
 	}
-	h := Hash(key)
+	h := r.Position(key)
 
 	//binary search, clockwise movement on ring
 	idx := sort.Search(len(r.nodes), func(i int) bool {
@@ -78,7 +131,7 @@ func (r *HashRing) GetPreferenceList(key string) []string {
 		return []string{}
 	}
 
-	h := Hash(key)
+	h := r.Position(key)
 	
 	idx := sort.Search(len(r.nodes), func(i int) bool {
 		return r.nodes[i] >= h
@@ -87,6 +140,11 @@ func (r *HashRing) GetPreferenceList(key string) []string {
 		idx = 0
 	}
 
+	return r.preferenceListAt(idx)
+}
+
+//walks clockwise from the vnode at idx collecting N distinct physical nodes
+func (r *HashRing) preferenceListAt(idx int) []string {
 	preferenceList := make([]string, 0, r.N)
 	seen := make(map[string]bool)
 	
@@ -111,4 +169,66 @@ func (r *HashRing) getTotalPhysicalNodes() int {
 		physicalNodes[node] = true
 	}
 	return len(physicalNodes)
-}
\ No newline at end of file
+}
+
+// TokenRange is the arc of the ring (Start, End] owned by the vnode at End,
+// Owners is the preference list of every key hashing into it. HashTags is the
+// placement of the ring it was cut from, a node needs it to tell its keys apart
+type TokenRange struct {
+	Start    int      `json:"start"`
+	End      int      `json:"end"`
+	Owners   []string `json:"owners"`
+	HashTags bool     `json:"hashTags,omitempty"`
+}
+
+// Contains reports whether hash h falls in the range, the first range wraps past zero
+func (t TokenRange) Contains(h int) bool {
+	if t.Start < t.End {
+		return h > t.Start && h <= t.End
+	}
+	//a single vnode owns the whole ring
+	return h > t.Start || h <= t.End
+}
+
+// ContainsKey reports whether key is placed in the range
+func (t TokenRange) ContainsKey(key string) bool {
+	return t.Contains(Position(key, t.HashTags))
+}
+
+// TokenRanges splits the ring at every vnode, together the ranges cover every hash once
+func (r *HashRing) TokenRanges() []TokenRange {
+	if len(r.nodes) == 0 || r.N <= 0 {
+		return nil
+	}
+
+	ranges := make([]TokenRange, 0, len(r.nodes))
+	for i, end := range r.nodes {
+		start := r.nodes[(i-1+len(r.nodes))%len(r.nodes)]
+		ranges = append(ranges, TokenRange{Start: start, End: end, Owners: r.preferenceListAt(i), HashTags: r.hashTags})
+	}
+	return ranges
+}
+
+// NodeID returns the stable id of the node at address, used for vector clock entries
+func (r *HashRing) NodeID(node string) string {
+	if id, ok := r.nodeIDs[node]; ok && id != node {
+		return id
+	}
+	//nodes added without an id fall back to the address, ':' trimmed like before
+	return strings.TrimPrefix(strings.TrimSpace(node), ":")
+}
+
+// Nodes returns every physical node on the ring, sorted by address
+func (r *HashRing) Nodes() []string {
+	seen := make(map[string]bool)
+	for _, node := range r.nodeMap {
+		seen[node] = true
+	}
+
+	out := make([]string, 0, len(seen))
+	for node := range seen {
+		out = append(out, node)
+	}
+	sort.Strings(out)
+	return out
+}
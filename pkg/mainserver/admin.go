@@ -0,0 +1,36 @@
+package mainserver
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminSecretHeader carries ADMIN_SECRET on requests to /ingest and /admin, the
+// routes that store raw versions or make the server call out to other hosts
+const AdminSecretHeader = "X-Admin-Secret"
+
+// AdminSecretFromEnv reads ADMIN_SECRET, empty keeps the admin routes closed
+func AdminSecretFromEnv() string {
+	secret := os.Getenv("ADMIN_SECRET")
+	if secret == "" {
+		log.Printf("[ADMIN] ADMIN_SECRET is not set, admin routes are refused")
+	}
+	return secret
+}
+
+// RequireAdmin refuses a request without the admin secret, compared in constant time
+func RequireAdmin(secret string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		given := c.GetHeader(AdminSecretHeader)
+		if secret == "" || subtle.ConstantTimeCompare([]byte(given), []byte(secret)) != 1 {
+			log.Printf("[ADMIN] Refused %s %s, bad admin secret", c.Request.Method, c.Request.URL.Path)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "a valid " + AdminSecretHeader + " is required"})
+			return
+		}
+		c.Next()
+	}
+}
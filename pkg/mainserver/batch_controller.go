@@ -0,0 +1,47 @@
+package mainserver
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type mgetRequest struct {
+	Keys []string `json:"keys" binding:"required"`
+}
+
+type msetRequest struct {
+	Items []map[string]string `json:"items" binding:"required"`
+}
+
+// MGet answers 200 with one result per requested key, in request order
+func (mc *MainController) MGet(c *gin.Context) {
+	var req mgetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "keys are required"})
+		return
+	}
+
+	results, err := mc.service.MGet(req.Keys)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
+
+// MSet answers 200 with one result per item, each with its causal context or error
+func (mc *MainController) MSet(c *gin.Context) {
+	var req msetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "items are required"})
+		return
+	}
+
+	results, err := mc.service.MSet(req.Items)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
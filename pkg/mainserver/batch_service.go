@@ -0,0 +1,252 @@
+package mainserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rupeshx80/consistent-hashing/pkg/model"
+	"github.com/rupeshx80/consistent-hashing/pkg/quorum"
+	"github.com/rupeshx80/consistent-hashing/pkg/storage"
+)
+
+const MaxBatchSize = 1000
+
+//the per key error of a key with no live version, anything else is a failed read
+const batchKeyNotFound = "key not found"
+
+// BatchResult is the outcome for one key of an /mget or /mset, Error is set
+// instead of failing the whole batch
+type BatchResult struct {
+	Key      string           `json:"key"`
+	Versions []VersionedValue `json:"versions,omitempty"`
+	Context  string           `json:"context,omitempty"`
+	Error    string           `json:"error,omitempty"`
+}
+
+// replicaGroup is a set of keys that share a preference list, one quorum
+// round covers all of them. With hash tags on, keys with a common tag always
+// end up in one group
+type replicaGroup struct {
+	nodes   []string
+	indexes []int //positions of the keys in the request
+}
+
+func (s *MainService) groupByPreferenceList(keys []string, skip func(i int) bool) []*replicaGroup {
+	groups := make(map[string]*replicaGroup)
+	var order []string
+
+	for i, key := range keys {
+		if skip(i) {
+			continue
+		}
+		nodes := s.ring.GetPreferenceList(key)
+		id := strings.Join(nodes, ",")
+
+		g, ok := groups[id]
+		if !ok {
+			g = &replicaGroup{nodes: nodes}
+			groups[id] = g
+			order = append(order, id)
+		}
+		g.indexes = append(g.indexes, i)
+	}
+
+	out := make([]*replicaGroup, 0, len(order))
+	for _, id := range order {
+		out = append(out, groups[id])
+	}
+	return out
+}
+
+// MGet reads many keys with one read quorum per replica set, the sets run in parallel
+func (s *MainService) MGet(keys []string) ([]BatchResult, error) {
+	if len(keys) > MaxBatchSize {
+		return nil, fmt.Errorf("batch too large: %d keys, max %d", len(keys), MaxBatchSize)
+	}
+
+	results := make([]BatchResult, len(keys))
+	for i, key := range keys {
+		results[i].Key = key
+		if key == "" {
+			results[i].Error = "key is required"
+		}
+	}
+
+	groups := s.groupByPreferenceList(keys, func(i int) bool { return results[i].Error != "" })
+
+	var wg sync.WaitGroup
+	for _, g := range groups {
+		wg.Add(1)
+		go func(g *replicaGroup) {
+			defer wg.Done()
+			s.mgetGroup(g, keys, results)
+		}(g)
+	}
+	wg.Wait()
+
+	log.Printf("[MGET] Read %d keys in %d replica sets", len(keys), len(groups))
+	return results, nil
+}
+
+//each group only touches its own indexes of results, so no locking is needed
+func (s *MainService) mgetGroup(g *replicaGroup, keys []string, results []BatchResult) {
+	groupKeys := make([]string, 0, len(g.indexes))
+	for _, i := range g.indexes {
+		groupKeys = append(groupKeys, keys[i])
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	qres, err := s.qManager.ReadQuorumBatch(ctx, g.nodes, groupKeys)
+	if err != nil {
+		log.Printf("[MGET] Quorum read failed for replica set %v, falling back to DB: %v", g.nodes, err)
+	}
+
+	for _, i := range g.indexes {
+		key := keys[i]
+
+		var versions []VersionedValue
+		for _, v := range qres[key] {
+			versions = append(versions, VersionedValue{
+				Value:       v.Value,
+				VectorClock: v.VectorClock,
+				CreatedAt:   v.CreatedAt,
+				ExpiresAt:   v.ExpiresAt,
+				Tombstone:   v.Tombstone,
+			})
+		}
+
+		if len(versions) == 0 {
+			dbVersions, dbErr := s.repository.GetAllVersions(key)
+			if dbErr != nil {
+				//a key is only missing when the quorum answered, otherwise the
+				//client has to see why the read failed
+				switch {
+				case err != nil:
+					results[i].Error = fmt.Sprintf("read quorum failed: %v", err)
+				case errors.Is(dbErr, storage.ErrNotFound):
+					results[i].Error = batchKeyNotFound
+				default:
+					results[i].Error = fmt.Sprintf("failed to read key: %v", dbErr)
+				}
+				continue
+			}
+			for _, kv := range dbVersions {
+				versions = append(versions, VersionedValue{
+					Value:       kv.Value,
+					VectorClock: kv.VectorClock,
+					CreatedAt:   kv.CreatedAt.String(),
+					ExpiresAt:   model.FormatExpiry(kv.ExpiresAt),
+					Tombstone:   kv.Tombstone,
+				})
+			}
+		}
+
+		versions = s.resolveSiblings(key, versions)
+		if len(versions) == 0 {
+			results[i].Error = batchKeyNotFound
+			continue
+		}
+		results[i].Versions = versions
+		results[i].Context = s.CausalContext(versions)
+	}
+}
+
+// MSet writes many keys, every item takes the same fields as a /set body. Items
+// sharing a replica set are stored with one batch request per replica
+func (s *MainService) MSet(items []map[string]string) ([]BatchResult, error) {
+	if len(items) > MaxBatchSize {
+		return nil, fmt.Errorf("batch too large: %d items, max %d", len(items), MaxBatchSize)
+	}
+
+	keys := make([]string, len(items))
+	results := make([]BatchResult, len(items))
+	seen := make(map[string]bool, len(items))
+	for i, item := range items {
+		key := item["key"]
+		keys[i] = key
+		results[i].Key = key
+
+		switch {
+		case key == "":
+			results[i].Error = "key is required"
+		case seen[key]:
+			results[i].Error = "duplicate key in batch"
+		case item["ifMatch"] != "":
+			results[i].Error = "ifMatch is not supported in batches, use /set"
+		}
+		seen[key] = true
+
+		//tombstones are only written through DELETE
+		delete(item, "tombstone")
+	}
+
+	groups := s.groupByPreferenceList(keys, func(i int) bool { return results[i].Error != "" })
+
+	var wg sync.WaitGroup
+	for _, g := range groups {
+		wg.Add(1)
+		go func(g *replicaGroup) {
+			defer wg.Done()
+			s.msetGroup(g, items, results)
+		}(g)
+	}
+	wg.Wait()
+
+	log.Printf("[MSET] Wrote %d items in %d replica sets", len(items), len(groups))
+	return results, nil
+}
+
+func (s *MainService) msetGroup(g *replicaGroup, items []map[string]string, results []BatchResult) {
+	keys := make([]string, 0, len(g.indexes))
+	for _, i := range g.indexes {
+		keys = append(keys, items[i]["key"])
+	}
+	defer s.lockKeys(keys...)()
+
+	writes := make(map[int]*pendingWrite, len(keys))
+	var quorumItems []quorum.BatchItem
+
+	//same order as put: the DB copy first since it hands out the dots, then the
+	//preference list
+	for _, i := range g.indexes {
+		w, err := s.prepareWrite(items[i])
+		if err == nil {
+			err = s.storeLocally(w)
+		}
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+
+		writes[i] = w
+		quorumItems = append(quorumItems, quorum.BatchItem{Key: w.key, VersionedValue: w.replicated()})
+	}
+	if len(writes) == 0 {
+		return
+	}
+
+	//the whole replica set, coordinator node included, has to reach W like a single write
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	failed := s.qManager.WriteQuorumBatch(ctx, g.nodes, quorumItems)
+
+	for i, w := range writes {
+		if err, ok := failed[w.key]; ok {
+			results[i].Error = err.Error()
+			continue
+		}
+		if err := s.recordChange(w.key, w.replicated()); err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i].Context = encodeCausalContext(w.vectorClock)
+	}
+}
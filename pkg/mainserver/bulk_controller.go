@@ -0,0 +1,69 @@
+package mainserver
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rupeshx80/consistent-hashing/pkg/bulk"
+)
+
+//?format= wins, otherwise a csv content type picks csv
+func bulkFormat(c *gin.Context) (string, error) {
+	format := c.Query("format")
+	if format == "" && strings.HasPrefix(c.ContentType(), "text/csv") {
+		format = bulk.FormatCSV
+	}
+	return bulk.ParseFormat(format)
+}
+
+// Import reads JSON Lines or CSV from the request body as it arrives and answers
+// with the counts and the lines that failed
+func (mc *MainController) Import(c *gin.Context) {
+	format, err := bulkFormat(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	report, err := mc.service.Import(format, c.Request.Body)
+	if err != nil {
+		if report == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		//what was stored before the body broke off stays stored
+		log.Printf("[CONTROLLER] Import aborted after %d lines: %v", report.Lines, err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "report": report})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// Export streams ?prefix= keys as JSON Lines or CSV. The body is already on its
+// way when an error happens, so the outcome travels in the X-Export-Error and
+// X-Export-Count trailers
+func (mc *MainController) Export(c *gin.Context) {
+	format, err := bulk.ParseFormat(c.Query("format"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	contentType := "application/x-ndjson"
+	if format == bulk.FormatCSV {
+		contentType = "text/csv"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Trailer", "X-Export-Error, X-Export-Count")
+	c.Status(http.StatusOK)
+
+	count, err := mc.service.Export(format, c.Query("prefix"), c.Writer)
+	c.Writer.Header().Set("X-Export-Count", strconv.Itoa(count))
+	if err != nil {
+		log.Printf("[CONTROLLER] Export failed after %d versions: %v", count, err)
+		c.Writer.Header().Set("X-Export-Error", err.Error())
+	}
+}
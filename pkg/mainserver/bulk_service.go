@@ -0,0 +1,246 @@
+package mainserver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/rupeshx80/consistent-hashing/pkg/bulk"
+	"github.com/rupeshx80/consistent-hashing/pkg/model"
+)
+
+//records per batch, each batch is split by preference list like /mset
+const importBatchSize = 500
+
+// importBatch collects records until a flush, versioned records go through
+// Ingest with their clock and plain ones through MSet
+type importBatch struct {
+	lines    []int
+	records  []bulk.Record
+	versions []IngestItem
+	plain    []map[string]string
+	keys     map[string]bool //true when the key was added as a plain write
+}
+
+func newImportBatch() *importBatch {
+	return &importBatch{keys: make(map[string]bool)}
+}
+
+// Import streams records from r and stores them in batches, a bad line is
+// reported in the result and doesn't stop the import
+func (s *MainService) Import(format string, r io.Reader) (*bulk.Report, error) {
+	reader, err := bulk.NewReader(format, r)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &bulk.Report{Errors: []bulk.ImportError{}}
+	batch := newImportBatch()
+
+	for {
+		rec, line, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		var lineErr *bulk.LineError
+		if errors.As(err, &lineErr) {
+			report.Lines++
+			report.AddError(bulk.ImportError{Line: line, Error: lineErr.Err.Error()})
+			continue
+		}
+		if err != nil {
+			return report, fmt.Errorf("failed to read import: %w", err)
+		}
+		report.Lines++
+
+		//versions and plain writes of one key run in separate calls, and MSet
+		//takes a key once, so a second record mixing them starts a new batch
+		if plain, ok := batch.keys[rec.Key]; ok && (plain || rec.VectorClock == "") {
+			s.flushImport(batch, report)
+			batch = newImportBatch()
+		}
+		if err := batch.add(rec, line); err != nil {
+			report.AddError(bulk.ImportError{Line: line, Key: rec.Key, Error: err.Error()})
+			continue
+		}
+
+		if len(batch.records) >= importBatchSize {
+			s.flushImport(batch, report)
+			batch = newImportBatch()
+		}
+	}
+
+	s.flushImport(batch, report)
+	log.Printf("[IMPORT] Done, %d lines, %d stored, %d failed", report.Lines, report.Stored, report.Failed)
+	return report, nil
+}
+
+func (b *importBatch) add(rec bulk.Record, line int) error {
+	if rec.Key == "" {
+		return fmt.Errorf("key is required")
+	}
+
+	if rec.VectorClock != "" {
+		expiresAt, err := model.ParseExpiry(rec.ExpiresAt)
+		if err != nil {
+			return err
+		}
+		if expiresAt == nil && rec.TTL != "" {
+			d, err := model.ParseTTL(rec.TTL)
+			if err != nil {
+				return err
+			}
+			t := time.Now().Add(d)
+			expiresAt = &t
+		}
+		b.versions = append(b.versions, IngestItem{
+			Key:         rec.Key,
+			Value:       rec.Value,
+			VectorClock: rec.VectorClock,
+			ExpiresAt:   expiresAt,
+			Tombstone:   rec.Tombstone,
+		})
+	} else {
+		if rec.Tombstone {
+			return fmt.Errorf("a tombstone needs a vectorClock, use DELETE to remove a key")
+		}
+
+		//plain writes only take a ttl, an absolute expiry becomes the time left
+		ttl := rec.TTL
+		if rec.ExpiresAt != "" {
+			expiresAt, err := model.ParseExpiry(rec.ExpiresAt)
+			if err != nil {
+				return err
+			}
+			left := time.Until(*expiresAt)
+			if left <= 0 {
+				return fmt.Errorf("already expired at %s", rec.ExpiresAt)
+			}
+			ttl = left.String()
+		}
+
+		item := map[string]string{"key": rec.Key, "value": rec.Value}
+		if ttl != "" {
+			item["ttl"] = ttl
+		}
+		b.plain = append(b.plain, item)
+	}
+
+	b.keys[rec.Key] = rec.VectorClock == ""
+	b.lines = append(b.lines, line)
+	b.records = append(b.records, rec)
+	return nil
+}
+
+func (s *MainService) flushImport(b *importBatch, report *bulk.Report) {
+	if len(b.records) == 0 {
+		return
+	}
+
+	//results come back in the order items went in, map them back to their lines
+	var versionLines, plainLines []int
+	for i, rec := range b.records {
+		if rec.VectorClock != "" {
+			versionLines = append(versionLines, b.lines[i])
+		} else {
+			plainLines = append(plainLines, b.lines[i])
+		}
+	}
+
+	record := func(results []BatchResult, lines []int, err error) {
+		for i, line := range lines {
+			switch {
+			case err != nil:
+				report.AddError(bulk.ImportError{Line: line, Key: results[i].Key, Error: err.Error()})
+			case results[i].Error != "":
+				report.AddError(bulk.ImportError{Line: line, Key: results[i].Key, Error: results[i].Error})
+			default:
+				report.Stored++
+			}
+		}
+	}
+
+	if len(b.versions) > 0 {
+		results, err := s.Ingest(b.versions)
+		if err != nil {
+			results = make([]BatchResult, len(b.versions))
+			for i, item := range b.versions {
+				results[i].Key = item.Key
+			}
+		}
+		record(results, versionLines, err)
+	}
+	if len(b.plain) > 0 {
+		results, err := s.MSet(b.plain)
+		if err != nil {
+			results = make([]BatchResult, len(b.plain))
+			for i, item := range b.plain {
+				results[i].Key = item["key"]
+			}
+		}
+		record(results, plainLines, err)
+	}
+
+	log.Printf("[IMPORT] Progress, %d lines, %d stored, %d failed", report.Lines, report.Stored, report.Failed)
+}
+
+// Export writes every live key with prefix, one record per sibling with its
+// vector clock so importing the file recreates the same versions
+func (s *MainService) Export(format, prefix string, w io.Writer) (int, error) {
+	writer, err := bulk.NewWriter(format, w)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	cursor := ""
+	for {
+		page, err := s.ListKeys(prefix, cursor, MaxKeysLimit)
+		if err != nil {
+			return count, err
+		}
+
+		if len(page.Keys) > 0 {
+			results, err := s.MGet(page.Keys)
+			if err != nil {
+				return count, err
+			}
+			for _, res := range results {
+				//deleted or expired since it was listed
+				if res.Error == batchKeyNotFound {
+					continue
+				}
+				//a key that couldn't be read would be missing from the file without a trace
+				if res.Error != "" {
+					return count, fmt.Errorf("failed to read key '%s': %s", res.Key, res.Error)
+				}
+				for _, v := range res.Versions {
+					err := writer.Write(bulk.Record{
+						Key:         res.Key,
+						Value:       v.Value,
+						VectorClock: v.VectorClock,
+						ExpiresAt:   v.ExpiresAt,
+					})
+					if err != nil {
+						return count, err
+					}
+					count++
+				}
+			}
+			if err := writer.Flush(); err != nil {
+				return count, err
+			}
+			log.Printf("[EXPORT] Progress, %d versions written", count)
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+
+	return count, writer.Flush()
+}
@@ -1,8 +1,41 @@
 package mainserver
 
-import "log"
+import (
+	"log"
+	"time"
+
+	"github.com/rupeshx80/consistent-hashing/pkg/model"
+)
+
+//cache nodes stamp versions a moment before the DB does, replay a little extra to cover that
+const rehydrateMargin = time.Minute
 
 func InitializeCache(service *MainService) {
+	since, ok := service.cacheClient.SyncPoint()
+	if !ok {
+		log.Printf("[CACHE] Some cache node has no persisted data, doing a full rehydration")
+		fullRehydrate(service)
+		return
+	}
+
+	since = since.Add(-rehydrateMargin)
+	versions, err := service.repository.GetVersionsSince(since)
+	if err != nil {
+		log.Printf("[CACHE] Failed to fetch versions since %v: %v", since, err)
+		return
+	}
+
+	count := 0
+	for _, v := range versions {
+		if writeVersionToCache(service, v) {
+			count++
+		}
+	}
+
+	log.Printf("[CACHE] Incrementally rehydrated %d versions written since %v", count, since)
+}
+
+func fullRehydrate(service *MainService) {
 	keys, err := service.repository.GetAllKeys()
 	if err != nil {
 		log.Printf("[CACHE] Failed to fetch keys for cache rehydration: %v", err)
@@ -16,14 +49,22 @@ func InitializeCache(service *MainService) {
 			continue
 		}
 		for _, v := range versions {
-			err := service.cacheClient.WriteToCache(key, v.Value, v.VectorClock)
-			if err != nil {
-				log.Printf("[CACHE] Failed to write key='%s' to cache: %v", key, err)
-				continue
+			if writeVersionToCache(service, v) {
+				count++
 			}
-			count++
 		}
 	}
 
 	log.Printf("[CACHE] Rehydrated %d versions into cache", count)
 }
+
+//expired versions are written too, they still supersede what they replaced and
+//the node purges them together
+func writeVersionToCache(service *MainService, v model.KeyValue) bool {
+	err := service.cacheClient.WriteToCache(v.Key, v.Value, v.VectorClock, model.FormatExpiry(v.ExpiresAt), v.Tombstone)
+	if err != nil {
+		log.Printf("[CACHE] Failed to write key='%s' to cache: %v", v.Key, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,70 @@
+package mainserver
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/rupeshx80/consistent-hashing/pkg/vclock"
+)
+
+// CausalContextHeader carries the merged vector clock of everything a client read,
+// the token is opaque to clients, they only echo it back on the next write
+const CausalContextHeader = "X-Causal-Context"
+
+// ErrInvalidCausalContext is a token that isn't one this server handed out
+var ErrInvalidCausalContext = errors.New("invalid causal context")
+
+func encodeCausalContext(vc string) string {
+	if vc == "" {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(serializeVC(parseVC(vc))))
+}
+
+func decodeCausalContext(token string) (string, error) {
+	if token == "" {
+		return "", nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidCausalContext, err)
+	}
+
+	vc := parseVC(string(raw))
+	if len(vc) == 0 {
+		return "", fmt.Errorf("%w: empty vector clock", ErrInvalidCausalContext)
+	}
+	return serializeVC(vc), nil
+}
+
+// descends reports whether a has seen every event b has seen
+func descends(a, b map[string]int) bool {
+	return vclock.Descends(a, b)
+}
+
+// pruneDominated drops every version whose vector clock is an ancestor of another one,
+// what is left are the concurrent siblings a client still has to reconcile
+func pruneDominated(versions []VersionedValue) []VersionedValue {
+	if len(versions) < 2 {
+		return versions
+	}
+
+	clocks := make([]string, len(versions))
+	for i, v := range versions {
+		clocks[i] = v.VectorClock
+	}
+
+	same := func(a, b int) bool {
+		return versions[a].Value == versions[b].Value && versions[a].Tombstone == versions[b].Tombstone
+	}
+
+	out := make([]VersionedValue, 0, len(versions))
+	for i, v := range versions {
+		if !vclock.Dominated(clocks, i, same) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,155 @@
+package mainserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rupeshx80/consistent-hashing/pkg/changelog"
+)
+
+const defaultChangesWait = 30 * time.Second
+
+// Changes serves /changes?after=&prefix=&limit=&wait=. Plain requests long-poll
+// and answer with a page, "Accept: text/event-stream" (or ?stream=sse) keeps the
+// connection open and pushes every change as an event whose id is its offset,
+// so a reconnect resumes with Last-Event-ID
+func (mc *MainController) Changes(c *gin.Context) {
+	sse := c.Query("stream") == "sse" || strings.Contains(c.GetHeader("Accept"), "text/event-stream")
+
+	after := uint64(0)
+	from := c.Query("after")
+	if from == "" && sse {
+		from = c.GetHeader("Last-Event-ID")
+	}
+	if from != "" {
+		n, err := strconv.ParseUint(from, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "after must be a change offset"})
+			return
+		}
+		after = n
+	}
+
+	if sse {
+		mc.streamChanges(c, after)
+		return
+	}
+
+	limit := 0
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive number"})
+			return
+		}
+		limit = n
+	}
+
+	wait := defaultChangesWait
+	if v := c.Query("wait"); v != "" {
+		d, err := parseWait(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		wait = d
+	}
+
+	page, err := mc.service.Changes(c.Request.Context(), after, c.Query("prefix"), limit, wait)
+	if err != nil {
+		mc.changesError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+func (mc *MainController) streamChanges(c *gin.Context, after uint64) {
+	//checked before the stream starts, afterwards there is no status to send
+	if err := mc.service.changesReadable(after); err != nil {
+		mc.changesError(c, err)
+		return
+	}
+
+	startSSE(c)
+	send := func(ch changelog.Change) error {
+		return writeSSE(c, strconv.FormatUint(ch.Offset, 10), "change", ch)
+	}
+
+	err := mc.service.StreamChanges(c.Request.Context(), after, c.Query("prefix"), send, sseHeartbeat(c))
+	mc.endSSE(c, "Change stream", err)
+}
+
+func startSSE(c *gin.Context) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+}
+
+// writeSSE sends one event, id is what the client echoes as Last-Event-ID
+func writeSSE(c *gin.Context, id, event string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(c.Writer, "id: %s\nevent: %s\ndata: %s\n\n", id, event, data); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
+}
+
+//a comment line keeps proxies from closing an idle stream
+func sseHeartbeat(c *gin.Context) func() error {
+	return func() error {
+		if _, err := c.Writer.WriteString(": keepalive\n\n"); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	}
+}
+
+//the status line is long gone, a truncated offset is reported as an error event
+func (mc *MainController) endSSE(c *gin.Context, what string, err error) {
+	if errors.Is(err, changelog.ErrTruncated) {
+		data, _ := json.Marshal(gin.H{"error": err.Error(), "oldest": mc.service.OldestChange()})
+		fmt.Fprintf(c.Writer, "event: error\ndata: %s\n\n", data)
+		c.Writer.Flush()
+		return
+	}
+	if err != nil {
+		log.Printf("[CONTROLLER] %s ended: %v", what, err)
+	}
+}
+
+func (mc *MainController) changesError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, changelog.ErrTruncated):
+		c.JSON(http.StatusGone, gin.H{"error": err.Error(), "oldest": mc.service.OldestChange()})
+	case errors.Is(err, ErrChangesDisabled):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+//a go duration or plain seconds, 0 answers right away
+func parseWait(v string) (time.Duration, error) {
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid wait '%s'", v)
+	}
+	return d, nil
+}
@@ -0,0 +1,186 @@
+package mainserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/rupeshx80/consistent-hashing/pkg/changelog"
+	"github.com/rupeshx80/consistent-hashing/pkg/quorum"
+)
+
+const (
+	DefaultChangesLimit = 100
+	MaxChangesLimit     = 1000
+	MaxChangesWait      = 60 * time.Second
+)
+
+var ErrChangesDisabled = errors.New("change feed is not enabled")
+
+// ErrChangeNotRecorded means a write is stored but missing from the change log,
+// it is not reported as accepted so the client retries it and feed readers,
+// watchers and replication don't silently miss it
+var ErrChangeNotRecorded = errors.New("write stored but not recorded in the change log")
+
+// recordChange appends an accepted write to the change log, callers fail the
+// write when it returns an error
+func (s *MainService) recordChange(key string, v quorum.VersionedValue) error {
+	return s.recordChangeFrom("", key, v)
+}
+
+// recordChangeFrom tags the change with the site it was replicated from so the
+// replicator doesn't ship it back
+func (s *MainService) recordChangeFrom(origin, key string, v quorum.VersionedValue) error {
+	if s.changes == nil {
+		return nil
+	}
+
+	op := changelog.OpSet
+	if v.Tombstone {
+		op = changelog.OpDelete
+	}
+	_, err := s.changes.Append(changelog.Change{
+		Op:          op,
+		Key:         key,
+		Value:       v.Value,
+		VectorClock: v.VectorClock,
+		ExpiresAt:   v.ExpiresAt,
+		Origin:      origin,
+	})
+	if err != nil {
+		log.Printf("[CHANGES] Failed to record %s of key='%s': %v", op, key, err)
+		return fmt.Errorf("%w: %v", ErrChangeNotRecorded, err)
+	}
+	return nil
+}
+
+// recordRepair puts a read repair in the change log, the repaired node can answer
+// a later read with a sibling set that differs from what it served before, so
+// watchers re-read the key. It is not a client write, a failure is only logged
+func (s *MainService) recordRepair(key string, v quorum.VersionedValue) {
+	if s.changes == nil {
+		return
+	}
+	_, err := s.changes.Append(changelog.Change{
+		Op:          changelog.OpRepair,
+		Key:         key,
+		Value:       v.Value,
+		VectorClock: v.VectorClock,
+		ExpiresAt:   v.ExpiresAt,
+	})
+	if err != nil {
+		log.Printf("[CHANGES] Failed to record repair of key='%s': %v", key, err)
+	}
+}
+
+// ChangesPage is one long-poll answer, Next is the offset to resume after
+type ChangesPage struct {
+	Changes []changelog.Change `json:"changes"`
+	Next    uint64             `json:"next"`
+}
+
+// Changes returns changes after offset "after" with prefix, waiting up to wait
+// for the first one when there is nothing new yet
+func (s *MainService) Changes(ctx context.Context, after uint64, prefix string, limit int, wait time.Duration) (*ChangesPage, error) {
+	if s.changes == nil {
+		return nil, ErrChangesDisabled
+	}
+	if limit <= 0 {
+		limit = DefaultChangesLimit
+	}
+	if limit > MaxChangesLimit {
+		limit = MaxChangesLimit
+	}
+	if wait > MaxChangesWait {
+		wait = MaxChangesWait
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		//take the channel first, an append between Read and the wait still wakes us
+		notify := s.changes.Wait()
+		changes, next, err := s.changes.Read(after, prefix, limit)
+		if err != nil {
+			return nil, err
+		}
+		if len(changes) > 0 || wait <= 0 {
+			return &ChangesPage{Changes: nonNil(changes), Next: next}, nil
+		}
+		after = next
+
+		select {
+		case <-notify:
+		case <-timer.C:
+			return &ChangesPage{Changes: []changelog.Change{}, Next: after}, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// StreamChanges calls send for every change after offset "after" with prefix,
+// old ones first and then new ones as they are appended, until ctx is done or
+// send fails. heartbeat runs when nothing was sent for a while
+func (s *MainService) StreamChanges(ctx context.Context, after uint64, prefix string, send func(c changelog.Change) error, heartbeat func() error) error {
+	if s.changes == nil {
+		return ErrChangesDisabled
+	}
+
+	ticker := time.NewTicker(15 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		notify := s.changes.Wait()
+		changes, next, err := s.changes.Read(after, prefix, MaxChangesLimit)
+		if err != nil {
+			return err
+		}
+		for _, c := range changes {
+			if err := send(c); err != nil {
+				return err
+			}
+		}
+		after = next
+		if len(changes) == MaxChangesLimit {
+			continue
+		}
+
+		select {
+		case <-notify:
+		case <-ticker.C:
+			if err := heartbeat(); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func nonNil(changes []changelog.Change) []changelog.Change {
+	if changes == nil {
+		return []changelog.Change{}
+	}
+	return changes
+}
+
+// OldestChange is where a consumer whose offset was truncated starts again
+func (s *MainService) OldestChange() uint64 {
+	if s.changes == nil {
+		return 0
+	}
+	return s.changes.Oldest()
+}
+
+//checks that after can still be read from
+func (s *MainService) changesReadable(after uint64) error {
+	if s.changes == nil {
+		return ErrChangesDisabled
+	}
+	_, _, err := s.changes.Read(after, "", 1)
+	return err
+}
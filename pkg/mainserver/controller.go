@@ -1,9 +1,12 @@
 package mainserver
 
 import (
+	"errors"
 	"net/http"
      "log"
+	"strings"
 	"github.com/gin-gonic/gin"
+	"github.com/rupeshx80/consistent-hashing/pkg/replication"
 )
 
 type MainController struct {
@@ -23,19 +26,50 @@ func (mc *MainController) Put(c *gin.Context) {
 		return
 	}
 
-	if err := mc.service.Put(body); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	//a write replicated from another site keeps its clock, tombstone included
+	if source := c.GetHeader(replication.SourceHeader); source != "" {
+		mc.putReplicated(c, source, body)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "new version stored successfully"})
+	//tombstones are only written through DELETE
+	delete(body, "tombstone")
+
+	//header wins over a context sent in the body
+	if token := c.GetHeader(CausalContextHeader); token != "" {
+		body["context"] = token
+	}
+
+	//If-Match makes this a compare-and-set on the causal context
+	if etag := c.GetHeader("If-Match"); etag != "" {
+		body["ifMatch"] = strings.Trim(etag, `"`)
+	}
+
+	causalContext, err := mc.service.Put(body)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.Header(CausalContextHeader, causalContext)
+	c.Header("ETag", `"`+causalContext+`"`)
+	c.JSON(http.StatusOK, gin.H{"message": "new version stored successfully", "context": causalContext})
 }
 
 func (mc *MainController) Get(c *gin.Context) {
 	key := c.Param("key")
 
+	//point in time reads come from the stored history, not the replicas
+	if asOf := c.Query("asOf"); asOf != "" {
+		mc.getAsOf(c, key, asOf)
+		return
+	}
+
 	versions, err := mc.service.Get(key)
-	
+	if errors.Is(err, ErrLWTKey) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		log.Printf("[CONTROLLER] Error getting key='%s', err=%v", key, err)
 		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
@@ -49,9 +83,45 @@ func (mc *MainController) Get(c *gin.Context) {
 	}
 
 	log.Printf("[CONTROLLER] Returning %d versions for key='%s'", len(versions), key)
+	causalContext := mc.service.CausalContext(versions)
+	c.Header(CausalContextHeader, causalContext)
+	c.Header("ETag", `"`+causalContext+`"`)
 	c.JSON(http.StatusOK, versions)
 }
 
+// Delete writes a tombstone, the causal context and If-Match headers work like on /set
+func (mc *MainController) Delete(c *gin.Context) {
+	body := map[string]string{"key": c.Param("key")}
+
+	if token := c.GetHeader(CausalContextHeader); token != "" {
+		body["context"] = token
+	}
+	if etag := c.GetHeader("If-Match"); etag != "" {
+		body["ifMatch"] = strings.Trim(etag, `"`)
+	}
+
+	causalContext, err := mc.service.Delete(body)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.Header(CausalContextHeader, causalContext)
+	c.JSON(http.StatusOK, gin.H{"message": "key deleted successfully", "context": causalContext})
+}
+
+//writeError maps a failed /set or /delete, a bad token is the client's fault
+func writeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidCausalContext), errors.Is(err, ErrLWTKey), errors.Is(err, ErrSystemKey):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrPreconditionFailed):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
 func (mc *MainController) GetPreferenceList(c *gin.Context) {
 	key := c.Query("key")
 	if key == "" {
@@ -59,11 +129,12 @@ func (mc *MainController) GetPreferenceList(c *gin.Context) {
 		return
 	}
 
-	list, err := mc.service.GetPreferenceList(key)
+	//hashTag and position show which keys are co-located
+	placement, err := mc.service.Placement(key)
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(200, gin.H{"preferenceList": list})
+	c.JSON(200, placement)
 }
@@ -0,0 +1,152 @@
+package mainserver
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rupeshx80/consistent-hashing/pkg/crdt"
+)
+
+type counterRequest struct {
+	Type  crdt.Type `json:"type"`
+	Delta int64     `json:"delta"`
+}
+
+type setElementRequest struct {
+	Element string `json:"element" binding:"required"`
+}
+
+type registerRequest struct {
+	Value string `json:"value"`
+}
+
+type mapFieldRequest struct {
+	Field string `json:"field" binding:"required"`
+	Value string `json:"value"`
+	Delta int64  `json:"delta"`
+}
+
+func (mc *MainController) GetCRDT(c *gin.Context) {
+	key := c.Param("key")
+
+	value, err := mc.service.GetCRDT(key)
+	if err != nil {
+		log.Printf("[CONTROLLER] Error getting crdt key='%s', err=%v", key, err)
+		if errors.Is(err, ErrCRDTTypeMismatch) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, value)
+}
+
+func (mc *MainController) IncrementCounter(c *gin.Context) {
+	var req counterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
+		return
+	}
+
+	if req.Type == "" {
+		req.Type = crdt.TypePNCounter
+	}
+	if req.Delta == 0 {
+		req.Delta = 1
+	}
+
+	value, err := mc.service.IncrementCounter(c.Param("key"), req.Type, req.Delta)
+	mc.respondCRDT(c, value, err)
+}
+
+func (mc *MainController) AddToSet(c *gin.Context) {
+	var req setElementRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "element is required"})
+		return
+	}
+
+	value, err := mc.service.AddToSet(c.Param("key"), req.Element)
+	mc.respondCRDT(c, value, err)
+}
+
+func (mc *MainController) RemoveFromSet(c *gin.Context) {
+	var req setElementRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "element is required"})
+		return
+	}
+
+	value, err := mc.service.RemoveFromSet(c.Param("key"), req.Element)
+	mc.respondCRDT(c, value, err)
+}
+
+func (mc *MainController) SetRegister(c *gin.Context) {
+	var req registerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
+		return
+	}
+
+	value, err := mc.service.SetRegister(c.Param("key"), req.Value)
+	mc.respondCRDT(c, value, err)
+}
+
+func (mc *MainController) SetMapField(c *gin.Context) {
+	var req mapFieldRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "field is required"})
+		return
+	}
+
+	value, err := mc.service.SetMapField(c.Param("key"), req.Field, req.Value)
+	mc.respondCRDT(c, value, err)
+}
+
+func (mc *MainController) IncrementMapField(c *gin.Context) {
+	var req mapFieldRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "field is required"})
+		return
+	}
+
+	if req.Delta == 0 {
+		req.Delta = 1
+	}
+
+	value, err := mc.service.IncrementMapField(c.Param("key"), req.Field, req.Delta)
+	mc.respondCRDT(c, value, err)
+}
+
+func (mc *MainController) RemoveMapField(c *gin.Context) {
+	var req mapFieldRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "field is required"})
+		return
+	}
+
+	value, err := mc.service.RemoveMapField(c.Param("key"), req.Field)
+	mc.respondCRDT(c, value, err)
+}
+
+func (mc *MainController) respondCRDT(c *gin.Context, value *CRDTValue, err error) {
+	if err != nil {
+		log.Printf("[CONTROLLER] CRDT update failed for key='%s', err=%v", c.Param("key"), err)
+		if errors.Is(err, ErrCRDTTypeMismatch) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, ErrLWTKey) || errors.Is(err, ErrSystemKey) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, value)
+}
@@ -0,0 +1,252 @@
+package mainserver
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/rupeshx80/consistent-hashing/pkg/crdt"
+	"github.com/rupeshx80/consistent-hashing/pkg/model"
+	"github.com/rupeshx80/consistent-hashing/pkg/storage"
+)
+
+var ErrCRDTTypeMismatch = errors.New("key holds a different value type")
+
+type CRDTValue struct {
+	Key         string      `json:"key"`
+	Type        crdt.Type   `json:"type"`
+	Value       interface{} `json:"value"`
+	VectorClock string      `json:"vectorClock,omitempty"`
+}
+
+func (s *MainService) GetCRDT(key string) (*CRDTValue, error) {
+	if key == "" {
+		return nil, fmt.Errorf("key is required")
+	}
+
+	versions, err := s.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("key not found")
+	}
+
+	merged, ok, err := s.mergeCRDTVersions(versions)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: '%s' is not a crdt", ErrCRDTTypeMismatch, key)
+	}
+
+	return &CRDTValue{
+		Key:         key,
+		Type:        merged.Type(),
+		Value:       merged.Value(),
+		VectorClock: mergedVectorClock(versions),
+	}, nil
+}
+
+// UpdateCRDT merges every sibling of key, applies op on behalf of the key's
+// coordinator and stores the result as a version that supersedes all of them
+func (s *MainService) UpdateCRDT(key string, t crdt.Type, op func(c crdt.CRDT, nodeID string) error) (*CRDTValue, error) {
+	if key == "" {
+		return nil, fmt.Errorf("key is required")
+	}
+
+	defer s.lockKeys(key)()
+
+	current := crdt.CRDT(nil)
+	clientVC := ""
+
+	//only a key that doesn't exist starts from an empty crdt, a failed read must not
+	//reset it since the new version would supersede everything stored
+	versions, err := s.Get(key)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("failed to read current value: %w", err)
+	}
+	if err == nil && len(versions) > 0 {
+		merged, ok, err := s.mergeCRDTVersions(versions)
+		if err != nil {
+			return nil, err
+		}
+		if !ok || merged.Type() != t {
+			return nil, fmt.Errorf("%w: '%s' is not a %s", ErrCRDTTypeMismatch, key, t)
+		}
+		current = merged
+		clientVC = mergedVectorClock(versions)
+	} else {
+		//first write for this key
+		current, err = crdt.New(t)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, node := s.ring.GetNode(key)
+	nodeID := s.ring.NodeID(node)
+
+	if err := op(current, nodeID); err != nil {
+		return nil, err
+	}
+
+	encoded, err := crdt.Encode(current)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.put(map[string]string{"key": key, "value": encoded, "vectorClock": clientVC}); err != nil {
+		return nil, err
+	}
+
+	log.Printf("[CRDT] Updated key='%s' type=%s node=%s", key, t, nodeID)
+	return &CRDTValue{Key: key, Type: current.Type(), Value: current.Value()}, nil
+}
+
+func (s *MainService) IncrementCounter(key string, t crdt.Type, delta int64) (*CRDTValue, error) {
+	if t != crdt.TypeGCounter && t != crdt.TypePNCounter {
+		return nil, fmt.Errorf("unsupported counter type: %s", t)
+	}
+
+	return s.UpdateCRDT(key, t, func(c crdt.CRDT, nodeID string) error {
+		return crdt.Increment(c, nodeID, delta)
+	})
+}
+
+func (s *MainService) AddToSet(key, element string) (*CRDTValue, error) {
+	return s.UpdateCRDT(key, crdt.TypeORSet, func(c crdt.CRDT, nodeID string) error {
+		c.(*crdt.ORSet).Add(element, crdt.NewTag(nodeID))
+		return nil
+	})
+}
+
+func (s *MainService) RemoveFromSet(key, element string) (*CRDTValue, error) {
+	return s.UpdateCRDT(key, crdt.TypeORSet, func(c crdt.CRDT, nodeID string) error {
+		c.(*crdt.ORSet).Remove(element)
+		return nil
+	})
+}
+
+func (s *MainService) SetRegister(key, value string) (*CRDTValue, error) {
+	return s.UpdateCRDT(key, crdt.TypeLWWRegister, func(c crdt.CRDT, nodeID string) error {
+		c.(*crdt.LWWRegister).Set(value, time.Now().UnixNano(), nodeID)
+		return nil
+	})
+}
+
+func (s *MainService) SetMapField(key, field, value string) (*CRDTValue, error) {
+	return s.UpdateCRDT(key, crdt.TypeORMap, func(c crdt.CRDT, nodeID string) error {
+		reg, err := c.(*crdt.ORMap).Update(field, crdt.TypeLWWRegister, crdt.NewTag(nodeID))
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrCRDTTypeMismatch, err)
+		}
+		reg.(*crdt.LWWRegister).Set(value, time.Now().UnixNano(), nodeID)
+		return nil
+	})
+}
+
+func (s *MainService) IncrementMapField(key, field string, delta int64) (*CRDTValue, error) {
+	return s.UpdateCRDT(key, crdt.TypeORMap, func(c crdt.CRDT, nodeID string) error {
+		counter, err := c.(*crdt.ORMap).Update(field, crdt.TypePNCounter, crdt.NewTag(nodeID))
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrCRDTTypeMismatch, err)
+		}
+		return crdt.Increment(counter, nodeID, delta)
+	})
+}
+
+func (s *MainService) RemoveMapField(key, field string) (*CRDTValue, error) {
+	return s.UpdateCRDT(key, crdt.TypeORMap, func(c crdt.CRDT, nodeID string) error {
+		c.(*crdt.ORMap).Remove(field)
+		return nil
+	})
+}
+
+func (s *MainService) mergeCRDTVersions(versions []VersionedValue) (crdt.CRDT, bool, error) {
+	values := make([]string, 0, len(versions))
+	for _, v := range versions {
+		values = append(values, v.Value)
+	}
+
+	merged, ok, err := crdt.MergeValues(values)
+	if err != nil {
+		return nil, ok, fmt.Errorf("%w: %v", ErrCRDTTypeMismatch, err)
+	}
+	return merged, ok, nil
+}
+
+// mergeCRDTSiblings collapses sibling CRDT versions into a single version,
+// plain values are returned untouched since only the client can resolve those.
+// A tombstone that supersedes the values deletes them, a concurrent one is left
+// next to the merged value. The merged value lives as long as its longest lived
+// sibling, it holds the state of all of them
+func (s *MainService) mergeCRDTSiblings(key string, versions []VersionedValue) []VersionedValue {
+	versions = pruneDominated(versions)
+
+	var live, tombstones []VersionedValue
+	for _, v := range versions {
+		if v.Tombstone {
+			tombstones = append(tombstones, v)
+		} else {
+			live = append(live, v)
+		}
+	}
+	if len(live) < 2 {
+		return versions
+	}
+
+	merged, ok, err := s.mergeCRDTVersions(live)
+	if !ok {
+		return versions
+	}
+	if err != nil {
+		log.Printf("[CRDT] Could not merge siblings for key='%s': %v", key, err)
+		return versions
+	}
+
+	encoded, err := crdt.Encode(merged)
+	if err != nil {
+		log.Printf("[CRDT] Could not encode merged value for key='%s': %v", key, err)
+		return versions
+	}
+
+	latest := live[0].CreatedAt
+	for _, v := range live[1:] {
+		if v.CreatedAt > latest {
+			latest = v.CreatedAt
+		}
+	}
+
+	log.Printf("[CRDT] Merged %d siblings for key='%s'", len(live), key)
+	return append([]VersionedValue{{
+		Value:       encoded,
+		VectorClock: mergedVectorClock(live),
+		CreatedAt:   latest,
+		ExpiresAt:   latestExpiry(live),
+	}}, tombstones...)
+}
+
+//latestExpiry is the expiry of the longest lived version, empty when one never expires
+func latestExpiry(versions []VersionedValue) string {
+	var latest *time.Time
+	for _, v := range versions {
+		expiresAt, err := model.ParseExpiry(v.ExpiresAt)
+		if err != nil || expiresAt == nil {
+			return ""
+		}
+		if latest == nil || expiresAt.After(*latest) {
+			latest = expiresAt
+		}
+	}
+	return model.FormatExpiry(latest)
+}
+
+func mergedVectorClock(versions []VersionedValue) string {
+	merged := map[string]int{}
+	for _, v := range versions {
+		mergeMapMax(merged, parseVC(v.VectorClock))
+	}
+	return serializeVC(merged)
+}
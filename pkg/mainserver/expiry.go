@@ -0,0 +1,25 @@
+package mainserver
+
+import (
+	"log"
+	"time"
+)
+
+// StartExpiryPurger periodically hard deletes expired versions from the DB
+func StartExpiryPurger(service *MainService, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			purged, err := service.repository.PurgeExpired(time.Now())
+			if err != nil {
+				log.Printf("[EXPIRY] Failed to purge expired versions: %v", err)
+				continue
+			}
+			if purged > 0 {
+				log.Printf("[EXPIRY] Purged %d expired versions", purged)
+			}
+		}
+	}()
+}
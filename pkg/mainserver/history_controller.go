@@ -0,0 +1,69 @@
+package mainserver
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// History serves /history/:key?cursor=&limit=, versions come newest first
+func (mc *MainController) History(c *gin.Context) {
+	key := c.Param("key")
+
+	limit := 0
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive number"})
+			return
+		}
+		limit = n
+	}
+
+	page, err := mc.service.History(key, c.Query("cursor"), limit)
+	if err != nil {
+		if errors.Is(err, ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("[CONTROLLER] Error reading history of key='%s', err=%v", key, err)
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// getAsOf answers /get/:key?asOf=, the timestamp is RFC3339 or unix seconds
+func (mc *MainController) getAsOf(c *gin.Context, key, raw string) {
+	asOf, err := parseAsOf(raw)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	versions, err := mc.service.GetAsOf(key, asOf)
+	if err != nil {
+		log.Printf("[CONTROLLER] Error getting key='%s' as of %v, err=%v", key, asOf, err)
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, versions)
+}
+
+func parseAsOf(raw string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, raw)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid asOf '%s', use RFC3339 or unix seconds", raw)
+	}
+	return t, nil
+}
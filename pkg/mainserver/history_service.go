@@ -0,0 +1,151 @@
+package mainserver
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rupeshx80/consistent-hashing/pkg/model"
+)
+
+const (
+	DefaultHistoryLimit = 50
+	MaxHistoryLimit     = 500
+)
+
+type HistoryPage struct {
+	Key        string           `json:"key"`
+	Versions   []VersionedValue `json:"versions"` //newest first, tombstones included
+	NextCursor string           `json:"nextCursor,omitempty"`
+}
+
+//a history cursor points at the last version of the page by (created_at, id),
+//versions written later sort in front of it so pages never shift
+func encodeHistoryCursor(kv model.KeyValue) string {
+	raw := fmt.Sprintf("%d:%d", kv.CreatedAt.UnixNano(), kv.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeHistoryCursor(cursor string) (int64, uint, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+
+	nanos, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return 0, 0, ErrInvalidCursor
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return 0, 0, ErrInvalidCursor
+	}
+	i, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return 0, 0, ErrInvalidCursor
+	}
+	return n, uint(i), nil
+}
+
+//oldest first by creation time, the id breaks ties between versions stored in the same instant
+func sortVersions(versions []model.KeyValue) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		if !versions[i].CreatedAt.Equal(versions[j].CreatedAt) {
+			return versions[i].CreatedAt.Before(versions[j].CreatedAt)
+		}
+		return versions[i].ID < versions[j].ID
+	})
+}
+
+func toVersionedValue(kv model.KeyValue) VersionedValue {
+	return VersionedValue{
+		Value:       kv.Value,
+		VectorClock: kv.VectorClock,
+		CreatedAt:   kv.CreatedAt.String(),
+		ExpiresAt:   model.FormatExpiry(kv.ExpiresAt),
+		Tombstone:   kv.Tombstone,
+	}
+}
+
+// History pages through every stored version of key newest first, including the
+// ones later writes superseded, as far back as the retention policy keeps them
+func (s *MainService) History(key, cursor string, limit int) (*HistoryPage, error) {
+	if key == "" {
+		return nil, fmt.Errorf("key is required")
+	}
+	if limit <= 0 {
+		limit = DefaultHistoryLimit
+	}
+	if limit > MaxHistoryLimit {
+		limit = MaxHistoryLimit
+	}
+
+	var beforeNanos int64
+	var beforeID uint
+	if cursor != "" {
+		var err error
+		if beforeNanos, beforeID, err = decodeHistoryCursor(cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	versions, err := s.repository.GetAllVersions(key)
+	if err != nil {
+		return nil, err
+	}
+	sortVersions(versions)
+
+	page := &HistoryPage{Key: key, Versions: []VersionedValue{}}
+	for i := len(versions) - 1; i >= 0; i-- {
+		kv := versions[i]
+		if cursor != "" {
+			nanos := kv.CreatedAt.UnixNano()
+			if nanos > beforeNanos || (nanos == beforeNanos && kv.ID >= beforeID) {
+				continue
+			}
+		}
+
+		if len(page.Versions) == limit {
+			page.NextCursor = encodeHistoryCursor(versions[i+1])
+			break
+		}
+		page.Versions = append(page.Versions, toVersionedValue(kv))
+	}
+
+	log.Printf("[HISTORY] Returned %d versions of key='%s'", len(page.Versions), key)
+	return page, nil
+}
+
+// GetAsOf returns the sibling set a read would have seen at asOf, built from the
+// versions written until then with expiry evaluated at that moment
+func (s *MainService) GetAsOf(key string, asOf time.Time) ([]VersionedValue, error) {
+	if key == "" {
+		return nil, fmt.Errorf("key is required")
+	}
+
+	versions, err := s.repository.GetAllVersions(key)
+	if err != nil {
+		return nil, err
+	}
+	sortVersions(versions)
+
+	var visible []VersionedValue
+	for _, kv := range versions {
+		if kv.CreatedAt.After(asOf) {
+			break
+		}
+		visible = append(visible, toVersionedValue(kv))
+	}
+
+	resolved := s.resolveSiblingsAt(key, visible, asOf)
+	if len(resolved) == 0 {
+		return nil, fmt.Errorf("key not found as of %s", asOf.UTC().Format(time.RFC3339Nano))
+	}
+
+	log.Printf("[HISTORY] Read key='%s' as of %v, %d siblings", key, asOf, len(resolved))
+	return resolved, nil
+}
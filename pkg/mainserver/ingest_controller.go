@@ -0,0 +1,33 @@
+package mainserver
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ingestRequest struct {
+	Items []IngestItem `json:"items" binding:"required"`
+}
+
+// Ingest answers 200 with one result per item, used by restore. The router only
+// lets requests with the admin secret through
+func (mc *MainController) Ingest(c *gin.Context) {
+	var req ingestRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "items are required"})
+		return
+	}
+
+	results, err := mc.service.Ingest(req.Items)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
+
+// TokenRanges lists every range of the ring with the nodes that own it
+func (mc *MainController) TokenRanges(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"ranges": mc.service.TokenRanges()})
+}
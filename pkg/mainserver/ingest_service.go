@@ -0,0 +1,168 @@
+package mainserver
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/rupeshx80/consistent-hashing/pkg/hash-ring"
+	"github.com/rupeshx80/consistent-hashing/pkg/model"
+	"github.com/rupeshx80/consistent-hashing/pkg/quorum"
+	"github.com/rupeshx80/consistent-hashing/pkg/storage"
+)
+
+// IngestItem is a version written elsewhere, a restore or import stores it with
+// its vector clock untouched so siblings and tombstones survive the trip
+type IngestItem struct {
+	Key         string     `json:"key"`
+	Value       string     `json:"value"`
+	VectorClock string     `json:"vectorClock"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+	Tombstone   bool       `json:"tombstone,omitempty"`
+	Origin      string     `json:"-"` //site the version was replicated from, only set by an authenticated Replicate
+}
+
+// Ingest stores raw versions through the ring, one result per item. A key may
+// appear more than once, its versions are applied in the order given
+func (s *MainService) Ingest(items []IngestItem) ([]BatchResult, error) {
+	if len(items) > MaxBatchSize {
+		return nil, fmt.Errorf("batch too large: %d items, max %d", len(items), MaxBatchSize)
+	}
+
+	keys := make([]string, len(items))
+	results := make([]BatchResult, len(items))
+	for i, item := range items {
+		keys[i] = item.Key
+		results[i].Key = item.Key
+
+		switch {
+		case item.Key == "":
+			results[i].Error = "key is required"
+		case item.VectorClock == "":
+			results[i].Error = "vectorClock is required"
+		case isLWTKey(item.Key):
+			results[i].Error = ErrLWTKey.Error()
+		case storage.IsSystemKey(item.Key):
+			results[i].Error = ErrSystemKey.Error()
+		}
+	}
+
+	groups := s.groupByPreferenceList(keys, func(i int) bool { return results[i].Error != "" })
+
+	var wg sync.WaitGroup
+	for _, g := range groups {
+		wg.Add(1)
+		go func(g *replicaGroup) {
+			defer wg.Done()
+			s.ingestGroup(g, items, results)
+		}(g)
+	}
+	wg.Wait()
+
+	log.Printf("[INGEST] Stored %d versions in %d replica sets", len(items), len(groups))
+	return results, nil
+}
+
+//the DB copy is not a ring copy, so like /set every version needs W
+//acknowledgements from its replica set
+func (s *MainService) ingestGroup(g *replicaGroup, items []IngestItem, results []BatchResult) {
+	keys := make([]string, 0, len(g.indexes))
+	for _, i := range g.indexes {
+		keys = append(keys, items[i].Key)
+	}
+	defer s.lockKeys(keys...)()
+
+	var quorumItems []quorum.BatchItem
+	imported := make(map[int]bool)
+	for _, i := range g.indexes {
+		item := items[i]
+		createdAt := item.CreatedAt
+		if createdAt.IsZero() {
+			createdAt = time.Now()
+		}
+
+		isNew, err := s.repository.ImportVersion(model.KeyValue{
+			Key:         item.Key,
+			Value:       item.Value,
+			VectorClock: item.VectorClock,
+			CreatedAt:   createdAt,
+			ExpiresAt:   item.ExpiresAt,
+			Tombstone:   item.Tombstone,
+		})
+		if err != nil {
+			results[i].Error = fmt.Sprintf("failed to save version: %v", err)
+			continue
+		}
+		imported[i] = isNew
+
+		//nodes stamp their own createdAt, sending versions in order keeps them ordered there too
+		quorumItems = append(quorumItems, quorum.BatchItem{
+			Key: item.Key,
+			VersionedValue: quorum.VersionedValue{
+				Value:       item.Value,
+				VectorClock: item.VectorClock,
+				ExpiresAt:   model.FormatExpiry(item.ExpiresAt),
+				Tombstone:   item.Tombstone,
+			},
+		})
+	}
+	if len(quorumItems) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	failed := s.qManager.WriteQuorumBatch(ctx, g.nodes, quorumItems)
+	for _, i := range g.indexes {
+		if results[i].Error != "" {
+			continue
+		}
+		if err, ok := failed[items[i].Key]; ok {
+			if imported[i] {
+				s.unimport(items[i])
+			}
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i].Context = encodeCausalContext(items[i].VectorClock)
+
+		//a version we already had is not a change, a replication retry must not show up twice
+		if !imported[i] {
+			continue
+		}
+		err := s.recordChangeFrom(items[i].Origin, items[i].Key, quorum.VersionedValue{
+			Value:       items[i].Value,
+			VectorClock: items[i].VectorClock,
+			ExpiresAt:   model.FormatExpiry(items[i].ExpiresAt),
+			Tombstone:   items[i].Tombstone,
+		})
+		if err != nil {
+			s.unimport(items[i])
+			results[i].Context = ""
+			results[i].Error = err.Error()
+		}
+	}
+}
+
+//drops the DB copy of a version that failed after it was imported, the retry
+//has to see it as new or it would never be recorded as a change
+func (s *MainService) unimport(item IngestItem) {
+	err := s.repository.RemoveVersion(model.KeyValue{
+		Key:         item.Key,
+		Value:       item.Value,
+		VectorClock: item.VectorClock,
+		Tombstone:   item.Tombstone,
+	})
+	if err != nil {
+		log.Printf("[INGEST] Failed to undo the DB copy of key='%s': %v", item.Key, err)
+	}
+}
+
+// TokenRanges is the current split of the ring, backups walk it range by range
+func (s *MainService) TokenRanges() []hashring.TokenRange {
+	return s.ring.TokenRanges()
+}
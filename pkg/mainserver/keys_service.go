@@ -0,0 +1,182 @@
+package mainserver
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultKeysLimit = 100
+	MaxKeysLimit     = 1000
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+type KeyPage struct {
+	Keys       []string `json:"keys"`
+	NextCursor string   `json:"nextCursor,omitempty"` //empty on the last page
+}
+
+//the cursor is the last key of the page, keys are listed in byte order so
+//a page boundary stays put when nodes join or leave
+func encodeKeyCursor(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func decodeKeyCursor(cursor string) (string, error) {
+	if cursor == "" {
+		return "", nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+	return string(raw), nil
+}
+
+// ListKeys asks every node for its next keys after the cursor and merges them,
+// every key lives on N nodes so up to N-1 nodes may be unreachable. A node only
+// knows its own copies, so the versions of every candidate are read back from a
+// read quorum and merged by vector clock before the key counts as live. A page
+// can hold fewer than limit keys when candidates turned out deleted
+func (s *MainService) ListKeys(prefix, cursor string, limit int) (*KeyPage, error) {
+	if limit <= 0 {
+		limit = DefaultKeysLimit
+	}
+	if limit > MaxKeysLimit {
+		limit = MaxKeysLimit
+	}
+
+	after, err := decodeKeyCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := s.ring.Nodes()
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no nodes available")
+	}
+
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		merged = make(map[string]bool)
+		failed []string
+	)
+
+	//one extra key per node tells us whether another page exists
+	for _, node := range nodes {
+		wg.Add(1)
+		go func(n string) {
+			defer wg.Done()
+
+			keys, err := s.cacheClient.ScanKeys(n, prefix, after, limit+1)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				log.Printf("[KEYS] Scan failed on node=%s: %v", n, err)
+				failed = append(failed, n)
+				return
+			}
+			for _, k := range keys {
+				merged[k] = true
+			}
+		}(node)
+	}
+	wg.Wait()
+
+	if tolerated := s.ring.N - 1; len(failed) > tolerated {
+		return nil, fmt.Errorf("key listing needs all but %d nodes, unreachable: %v", tolerated, failed)
+	}
+
+	keys := make([]string, 0, len(merged))
+	for k := range merged {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	page := &KeyPage{}
+	if len(keys) > limit {
+		keys = keys[:limit]
+		page.NextCursor = encodeKeyCursor(keys[limit-1])
+	}
+
+	page.Keys, err = s.liveKeys(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("[KEYS] Listed %d keys prefix='%s' from %d nodes (%d unreachable)", len(page.Keys), prefix, len(nodes), len(failed))
+	return page, nil
+}
+
+//liveKeys keeps the keys whose merged versions still hold a value, a stale node
+//reporting a value the delete on another node replaced doesn't bring it back
+func (s *MainService) liveKeys(keys []string) ([]string, error) {
+	live := make([]bool, len(keys))
+	groups := s.groupByPreferenceList(keys, func(i int) bool { return false })
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for _, g := range groups {
+		wg.Add(1)
+		go func(g *replicaGroup) {
+			defer wg.Done()
+
+			groupKeys := make([]string, 0, len(g.indexes))
+			for _, i := range g.indexes {
+				groupKeys = append(groupKeys, keys[i])
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			qres, err := s.qManager.ReadQuorumBatch(ctx, g.nodes, groupKeys)
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("key listing read failed for replica set %v: %w", g.nodes, err)
+				}
+				mu.Unlock()
+				return
+			}
+
+			now := time.Now()
+			for _, i := range g.indexes {
+				versions := make([]VersionedValue, 0, len(qres[keys[i]]))
+				for _, v := range qres[keys[i]] {
+					versions = append(versions, VersionedValue{
+						Value:       v.Value,
+						VectorClock: v.VectorClock,
+						ExpiresAt:   v.ExpiresAt,
+						Tombstone:   v.Tombstone,
+					})
+				}
+				live[i] = len(dropTombstones(dropExpired(keys[i], pruneDominated(versions), now))) > 0
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	out := make([]string, 0, len(keys))
+	for i, key := range keys {
+		if live[i] {
+			out = append(out, key)
+		}
+	}
+	return out, nil
+}
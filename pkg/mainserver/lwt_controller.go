@@ -0,0 +1,61 @@
+package mainserver
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type casRequest struct {
+	Key      string  `json:"key" binding:"required"`
+	Expected *string `json:"expected"` //null means the key must not exist yet
+	Value    string  `json:"value"`
+}
+
+func (mc *MainController) CompareAndSet(c *gin.Context) {
+	var req casRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "key is required"})
+		return
+	}
+
+	result, err := mc.service.CompareAndSet(req.Key, req.Expected, req.Value)
+	if errors.Is(err, ErrNotLWTKey) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("[CONTROLLER] LWT cas failed for key='%s', err=%v", req.Key, err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !result.Applied {
+		c.JSON(http.StatusConflict, result)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (mc *MainController) LinearizableGet(c *gin.Context) {
+	key := c.Param("key")
+
+	result, err := mc.service.LinearizableGet(key)
+	if errors.Is(err, ErrNotLWTKey) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("[CONTROLLER] LWT read failed for key='%s', err=%v", key, err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !result.Exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "key not found"})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,79 @@
+package mainserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"log"
+	"time"
+
+	"github.com/rupeshx80/consistent-hashing/pkg/changelog"
+	"github.com/rupeshx80/consistent-hashing/pkg/paxos"
+)
+
+// LWTKeyPrefix marks the keys of the linearizable path. Their value is kept by the
+// paxos acceptors and not as sloppy quorum versions, so /lwt only takes keys with
+// the prefix and every versioned endpoint refuses them. A CAS can therefore never
+// miss a value written through /set
+const LWTKeyPrefix = "lwt:"
+
+// ErrLWTKey is a key used on the wrong side of the lwt split
+var ErrLWTKey = errors.New("lwt keys are only readable and writable through /lwt")
+
+// ErrNotLWTKey is a key without LWTKeyPrefix sent to /lwt
+var ErrNotLWTKey = errors.New("/lwt keys must start with " + LWTKeyPrefix)
+
+func isLWTKey(key string) bool {
+	return strings.HasPrefix(key, LWTKeyPrefix)
+}
+
+// CompareAndSet runs a paxos round over the key's preference list, it is opt-in
+// and lwt keys are kept by the acceptors apart from the sloppy quorum versions
+func (s *MainService) CompareAndSet(key string, expected *string, value string) (*paxos.Result, error) {
+	if key == "" {
+		return nil, fmt.Errorf("key is required")
+	}
+	if !isLWTKey(key) {
+		return nil, ErrNotLWTKey
+	}
+
+	preferenceList := s.ring.GetPreferenceList(key)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := s.proposer.CompareAndSet(ctx, preferenceList, key, expected, value)
+	if err != nil {
+		return nil, fmt.Errorf("lwt cas failed: %w", err)
+	}
+
+	log.Printf("[LWT] CAS key='%s' applied=%v", key, result.Applied)
+	if result.Applied && s.changes != nil {
+		if _, err := s.changes.Append(changelog.Change{Op: changelog.OpCAS, Key: key, Value: value}); err != nil {
+			log.Printf("[CHANGES] Failed to record cas of key='%s': %v", key, err)
+			return nil, fmt.Errorf("%w: %v", ErrChangeNotRecorded, err)
+		}
+	}
+	return result, nil
+}
+
+func (s *MainService) LinearizableGet(key string) (*paxos.Result, error) {
+	if key == "" {
+		return nil, fmt.Errorf("key is required")
+	}
+	if !isLWTKey(key) {
+		return nil, ErrNotLWTKey
+	}
+
+	preferenceList := s.ring.GetPreferenceList(key)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := s.proposer.Read(ctx, preferenceList, key)
+	if err != nil {
+		return nil, fmt.Errorf("lwt read failed: %w", err)
+	}
+	return result, nil
+}
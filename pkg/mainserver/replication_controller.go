@@ -0,0 +1,40 @@
+package mainserver
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rupeshx80/consistent-hashing/pkg/replication"
+)
+
+//putReplicated serves /set carrying the replication header, the body's vectorClock is stored unchanged
+func (mc *MainController) putReplicated(c *gin.Context, source string, body map[string]string) {
+	causalContext, err := mc.service.Replicate(source, c.GetHeader(replication.SecretHeader), body)
+	if err != nil {
+		if errors.Is(err, ErrReplicationForbidden) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, ErrInvalidReplicatedWrite) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("[CONTROLLER] Replicated write from '%s' failed, err=%v", source, err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header(CausalContextHeader, causalContext)
+	c.JSON(http.StatusOK, gin.H{"message": "replicated version stored successfully", "context": causalContext})
+}
+
+func (mc *MainController) ReplicationStatus(c *gin.Context) {
+	status, err := mc.service.ReplicationStatus()
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
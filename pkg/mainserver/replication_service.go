@@ -0,0 +1,92 @@
+package mainserver
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/rupeshx80/consistent-hashing/pkg/model"
+	"github.com/rupeshx80/consistent-hashing/pkg/replication"
+)
+
+var (
+	ErrInvalidReplicatedWrite = errors.New("invalid replicated write")
+	ErrReplicationDisabled    = errors.New("replication is not enabled")
+	ErrReplicationForbidden   = errors.New("replicated write without a valid replication secret")
+)
+
+// StartReplication ships local changes to cfg.Peer, it needs the change log.
+// The config is kept either way, it authenticates writes the peer sends here
+func StartReplication(service *MainService, cfg replication.Config) {
+	service.replicationCfg = cfg
+	if cfg.Secret == "" {
+		log.Printf("[REPLICATION] REPLICATION_SECRET is not set, replicated writes are refused")
+	}
+	if !cfg.Enabled() {
+		return
+	}
+	if service.changes == nil {
+		log.Printf("[REPLICATION] REPLICATION_PEER is set but the change log is disabled, not replicating")
+		return
+	}
+
+	r, err := replication.NewReplicator(cfg, service.changes)
+	if err != nil {
+		log.Printf("[REPLICATION] Failed to start: %v", err)
+		return
+	}
+	service.replicator = r
+	r.Start()
+}
+
+// Replicate stores a version another site accepted with its vector clock as
+// it is, a concurrent local version stays next to it as a sibling
+func (s *MainService) Replicate(source, secret string, body map[string]string) (string, error) {
+	if !s.replicationCfg.Authenticate(secret) {
+		log.Printf("[REPLICATION] Refused write claiming to come from '%s', bad secret", source)
+		return "", ErrReplicationForbidden
+	}
+
+	item := IngestItem{
+		Key:         body["key"],
+		Value:       body["value"],
+		VectorClock: body["vectorClock"],
+		Tombstone:   body["tombstone"] == "true",
+		Origin:      source,
+	}
+	if item.Key == "" || item.VectorClock == "" {
+		return "", fmt.Errorf("%w: key and vectorClock are required", ErrInvalidReplicatedWrite)
+	}
+
+	if v := body["createdAt"]; v != "" {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return "", fmt.Errorf("%w: createdAt: %v", ErrInvalidReplicatedWrite, err)
+		}
+		item.CreatedAt = t
+	}
+	expiresAt, err := model.ParseExpiry(body["expiresAt"])
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidReplicatedWrite, err)
+	}
+	item.ExpiresAt = expiresAt
+
+	results, err := s.Ingest([]IngestItem{item})
+	if err != nil {
+		return "", err
+	}
+	if results[0].Error != "" {
+		return "", fmt.Errorf("replicated write failed: %s", results[0].Error)
+	}
+
+	log.Printf("[REPLICATION] Stored key='%s' from site '%s' VC=%s", item.Key, source, item.VectorClock)
+	return results[0].Context, nil
+}
+
+func (s *MainService) ReplicationStatus() (replication.Status, error) {
+	if s.replicator == nil {
+		return replication.Status{}, ErrReplicationDisabled
+	}
+	return s.replicator.Status(), nil
+}
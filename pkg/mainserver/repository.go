@@ -2,54 +2,171 @@ package mainserver
 
 import (
 	"errors"
+	"fmt"
+	"time"
 
-	"github.com/rupeshx80/consistent-hashing/pkg/db"
 	"github.com/rupeshx80/consistent-hashing/pkg/model"
+	"github.com/rupeshx80/consistent-hashing/pkg/storage"
 )
 
-type KeyValueRepository struct{}
+// ErrSystemKey is a client key in the range the coordinator keeps its own state
+// in, like the commit records of transactions
+var ErrSystemKey = errors.New("keys starting with the system prefix are reserved")
 
-func NewKeyValueRepository() *KeyValueRepository {
-	return &KeyValueRepository{}
+type KeyValueRepository struct {
+	store storage.Storage
+}
+
+func NewKeyValueRepository(store storage.Storage) *KeyValueRepository {
+	return &KeyValueRepository{store: store}
 }
 
 
-func (r *KeyValueRepository) PutVersion(key, value, vectorClock string) error{
+func (r *KeyValueRepository) PutVersion(key, value, vectorClock string, expiresAt *time.Time) error{
+	if storage.IsSystemKey(key) {
+		return ErrSystemKey
+	}
 	kv := model.KeyValue {
 		Key: key,
 		Value: value,
 		VectorClock: vectorClock,
+		ExpiresAt: expiresAt,
 	}
 
-	return db.RJ.Create(&kv).Error
+	return r.store.PutVersion(kv)
 
 }
 
-func (r *KeyValueRepository) GetAllVersions(key string) ([]model.KeyValue, error) {
-	var versions []model.KeyValue
+// PutTombstone records a delete as a version with no value
+func (r *KeyValueRepository) PutTombstone(key, vectorClock string, expiresAt *time.Time) error {
+	if storage.IsSystemKey(key) {
+		return ErrSystemKey
+	}
+	return r.store.PutVersion(model.KeyValue{
+		Key:         key,
+		VectorClock: vectorClock,
+		ExpiresAt:   expiresAt,
+		Tombstone:   true,
+	})
+}
 
-	result := db.RJ.Where("key = ?", key).Order("created_at asc").Find(&versions)
+// ImportVersion stores kv with its clock and timestamps as given, false means the
+// version was already there
+func (r *KeyValueRepository) ImportVersion(kv model.KeyValue) (bool, error) {
+	if storage.IsSystemKey(kv.Key) {
+		return false, ErrSystemKey
+	}
+	existing, err := r.store.GetAllVersions(kv.Key)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return false, err
+	}
+	for _, v := range existing {
+		if v.VectorClock == kv.VectorClock && v.Value == kv.Value && v.Tombstone == kv.Tombstone {
+			return false, nil
+		}
+	}
 
-	if result.Error != nil {
-		return nil, result.Error
+	kv.ID = 0
+	return true, r.store.PutVersion(kv)
+}
+
+// RemoveVersion deletes the stored copies of kv, matched by clock, value and
+// tombstone. It undoes a write that failed after it was stored, so a retry of
+// the same version counts as new again
+func (r *KeyValueRepository) RemoveVersion(kv model.KeyValue) error {
+	existing, err := r.store.GetAllVersions(kv.Key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var ids []uint
+	for _, v := range existing {
+		if v.VectorClock == kv.VectorClock && v.Value == kv.Value && v.Tombstone == kv.Tombstone {
+			ids = append(ids, v.ID)
+		}
 	}
-	
-	if len(versions) == 0 {
-		return nil, errors.New("key not found in DB")
+	if len(ids) == 0 {
+		return nil
+	}
+	return r.store.DeleteVersions(kv.Key, ids)
+}
+
+func (r *KeyValueRepository) GetAllVersions(key string) ([]model.KeyValue, error) {
+	if storage.IsSystemKey(key) {
+		return nil, fmt.Errorf("key not found in DB: %w", storage.ErrNotFound)
+	}
+	versions, err := r.store.GetAllVersions(key)
+
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("key not found in DB: %w", err)
+	}
+	if err != nil {
+		return nil, err
 	}
 	return versions, nil
 }
 
 func (r *KeyValueRepository) GetAllKeys() ([]string, error) {
-    var keys []string
-    result := db.RJ.Model(&model.KeyValue{}).Distinct("key").Pluck("key", &keys)
-    if result.Error != nil {
-        return nil, result.Error
-    }
-    return keys, nil
+	keys, err := r.store.ScanKeys("", "", 0)
+	return withoutSystemKeys(keys), err
+}
+
+//the coordinator keeps its transaction decisions in the same store, they are not data
+func withoutSystemKeys(keys []string) []string {
+	out := keys[:0]
+	for _, key := range keys {
+		if !storage.IsSystemKey(key) {
+			out = append(out, key)
+		}
+	}
+	return out
+}
+
+
+// ScanKeys pages through the stored keys in byte order
+func (r *KeyValueRepository) ScanKeys(prefix, after string, limit int) ([]string, error) {
+	var out []string
+	for {
+		keys, err := r.store.ScanKeys(prefix, after, limit)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, withoutSystemKeys(keys)...)
+
+		//a full page that lost system keys is topped up, a short page means the end
+		if limit <= 0 || len(keys) < limit || len(out) >= limit {
+			if limit > 0 && len(out) > limit {
+				out = out[:limit]
+			}
+			return out, nil
+		}
+		after = keys[len(keys)-1]
+	}
 }
 
+func (r *KeyValueRepository) DeleteVersions(key string, ids []uint) error {
+	return r.store.DeleteVersions(key, ids)
+}
 
 func (r *KeyValueRepository) DeleteAllVersions(key string) error {
-	return db.RJ.Where("key = ?", key).Delete(&model.KeyValue{}).Error
-}
\ No newline at end of file
+	return r.store.Delete(key)
+}
+
+// PurgeExpired hard deletes expired versions, see storage.Storage
+func (r *KeyValueRepository) PurgeExpired(now time.Time) (int64, error) {
+	return r.store.PurgeExpired(now)
+}
+
+func (r *KeyValueRepository) GetVersionsSince(since time.Time) ([]model.KeyValue, error) {
+	versions, err := r.store.GetVersionsSince(since)
+	out := versions[:0]
+	for _, kv := range versions {
+		if !storage.IsSystemKey(kv.Key) {
+			out = append(out, kv)
+		}
+	}
+	return out, err
+}
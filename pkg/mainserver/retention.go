@@ -0,0 +1,142 @@
+package mainserver
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/rupeshx80/consistent-hashing/pkg/model"
+	"github.com/rupeshx80/consistent-hashing/pkg/vclock"
+)
+
+// RetentionPolicy caps how much superseded history is kept per key, zero means
+// no limit. Versions a read can still return are never removed
+type RetentionPolicy struct {
+	MaxVersions int
+	MaxAge      time.Duration
+}
+
+// RetentionPolicyFromEnv reads HISTORY_MAX_VERSIONS and HISTORY_MAX_AGE ("720h")
+func RetentionPolicyFromEnv() RetentionPolicy {
+	var p RetentionPolicy
+
+	if v := os.Getenv("HISTORY_MAX_VERSIONS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Printf("[RETENTION] Ignoring invalid HISTORY_MAX_VERSIONS=%s", v)
+		} else {
+			p.MaxVersions = n
+		}
+	}
+
+	if v := os.Getenv("HISTORY_MAX_AGE"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			log.Printf("[RETENTION] Ignoring invalid HISTORY_MAX_AGE=%s", v)
+		} else {
+			p.MaxAge = d
+		}
+	}
+	return p
+}
+
+func (p RetentionPolicy) Enabled() bool {
+	return p.MaxVersions > 0 || p.MaxAge > 0
+}
+
+// StartRetentionEnforcer trims history every interval, it does nothing without a policy
+func StartRetentionEnforcer(service *MainService, policy RetentionPolicy, interval time.Duration) {
+	if !policy.Enabled() {
+		return
+	}
+
+	log.Printf("[RETENTION] Keeping at most %d versions per key, max age %v (0 = unlimited)", policy.MaxVersions, policy.MaxAge)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			removed, err := service.EnforceRetention(policy, time.Now())
+			if err != nil {
+				log.Printf("[RETENTION] Failed to enforce retention: %v", err)
+				continue
+			}
+			if removed > 0 {
+				log.Printf("[RETENTION] Removed %d old versions", removed)
+			}
+		}
+	}()
+}
+
+// EnforceRetention walks every key and removes the superseded versions the policy doesn't keep
+func (s *MainService) EnforceRetention(policy RetentionPolicy, now time.Time) (int, error) {
+	removed := 0
+	after := ""
+	for {
+		keys, err := s.repository.ScanKeys("", after, 500)
+		if err != nil {
+			return removed, err
+		}
+
+		for _, key := range keys {
+			versions, err := s.repository.GetAllVersions(key)
+			if err != nil {
+				continue
+			}
+
+			ids := historyToDrop(versions, policy, now)
+			if len(ids) == 0 {
+				continue
+			}
+			if err := s.repository.DeleteVersions(key, ids); err != nil {
+				return removed, err
+			}
+			removed += len(ids)
+		}
+
+		if len(keys) < 500 {
+			return removed, nil
+		}
+		after = keys[len(keys)-1]
+	}
+}
+
+//current versions always count toward MaxVersions and are always kept, the
+//superseded ones fill what is left newest first
+func historyToDrop(versions []model.KeyValue, policy RetentionPolicy, now time.Time) []uint {
+	sortVersions(versions)
+
+	clocks := make([]string, len(versions))
+	for i, kv := range versions {
+		clocks[i] = kv.VectorClock
+	}
+
+	same := func(a, b int) bool {
+		return versions[a].Value == versions[b].Value && versions[a].Tombstone == versions[b].Tombstone
+	}
+
+	kept := 0
+	superseded := make([]bool, len(versions))
+	for i := range versions {
+		if superseded[i] = vclock.Dominated(clocks, i, same); !superseded[i] {
+			kept++
+		}
+	}
+
+	var ids []uint
+	for i := len(versions) - 1; i >= 0; i-- {
+		if !superseded[i] {
+			continue
+		}
+
+		tooMany := policy.MaxVersions > 0 && kept >= policy.MaxVersions
+		tooOld := policy.MaxAge > 0 && now.Sub(versions[i].CreatedAt) > policy.MaxAge
+		if tooMany || tooOld {
+			ids = append(ids, versions[i].ID)
+			continue
+		}
+		kept++
+	}
+	return ids
+}
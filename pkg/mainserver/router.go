@@ -1,23 +1,94 @@
 package mainserver
 
 import (
+	"time"
+
 	"github.com/gin-gonic/gin"
 	"github.com/rupeshx80/consistent-hashing/pkg/cache"
+	"github.com/rupeshx80/consistent-hashing/pkg/changelog"
 	"github.com/rupeshx80/consistent-hashing/pkg/hash-ring"
 	"github.com/rupeshx80/consistent-hashing/pkg/quorum"
+	"github.com/rupeshx80/consistent-hashing/pkg/replication"
+	"github.com/rupeshx80/consistent-hashing/pkg/webhook"
 )
 
-func SetupRouter(ring *hashring.HashRing, repo *KeyValueRepository, qManager *quorum.QuorumManager, cacheClient *cache.CacheClient) *gin.Engine {
+// ListenAddr is where the main server serves clients, ring nodes reach it here
+// to ask for the outcome of a transaction
+const ListenAddr = ":5000"
+
+func SetupRouter(nodeID string, ring *hashring.HashRing, repo *KeyValueRepository, qManager *quorum.QuorumManager, cacheClient *cache.CacheClient, changes *changelog.Log, hooks *webhook.Store) (*gin.Engine, error) {
 	r := gin.Default()
-	service := NewMainService(ring, repo, qManager, cacheClient)
-	
+	service := NewMainService(nodeID, ring, repo, qManager, cacheClient, changes)
+
+	//commit decisions that didn't reach every replica before a restart
+	if err := service.txns.Recover(); err != nil {
+		return nil, err
+	}
+
 	//rehydrate cache from DB
 	InitializeCache(service)
 
+	//expired versions are hidden on read right away, this reclaims their rows
+	StartExpiryPurger(service, time.Minute)
+
+	//superseded versions are kept for /history until the retention policy drops them
+	StartRetentionEnforcer(service, RetentionPolicyFromEnv(), time.Hour)
+
+	//webhooks tail the change log, each from its own saved offset
+	StartWebhooks(service, hooks, webhook.ConfigFromEnv())
+
+	//REPLICATION_PEER turns on shipping local writes to another cluster
+	StartReplication(service, replication.ConfigFromEnv())
+
 	ctrl := NewMainController(service)
+	requireAdmin := RequireAdmin(AdminSecretFromEnv())
 
 	r.PUT("/set", ctrl.Put)
 	r.GET("/get/:key", ctrl.Get)
+	r.GET("/history/:key", ctrl.History)
+	r.DELETE("/delete/:key", ctrl.Delete)
 	r.GET("/preference-list", ctrl.GetPreferenceList)
-	return r
+	r.GET("/keys", ctrl.ListKeys)
+	r.POST("/mget", ctrl.MGet)
+	r.POST("/mset", ctrl.MSet)
+	r.POST("/txn", ctrl.Transact)
+	r.GET("/txn/status/:id", ctrl.TxnStatus)
+
+	//raw versions for restore, clocks are stored as given so only an admin may send them
+	r.POST("/ingest", requireAdmin, ctrl.Ingest)
+	r.GET("/ring/ranges", ctrl.TokenRanges)
+
+	//bulk load and dump in JSON Lines or CSV
+	r.POST("/import", ctrl.Import)
+	r.GET("/export", ctrl.Export)
+
+	//change data capture, long-poll or server-sent events
+	r.GET("/changes", ctrl.Changes)
+	r.GET("/watch", ctrl.WatchPrefix)
+	r.GET("/watch/:key", ctrl.WatchKey)
+
+	r.GET("/replication/status", ctrl.ReplicationStatus)
+
+	//webhooks make the server post to any url, only an admin may register or replay them
+	admin := r.Group("/admin", requireAdmin)
+	admin.POST("/webhooks", ctrl.CreateWebhook)
+	admin.GET("/webhooks", ctrl.ListWebhooks)
+	admin.DELETE("/webhooks/:id", ctrl.DeleteWebhook)
+	admin.GET("/dead-letters", ctrl.ListDeadLetters)
+	admin.POST("/dead-letters/replay", ctrl.ReplayDeadLetters)
+	admin.POST("/dead-letters/:id/replay", ctrl.ReplayDeadLetter)
+
+	r.GET("/crdt/:key", ctrl.GetCRDT)
+	r.POST("/crdt/counter/:key/increment", ctrl.IncrementCounter)
+	r.POST("/crdt/set/:key/add", ctrl.AddToSet)
+	r.POST("/crdt/set/:key/remove", ctrl.RemoveFromSet)
+	r.PUT("/crdt/register/:key", ctrl.SetRegister)
+	r.POST("/crdt/map/:key/set", ctrl.SetMapField)
+	r.POST("/crdt/map/:key/increment", ctrl.IncrementMapField)
+	r.POST("/crdt/map/:key/remove", ctrl.RemoveMapField)
+
+	//linearizable single key operations, separate from the eventual path
+	r.POST("/lwt/cas", ctrl.CompareAndSet)
+	r.GET("/lwt/get/:key", ctrl.LinearizableGet)
+	return r, nil
 }
@@ -2,175 +2,350 @@ package mainserver
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"log"
-	"strings"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/rupeshx80/consistent-hashing/pkg/cache"
+	"github.com/rupeshx80/consistent-hashing/pkg/changelog"
 	"github.com/rupeshx80/consistent-hashing/pkg/hash-ring"
+	"github.com/rupeshx80/consistent-hashing/pkg/model"
+	"github.com/rupeshx80/consistent-hashing/pkg/paxos"
 	"github.com/rupeshx80/consistent-hashing/pkg/quorum"
+	"github.com/rupeshx80/consistent-hashing/pkg/replication"
+	"github.com/rupeshx80/consistent-hashing/pkg/storage"
+	"github.com/rupeshx80/consistent-hashing/pkg/txn"
+	"github.com/rupeshx80/consistent-hashing/pkg/vclock"
+	"github.com/rupeshx80/consistent-hashing/pkg/webhook"
 )
 
 type VersionedValue struct {
 	Value       string `json:"value"`
 	VectorClock string `json:"vectorClock"`
 	CreatedAt   string `json:"createdAt"`
+	ExpiresAt   string `json:"expiresAt,omitempty"`
+	Tombstone   bool   `json:"tombstone,omitempty"`
 }
 
 type MainService struct {
-	ring        *hashring.HashRing
-	repository  *KeyValueRepository
-	qManager    *quorum.QuorumManager
-	cacheClient *cache.CacheClient
+	ring           *hashring.HashRing
+	repository     *KeyValueRepository
+	qManager       *quorum.QuorumManager
+	cacheClient    *cache.CacheClient
+	proposer       *paxos.Proposer
+	txns           *txn.Coordinator
+	keyLocks       [keyLockStripes]sync.Mutex
+	tombstoneGrace time.Duration
+	changes        *changelog.Log //nil disables the change feed
+	webhooks       *webhook.Dispatcher
+	replicator     *replication.Replicator //nil unless REPLICATION_PEER is set
+	replicationCfg replication.Config      //its secret authenticates writes from the peer site
 }
 
-func NewMainService(ring *hashring.HashRing, repo *KeyValueRepository, qManager *quorum.QuorumManager, cacheClient *cache.CacheClient) *MainService {
-	return &MainService{
+// NewMainService builds the coordinator, nodeID is its persisted id and names it
+// in paxos ballots
+func NewMainService(nodeID string, ring *hashring.HashRing, repo *KeyValueRepository, qManager *quorum.QuorumManager, cacheClient *cache.CacheClient, changes *changelog.Log) *MainService {
+	s := &MainService{
 		ring:        ring,
 		repository:  repo,
 		qManager:    qManager,
 		cacheClient: cacheClient,
+		proposer:    paxos.NewProposer(nodeID),
+		txns:        txn.NewCoordinator(repo.store, ListenAddr),
+		changes:     changes,
+
+		tombstoneGrace: TombstoneGraceFromEnv(),
 	}
+	qManager.SetRepairHook(s.recordRepair)
+	return s
 }
 
 // mergeMapMax merges integer counters, taking max per node
 func mergeMapMax(dst, src map[string]int) {
-	for k, v := range src {
-		if cur, ok := dst[k]; !ok || v > cur {
-			dst[k] = v
-		}
-	}
+	vclock.Merge(dst, src)
 }
 
+// parseVC returns the node counters of a clock, see pkg/vclock for the dot it may carry
 func parseVC(vc string) map[string]int {
-	out := map[string]int{}
+	return vclock.Parse(vc)
+}
+
+func serializeVC(vc map[string]int) string {
+	return vclock.Serialize(vc)
+}
+
+// buildNewVectorClock derives the clock of a new write from what the client has
+// seen, so a write made without reading the latest versions becomes their sibling.
+// A tombstone also covers every version the coordinator stored, a delete removes
+// the key and not only what the client read
+func (s *MainService) buildNewVectorClock(key string, nodeID string, clientVC string, supersedeStored bool) (string, error) {
+	context := parseVC(clientVC)
 
-	if vc == "" {
-		return out
+	dbVersions, err := s.repository.GetAllVersions(key)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return "", err
 	}
 
-	_ = json.Unmarshal([]byte(vc), &out)
+	//the coordinator's counter goes past every one it stored, so each write has its own dot
+	counter := context[nodeID]
+	for _, kv := range dbVersions {
+		stored := parseVC(kv.VectorClock)
+		if stored[nodeID] > counter {
+			counter = stored[nodeID]
+		}
+		if supersedeStored {
+			mergeMapMax(context, stored)
+		}
+	}
 
-	return out
+	return vclock.New(context, nodeID, counter+1), nil
 }
 
-func serializeVC(vc map[string]int) string {
-	b, _ := json.Marshal(vc)
-	return string(b)
+// ErrPreconditionFailed means the versions a write quorum holds are not the ones the client read
+var ErrPreconditionFailed = errors.New("precondition failed: key was modified since it was read")
+
+//keys share a fixed set of stripes so the locks don't grow with the keyspace
+const keyLockStripes = 1024
+
+//writes for one key go through the coordinator one at a time, so a precondition
+//check or a crdt read-modify-write can't interleave with another write. Stripes are
+//taken once each and in index order, so callers locking several keys can't deadlock
+func (s *MainService) lockKeys(keys ...string) (unlock func()) {
+	stripes := make([]int, 0, len(keys))
+	seen := make(map[int]bool, len(keys))
+	for _, key := range keys {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		i := int(h.Sum32() % keyLockStripes)
+		if !seen[i] {
+			seen[i] = true
+			stripes = append(stripes, i)
+		}
+	}
+	sort.Ints(stripes)
+
+	for _, i := range stripes {
+		s.keyLocks[i].Lock()
+	}
+	return func() {
+		for j := len(stripes) - 1; j >= 0; j-- {
+			s.keyLocks[stripes[j]].Unlock()
+		}
+	}
 }
 
-func (s *MainService) buildNewVectorClock(key string, nodeID string, clientVC string) (string, error) {
-	merged := map[string]int{}
+// Put stores a new version, when body carries "ifMatch" the write only happens if
+// the causal context of the current versions is exactly that token
+func (s *MainService) Put(body map[string]string) (string, error) {
+	key := body["key"]
+	if key == "" {
+		return "", fmt.Errorf("key is required")
+	}
 
-	nodeID = strings.TrimSpace(nodeID)
-	nodeID = strings.TrimPrefix(nodeID, ":")
+	defer s.lockKeys(key)()
 
-	dbVersions, err := s.repository.GetAllVersions(key)
+	if token, ok := body["ifMatch"]; ok {
+		if err := s.checkPrecondition(key, token); err != nil {
+			return "", err
+		}
+	}
+
+	return s.put(body)
+}
+
+// checkPrecondition compares the merged vector clock across W replicas and the
+// coordinator's DB with the clock the client observed
+func (s *MainService) checkPrecondition(key, token string) error {
+	expectedVC, err := decodeCausalContext(token)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	current := map[string]int{}
+
+	preferenceList := s.ring.GetPreferenceList(key)
+	qres, err := s.qManager.ReadQuorumN(ctx, preferenceList, key, s.qManager.WriteQuorumSize())
+	if err != nil {
+		return fmt.Errorf("could not evaluate precondition: %w", err)
+	}
+	for _, v := range qres {
+		mergeMapMax(current, parseVC(v.VectorClock))
+	}
 
-	if err == nil {
+	if dbVersions, err := s.repository.GetAllVersions(key); err == nil {
 		for _, kv := range dbVersions {
-			vcMap := parseVC(kv.VectorClock)
-			mergeMapMax(merged, vcMap)
+			mergeMapMax(current, parseVC(kv.VectorClock))
 		}
 	}
 
-	if clientVC != "" {
-		//here all dbs vector clock merges with client vc
-		mergeMapMax(merged, parseVC(clientVC))
+	expected := parseVC(expectedVC)
+	if !descends(current, expected) || !descends(expected, current) {
+		log.Printf("[PUT] Precondition failed for key='%s' expected=%s current=%s", key, expectedVC, serializeVC(current))
+		return ErrPreconditionFailed
 	}
 
-	//nodes counter increment
-	if _, ok := merged[nodeID]; !ok {
-		merged[nodeID] = 1
-	} else {
-		merged[nodeID] = merged[nodeID] + 1
+	log.Printf("[PUT] Precondition matched for key='%s' vc=%s", key, expectedVC)
+	return nil
+}
+
+// pendingWrite is a version with its vector clock assigned, ready to be stored
+// on the coordinator and replicated
+type pendingWrite struct {
+	key         string
+	value       string
+	vectorClock string
+	expiresAt   *time.Time
+	tombstone   bool
+	coordinator string
+}
+
+func (w *pendingWrite) replicated() quorum.VersionedValue {
+	return quorum.VersionedValue{
+		Value:       w.value,
+		VectorClock: w.vectorClock,
+		ExpiresAt:   model.FormatExpiry(w.expiresAt),
+		Tombstone:   w.tombstone,
+	}
+}
+
+// this persists locally (coordinator) and writes to the key's preference list using
+// the quorum manager, the coordinator node counts toward W like any other owner.
+// it returns the causal context of the stored version so clients can chain writes
+func (s *MainService) put(body map[string]string) (string, error) {
+	w, err := s.prepareWrite(body)
+	if err != nil {
+		return "", err
 	}
 
-	return serializeVC(merged), nil
+	//the DB goes first, the next write takes its dot counter from the stored
+	//versions and must not reuse one a node may already hold
+	if err := s.storeLocally(w); err != nil {
+		return "", err
+	}
+
+	//Issue write quorum request to the preference list and wait for W acks
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := s.qManager.WriteQuorum(ctx, s.ring.GetPreferenceList(w.key), w.key, w.replicated()); err != nil {
+		return "", fmt.Errorf("write quorum failed: %w", err)
+	}
+
+	log.Printf("[PUT] SUCCESS - Key='%s' Coordinator=%s VC=%s", w.key, w.coordinator, w.vectorClock)
+	if err := s.recordChange(w.key, w.replicated()); err != nil {
+		return "", err
+	}
+	return encodeCausalContext(w.vectorClock), nil
 }
 
-// this persists locally (coordinator) and writes to replicas using the quorum manager.
-func (s *MainService) Put(body map[string]string) error {
+// prepareWrite validates body and assigns the new version's vector clock
+func (s *MainService) prepareWrite(body map[string]string) (*pendingWrite, error) {
 
 	key := body["key"]
 	value := body["value"]
 	clientVC := body["vectorClock"]
 
 	if key == "" {
-		return fmt.Errorf("key is required")
+		return nil, fmt.Errorf("key is required")
+	}
+	if isLWTKey(key) {
+		return nil, ErrLWTKey
+	}
+	if storage.IsSystemKey(key) {
+		return nil, ErrSystemKey
+	}
+
+	//ttl is relative to now, the absolute expiry is what gets replicated.
+	//a tombstone expires after the grace period, the expiry purge then removes
+	//it together with every version it deleted
+	tombstone := body["tombstone"] == "true"
+	var expiresAt *time.Time
+	if tombstone {
+		value = ""
+		t := time.Now().Add(s.tombstoneGrace)
+		expiresAt = &t
+	} else if ttl := body["ttl"]; ttl != "" {
+		d, err := model.ParseTTL(ttl)
+		if err != nil {
+			return nil, err
+		}
+		t := time.Now().Add(d)
+		expiresAt = &t
+	}
+
+	//causal context from a previous read supersedes every version that read returned
+	if token := body["context"]; token != "" {
+		contextVC, err := decodeCausalContext(token)
+		if err != nil {
+			return nil, err
+		}
+		merged := parseVC(clientVC)
+		mergeMapMax(merged, parseVC(contextVC))
+		clientVC = serializeVC(merged)
 	}
 
 	_, node := s.ring.GetNode(key)
-	nodeID := node //use node string as node identifier for VC counters
+	nodeID := s.ring.NodeID(node) //stable id, not the address, so restarts don't fork history
 
-	newVC, err := s.buildNewVectorClock(key, nodeID, clientVC)
+	newVC, err := s.buildNewVectorClock(key, nodeID, clientVC, tombstone)
 	log.Printf("[DB] Key='%s' clientVC='%s'", key, clientVC)
 
 	if err != nil {
-		return fmt.Errorf("failed to build vector clock: %w", err)
+		return nil, fmt.Errorf("failed to build vector clock: %w", err)
 	}
 
 	log.Printf("[PUT] Key='%s' clientVC='%s' newVC='%s'", key, clientVC, newVC)
+	return &pendingWrite{
+		key:         key,
+		value:       value,
+		vectorClock: newVC,
+		expiresAt:   expiresAt,
+		tombstone:   tombstone,
+		coordinator: node,
+	}, nil
+}
 
-	//writes to cache first (fast path) -non-fatal if fails
-	if s.cacheClient != nil {
-		_ = s.cacheClient.WriteToCache(key, value, newVC)
+func (s *MainService) storeLocally(w *pendingWrite) error {
+	var err error
+	if w.tombstone {
+		err = s.repository.PutTombstone(w.key, w.vectorClock, w.expiresAt)
+	} else {
+		err = s.repository.PutVersion(w.key, w.value, w.vectorClock, w.expiresAt)
 	}
-
-	//then we persist locally to DB (coordinator write)
-	if err := s.repository.PutVersion(key, value, newVC); err != nil {
+	if err != nil {
 		return fmt.Errorf("failed to save new version: %w", err)
 	}
+	return nil
+}
 
-	//Build replica list (exclude coordinator)
-	preferenceList := s.ring.GetPreferenceList(key)
-	replicas := make([]string, 0, len(preferenceList))
-	for _, n := range preferenceList {
-		if n == node {
-			continue
-		}
-		replicas = append(replicas, n)
-	}
-
-	//Issue write quorum request to replicas and wait for quorum
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	if len(replicas) > 0 {
-		if err := s.qManager.WriteQuorum(ctx, replicas, key, value, newVC); err != nil {
-			return fmt.Errorf("write quorum failed: %w", err)
+//unstoreLocally takes back the DB copies of writes that never reached the replicas
+func (s *MainService) unstoreLocally(writes []*pendingWrite) {
+	for _, w := range writes {
+		err := s.repository.RemoveVersion(model.KeyValue{
+			Key:         w.key,
+			Value:       w.value,
+			VectorClock: w.vectorClock,
+			Tombstone:   w.tombstone,
+		})
+		if err != nil {
+			log.Printf("[DB] Failed to undo the DB copy of key='%s' vc='%s': %v", w.key, w.vectorClock, err)
 		}
 	}
-
-	log.Printf("[PUT] SUCCESS - Key='%s' Coordinator=%s VC=%s", key, node, newVC)
-	return nil
 }
 
-
 func (s *MainService) Get(key string) ([]VersionedValue, error) {
 	if key == "" {
 		return nil, fmt.Errorf("key is required")
 	}
-
-	if s.cacheClient != nil {
-		cacheVersions, err := s.cacheClient.ReadFromCache(key)
-		if err == nil && len(cacheVersions) > 0 {
-			log.Printf("[GET] Cache HIT for key='%s'", key)
-
-			// Convert cache response to VersionedValue
-			result := make([]VersionedValue, len(cacheVersions))
-			for i, cv := range cacheVersions {
-				result[i] = VersionedValue{
-					Value:       cv.Value,
-					VectorClock: cv.VectorClock,
-					CreatedAt:   cv.CreatedAt,
-				}
-			}
-			return result, nil
-		}
-		log.Printf("[GET] Cache MISS for key='%s', trying quorum/DB", key)
+	if isLWTKey(key) {
+		return nil, ErrLWTKey
 	}
 
 	preferenceList := s.ring.GetPreferenceList(key)
@@ -191,28 +366,113 @@ func (s *MainService) Get(key string) ([]VersionedValue, error) {
 				Value:       v.Value,
 				VectorClock: v.VectorClock,
 				CreatedAt:   v.CreatedAt,
+				ExpiresAt:   v.ExpiresAt,
+				Tombstone:   v.Tombstone,
 			})
 		}
 		log.Printf("[GET] Quorum READ success for key='%s'", key)
-		return out, nil
+		return s.resolveSiblings(key, out), nil
+	}
+
+	//without a quorum one replica is asked too, it may have missed a delete so
+	//its answer is only merged with the DB copy, which saw every write taken here
+	var out []VersionedValue
+	if err != nil && s.cacheClient != nil {
+		log.Printf("[GET] Quorum read failed for key='%s', merging one replica with the DB: %v", key, err)
+		cacheVersions, cerr := s.cacheClient.ReadFromCache(key)
+		if cerr == nil {
+			for _, cv := range cacheVersions {
+				out = append(out, VersionedValue{
+					Value:       cv.Value,
+					VectorClock: cv.VectorClock,
+					CreatedAt:   cv.CreatedAt,
+					ExpiresAt:   cv.ExpiresAt,
+					Tombstone:   cv.Tombstone,
+				})
+			}
+		}
 	}
 
 	dbVersions, dbErr := s.repository.GetAllVersions(key)
-	if dbErr != nil {
+	if dbErr != nil && (len(out) == 0 || !errors.Is(dbErr, storage.ErrNotFound)) {
 		return nil, fmt.Errorf("not found in cache, quorum, or DB: %w", dbErr)
 	}
 
-	var out []VersionedValue
 	for _, kv := range dbVersions {
 		out = append(out, VersionedValue{
 			Value:       kv.Value,
 			VectorClock: kv.VectorClock,
 			CreatedAt:   kv.CreatedAt.String(),
+			ExpiresAt:   model.FormatExpiry(kv.ExpiresAt),
+			Tombstone:   kv.Tombstone,
 		})
 	}
 
 	log.Printf("[GET] DB fallback for key='%s', found %d versions", key, len(dbVersions))
-	return out, nil
+	return s.resolveSiblings(key, out), nil
+}
+
+// resolveSiblings hides versions superseded by a later write, then expired ones and
+// tombstones, and merges CRDT siblings. Pruning comes first so an expired write or a
+// delete still hides what it replaced, an empty result means the key is deleted
+func (s *MainService) resolveSiblings(key string, versions []VersionedValue) []VersionedValue {
+	return s.resolveSiblingsAt(key, versions, time.Now())
+}
+
+// resolveSiblingsAt is resolveSiblings with expiry evaluated at now, point in time reads pass the past
+func (s *MainService) resolveSiblingsAt(key string, versions []VersionedValue, now time.Time) []VersionedValue {
+	current := pruneDominated(versions)
+	if len(current) != len(versions) {
+		log.Printf("[GET] Pruned %d superseded versions for key='%s'", len(versions)-len(current), key)
+	}
+	return s.mergeCRDTSiblings(key, dropTombstones(dropExpired(key, current, now)))
+}
+
+func dropExpired(key string, versions []VersionedValue, now time.Time) []VersionedValue {
+	out := make([]VersionedValue, 0, len(versions))
+	for _, v := range versions {
+		expiresAt, err := model.ParseExpiry(v.ExpiresAt)
+		if err != nil {
+			log.Printf("[GET] Ignoring bad expiry on key='%s': %v", key, err)
+		}
+		if model.IsExpired(expiresAt, now) {
+			continue
+		}
+		out = append(out, v)
+	}
+	return out
+}
+
+// CausalContext is the opaque token for a set of versions returned by Get
+func (s *MainService) CausalContext(versions []VersionedValue) string {
+	return encodeCausalContext(mergedVectorClock(versions))
+}
+
+// Placement is where a key lives on the ring, keys with the same Position have
+// the same preference list. HashTag is only set when the ring places keys by it
+type Placement struct {
+	Key            string   `json:"key"`
+	HashTag        string   `json:"hashTag,omitempty"`
+	Position       int      `json:"position"`
+	PreferenceList []string `json:"preferenceList"`
+}
+
+func (s *MainService) Placement(key string) (*Placement, error) {
+	preferenceList, err := s.GetPreferenceList(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var tag string
+	if s.ring.HashTags() {
+		tag, _ = hashring.HashTag(key)
+	}
+	return &Placement{
+		Key:            key,
+		HashTag:        tag,
+		Position:       s.ring.Position(key),
+		PreferenceList: preferenceList,
+	}, nil
 }
 
 func (s *MainService) GetPreferenceList(key string) ([]string, error) {
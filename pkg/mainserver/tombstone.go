@@ -0,0 +1,57 @@
+package mainserver
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// DefaultTombstoneGrace is how long a delete is kept around, a replica that was
+// down longer than this can bring the deleted versions back
+const DefaultTombstoneGrace = 24 * time.Hour
+
+// TombstoneGraceFromEnv reads TOMBSTONE_GRACE as a go duration ("72h")
+func TombstoneGraceFromEnv() time.Duration {
+	v := os.Getenv("TOMBSTONE_GRACE")
+	if v == "" {
+		return DefaultTombstoneGrace
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Printf("[DELETE] Ignoring invalid TOMBSTONE_GRACE=%s", v)
+		return DefaultTombstoneGrace
+	}
+	return d
+}
+
+// Delete replicates a tombstone through the same quorum path as a write, its vector
+// clock supersedes every version the coordinator knows about and the client's context.
+// Once the grace period is over the expiry purge removes the tombstone with the
+// versions it superseded, a concurrent write it never saw is kept
+func (s *MainService) Delete(body map[string]string) (string, error) {
+	tombstone := make(map[string]string, len(body)+1)
+	for k, v := range body {
+		tombstone[k] = v
+	}
+	tombstone["tombstone"] = "true"
+	delete(tombstone, "ttl")
+
+	causalContext, err := s.Put(tombstone)
+	if err != nil {
+		return "", err
+	}
+
+	log.Printf("[DELETE] Tombstone written for key='%s', collected after %v", tombstone["key"], s.tombstoneGrace)
+	return causalContext, nil
+}
+
+func dropTombstones(versions []VersionedValue) []VersionedValue {
+	out := make([]VersionedValue, 0, len(versions))
+	for _, v := range versions {
+		if !v.Tombstone {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,44 @@
+package mainserver
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rupeshx80/consistent-hashing/pkg/txn"
+)
+
+type txnRequest struct {
+	Ops []map[string]string `json:"ops" binding:"required"`
+}
+
+// Transact answers 200 with a context per key once all of them are committed,
+// 409 when a precondition failed or a replica voted against the transaction
+func (mc *MainController) Transact(c *gin.Context) {
+	var req txnRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ops are required"})
+		return
+	}
+
+	result, err := mc.service.Transact(req.Ops)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidTransaction), errors.Is(err, ErrCrossPartition), errors.Is(err, ErrInvalidCausalContext):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, ErrPreconditionFailed), errors.Is(err, txn.ErrAborted):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			log.Printf("[CONTROLLER] Transaction failed, err=%v", err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// TxnStatus tells a replica stuck on a prepared transaction how it ended
+func (mc *MainController) TxnStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"id": c.Param("id"), "state": mc.service.TxnStatus(c.Param("id"))})
+}
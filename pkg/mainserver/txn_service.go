@@ -0,0 +1,138 @@
+package mainserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/rupeshx80/consistent-hashing/pkg/model"
+	"github.com/rupeshx80/consistent-hashing/pkg/txn"
+)
+
+const MaxTxnSize = 100
+
+var (
+	ErrInvalidTransaction = errors.New("invalid transaction")
+	ErrCrossPartition     = errors.New("keys of a transaction must share a preference list")
+)
+
+// TxnResult carries the causal context of every key the transaction wrote, in request order
+type TxnResult struct {
+	ID      string        `json:"id"`
+	Results []BatchResult `json:"results"`
+}
+
+// Transact writes every op or none of them. Ops take the /set fields plus
+// "op":"delete" for a tombstone and ifMatch, which is checked for all keys
+// before anything is written. All keys must live on one replica set, the
+// write then goes through two-phase commit on every replica of it
+func (s *MainService) Transact(ops []map[string]string) (*TxnResult, error) {
+	if len(ops) == 0 {
+		return nil, fmt.Errorf("%w: no ops", ErrInvalidTransaction)
+	}
+	if len(ops) > MaxTxnSize {
+		return nil, fmt.Errorf("%w: %d ops, max %d", ErrInvalidTransaction, len(ops), MaxTxnSize)
+	}
+
+	keys := make([]string, len(ops))
+	seen := make(map[string]bool, len(ops))
+	for i, op := range ops {
+		key := op["key"]
+		switch {
+		case key == "":
+			return nil, fmt.Errorf("%w: op %d has no key", ErrInvalidTransaction, i)
+		case seen[key]:
+			return nil, fmt.Errorf("%w: key '%s' appears twice", ErrInvalidTransaction, key)
+		case op["op"] != "" && op["op"] != "set" && op["op"] != "delete":
+			return nil, fmt.Errorf("%w: unknown op '%s' for key '%s'", ErrInvalidTransaction, op["op"], key)
+		}
+		seen[key] = true
+		keys[i] = key
+	}
+
+	nodes := s.ring.GetPreferenceList(keys[0])
+	for _, key := range keys[1:] {
+		if other := s.ring.GetPreferenceList(key); strings.Join(other, ",") != strings.Join(nodes, ",") {
+			err := fmt.Errorf("%w: '%s' is on %v but '%s' is on %v", ErrCrossPartition, keys[0], nodes, key, other)
+			if s.ring.HashTags() {
+				err = fmt.Errorf("%w, give them a common hash tag like {user42}", err)
+			}
+			return nil, err
+		}
+	}
+
+	defer s.lockKeys(keys...)()
+
+	for _, op := range ops {
+		if token := op["ifMatch"]; token != "" {
+			if err := s.checkPrecondition(op["key"], token); err != nil {
+				return nil, fmt.Errorf("key '%s': %w", op["key"], err)
+			}
+		}
+	}
+
+	writes := make([]*pendingWrite, len(ops))
+	txnWrites := make([]txn.Write, len(ops))
+	for i, op := range ops {
+		body := make(map[string]string, len(op))
+		for k, v := range op {
+			body[k] = v
+		}
+		delete(body, "tombstone")
+		if op["op"] == "delete" {
+			body["tombstone"] = "true"
+			delete(body, "ttl")
+		}
+
+		w, err := s.prepareWrite(body)
+		if err != nil {
+			return nil, fmt.Errorf("%w: key '%s': %v", ErrInvalidTransaction, op["key"], err)
+		}
+		writes[i] = w
+		txnWrites[i] = txn.Write{
+			Key:         w.key,
+			Value:       w.value,
+			VectorClock: w.vectorClock,
+			ExpiresAt:   model.FormatExpiry(w.expiresAt),
+			Tombstone:   w.tombstone,
+		}
+	}
+
+	//like put the DB copy comes first, it hands out the dots, so a later /set can't
+	//reuse the dot of a transactional write. An abort takes the copies back
+	for i, w := range writes {
+		if err := s.storeLocally(w); err != nil {
+			s.unstoreLocally(writes[:i])
+			return nil, fmt.Errorf("key '%s': %w", w.key, err)
+		}
+	}
+
+	id := txn.NewID()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := s.txns.Run(ctx, nodes, id, txnWrites); err != nil {
+		s.unstoreLocally(writes)
+		return nil, err
+	}
+
+	result := &TxnResult{ID: id, Results: make([]BatchResult, len(ops))}
+	for i, w := range writes {
+		result.Results[i] = BatchResult{Key: w.key, Context: encodeCausalContext(w.vectorClock)}
+		if err := s.recordChange(w.key, w.replicated()); err != nil {
+			result.Results[i].Context = ""
+			result.Results[i].Error = err.Error()
+		}
+	}
+
+	log.Printf("[TXN] %s committed %d keys on %v", id, len(ops), nodes)
+	return result, nil
+}
+
+// TxnStatus is committed, aborted or pending, see txn.Coordinator.Status
+func (s *MainService) TxnStatus(id string) string {
+	return s.txns.Status(id)
+}
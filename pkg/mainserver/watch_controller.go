@@ -0,0 +1,70 @@
+package mainserver
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WatchKey serves /watch/:key as server-sent events, every event carries the
+// key's sibling set and has its causal context as id. A client reconnects with
+// Last-Event-ID (or ?context=) and only hears about versions it hasn't seen
+func (mc *MainController) WatchKey(c *gin.Context) {
+	key := c.Param("key")
+	if key == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "key is required"})
+		return
+	}
+
+	lastContext := c.Query("context")
+	if lastContext == "" {
+		lastContext = c.GetHeader("Last-Event-ID")
+	}
+	if _, err := decodeCausalContext(lastContext); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := mc.service.changesReadable(mc.service.LastChange()); err != nil {
+		mc.changesError(c, err)
+		return
+	}
+
+	startSSE(c)
+	send := func(ev WatchEvent) error {
+		return writeSSE(c, ev.Context, "siblings", ev)
+	}
+	err := mc.service.WatchKey(c.Request.Context(), key, lastContext, send, sseHeartbeat(c))
+	mc.endSSE(c, "Watch of key='"+key+"'", err)
+}
+
+// WatchPrefix serves /watch?prefix= as server-sent events, one per changed key
+// with the change log offset as id, so Last-Event-ID (or ?after=) resumes it.
+// Without an offset it starts with the next change
+func (mc *MainController) WatchPrefix(c *gin.Context) {
+	from := c.Query("after")
+	if from == "" {
+		from = c.GetHeader("Last-Event-ID")
+	}
+
+	after := mc.service.LastChange()
+	if from != "" {
+		n, err := strconv.ParseUint(from, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "after must be a change offset"})
+			return
+		}
+		after = n
+	}
+	if err := mc.service.changesReadable(after); err != nil {
+		mc.changesError(c, err)
+		return
+	}
+
+	startSSE(c)
+	send := func(ev WatchEvent) error {
+		return writeSSE(c, strconv.FormatUint(ev.Offset, 10), "siblings", ev)
+	}
+	err := mc.service.WatchPrefix(c.Request.Context(), c.Query("prefix"), after, send, sseHeartbeat(c))
+	mc.endSSE(c, "Watch of prefix='"+c.Query("prefix")+"'", err)
+}
@@ -0,0 +1,116 @@
+package mainserver
+
+import (
+	"context"
+
+	"github.com/rupeshx80/consistent-hashing/pkg/changelog"
+)
+
+// WatchEvent is the sibling set of a key after it changed, Deleted with no
+// versions when the change removed it. Offset is the change that caused it
+type WatchEvent struct {
+	Key      string           `json:"key"`
+	Versions []VersionedValue `json:"versions"`
+	Context  string           `json:"context,omitempty"`
+	Deleted  bool             `json:"deleted,omitempty"`
+	Offset   uint64           `json:"offset,omitempty"`
+}
+
+// siblingSet reads the current versions of key the way /get does, vc is the
+// clock of the change that triggered the read and is the context of a delete
+func (s *MainService) siblingSet(key, vc string) WatchEvent {
+	versions, err := s.Get(key)
+	if err == nil && len(versions) > 0 {
+		return WatchEvent{Key: key, Versions: versions, Context: s.CausalContext(versions)}
+	}
+
+	//the tombstone is hidden by Get, its clock is still in the stored versions
+	if vc == "" {
+		if stored, err := s.repository.GetAllVersions(key); err == nil {
+			all := make([]VersionedValue, 0, len(stored))
+			for _, kv := range stored {
+				all = append(all, VersionedValue{VectorClock: kv.VectorClock})
+			}
+			vc = mergedVectorClock(all)
+		}
+	}
+	return WatchEvent{Key: key, Versions: []VersionedValue{}, Context: encodeCausalContext(vc), Deleted: true}
+}
+
+//true when a client holding context has already seen everything in current
+func seenContext(context, current string) bool {
+	if current == "" {
+		return context == ""
+	}
+	seen, err := decodeCausalContext(context)
+	if err != nil || seen == "" {
+		return false
+	}
+	now, _ := decodeCausalContext(current)
+	return descends(parseVC(seen), parseVC(now))
+}
+
+// WatchKey sends the sibling set of key every time a change to it lands in the
+// change log: client writes, replicated writes and read repairs, which record
+// the versions they pushed to stale nodes. lastContext is the context of the last event a reconnecting client saw,
+// the current set is sent first unless that context already covers it
+func (s *MainService) WatchKey(ctx context.Context, key, lastContext string, send func(WatchEvent) error, heartbeat func() error) error {
+	if s.changes == nil {
+		return ErrChangesDisabled
+	}
+
+	//take the offset before reading so a write in between isn't lost
+	after := s.changes.Last()
+
+	current := s.siblingSet(key, "")
+	last := lastContext
+	if !seenContext(last, current.Context) {
+		if err := send(current); err != nil {
+			return err
+		}
+		last = current.Context
+	}
+
+	return s.StreamChanges(ctx, after, key, func(c changelog.Change) error {
+		//the log filters by prefix, a watch is on the exact key
+		if c.Key != key || c.Op == changelog.OpCAS {
+			return nil
+		}
+
+		ev := s.siblingSet(key, c.VectorClock)
+		if ev.Context != "" && ev.Context == last {
+			return nil
+		}
+		ev.Offset = c.Offset
+		last = ev.Context
+		return send(ev)
+	}, heartbeat)
+}
+
+// WatchPrefix sends the sibling set of every key with prefix that changes after
+// offset "after", a reconnecting client passes the offset of the last event
+func (s *MainService) WatchPrefix(ctx context.Context, prefix string, after uint64, send func(WatchEvent) error, heartbeat func() error) error {
+	if s.changes == nil {
+		return ErrChangesDisabled
+	}
+	if err := s.changesReadable(after); err != nil {
+		return err
+	}
+
+	return s.StreamChanges(ctx, after, prefix, func(c changelog.Change) error {
+		if c.Op == changelog.OpCAS {
+			return nil
+		}
+		ev := s.siblingSet(c.Key, c.VectorClock)
+		ev.Offset = c.Offset
+		return send(ev)
+	}, heartbeat)
+}
+
+// LastChange is where a new prefix watch starts when it has no offset to resume from
+func (s *MainService) LastChange() uint64 {
+	if s.changes == nil {
+		return 0
+	}
+	return s.changes.Last()
+}
@@ -0,0 +1,117 @@
+package mainserver
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rupeshx80/consistent-hashing/pkg/webhook"
+)
+
+type webhookRequest struct {
+	Prefix string `json:"prefix"`
+	URL    string `json:"url" binding:"required"`
+	Secret string `json:"secret"` //generated when empty
+}
+
+func webhookError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrWebhooksDisabled):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, webhook.ErrWebhookNotFound), errors.Is(err, webhook.ErrDeadLetterNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// CreateWebhook answers with the webhook and its signing secret, the only time the secret is shown
+func (mc *MainController) CreateWebhook(c *gin.Context) {
+	var req webhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "url is required"})
+		return
+	}
+
+	hook, err := mc.service.RegisterWebhook(req.Prefix, req.URL, req.Secret)
+	if err != nil {
+		if errors.Is(err, ErrWebhooksDisabled) {
+			webhookError(c, err)
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, hook)
+}
+
+func (mc *MainController) ListWebhooks(c *gin.Context) {
+	hooks, err := mc.service.Webhooks()
+	if err != nil {
+		webhookError(c, err)
+		return
+	}
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+	c.JSON(http.StatusOK, gin.H{"webhooks": hooks})
+}
+
+func (mc *MainController) DeleteWebhook(c *gin.Context) {
+	if err := mc.service.RemoveWebhook(c.Param("id")); err != nil {
+		webhookError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "webhook removed"})
+}
+
+// ListDeadLetters serves ?webhook= to narrow the list to one webhook
+func (mc *MainController) ListDeadLetters(c *gin.Context) {
+	letters, err := mc.service.DeadLetters(c.Query("webhook"))
+	if err != nil {
+		webhookError(c, err)
+		return
+	}
+	if letters == nil {
+		letters = []webhook.DeadLetter{}
+	}
+	c.JSON(http.StatusOK, gin.H{"deadLetters": letters})
+}
+
+// ReplayDeadLetter retries one failed delivery, 502 when the endpoint still rejects it
+func (mc *MainController) ReplayDeadLetter(c *gin.Context) {
+	failed, err := mc.service.ReplayDeadLetters([]string{c.Param("id")})
+	if err != nil {
+		webhookError(c, err)
+		return
+	}
+	if msg, ok := failed[c.Param("id")]; ok {
+		if msg == webhook.ErrDeadLetterNotFound.Error() || msg == webhook.ErrWebhookNotFound.Error() {
+			c.JSON(http.StatusNotFound, gin.H{"error": msg})
+			return
+		}
+		c.JSON(http.StatusBadGateway, gin.H{"error": msg})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "delivered"})
+}
+
+// ReplayDeadLetters retries every dead letter, or those of ?webhook=, and lists what failed again
+func (mc *MainController) ReplayDeadLetters(c *gin.Context) {
+	letters, err := mc.service.DeadLetters(c.Query("webhook"))
+	if err != nil {
+		webhookError(c, err)
+		return
+	}
+
+	ids := make([]string, 0, len(letters))
+	for _, d := range letters {
+		ids = append(ids, d.ID)
+	}
+	failed, err := mc.service.ReplayDeadLetters(ids)
+	if err != nil {
+		webhookError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"replayed": len(ids) - len(failed), "failed": failed})
+}
@@ -0,0 +1,78 @@
+package mainserver
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/rupeshx80/consistent-hashing/pkg/changelog"
+	"github.com/rupeshx80/consistent-hashing/pkg/webhook"
+)
+
+var ErrWebhooksDisabled = errors.New("webhooks are not enabled")
+
+// StartWebhooks delivers changes to the registered webhooks, it needs the change log
+func StartWebhooks(service *MainService, store *webhook.Store, cfg webhook.Config) {
+	if store == nil || service.changes == nil {
+		return
+	}
+	service.webhooks = webhook.NewDispatcher(cfg, store, service.changes, service.webhookPayload)
+	service.webhooks.Start()
+}
+
+//a write or delete sends the key's sibling set, the same thing /watch pushes
+func (s *MainService) webhookPayload(c changelog.Change) interface{} {
+	if c.Op == changelog.OpCAS {
+		return map[string]string{"key": c.Key, "value": c.Value}
+	}
+	return s.siblingSet(c.Key, c.VectorClock)
+}
+
+func (s *MainService) RegisterWebhook(prefix, rawURL, secret string) (webhook.Webhook, error) {
+	if s.webhooks == nil {
+		return webhook.Webhook{}, ErrWebhooksDisabled
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return webhook.Webhook{}, fmt.Errorf("url must be an absolute http(s) url")
+	}
+	return s.webhooks.Register(webhook.Webhook{Prefix: prefix, URL: rawURL, Secret: secret})
+}
+
+func (s *MainService) RemoveWebhook(id string) error {
+	if s.webhooks == nil {
+		return ErrWebhooksDisabled
+	}
+	return s.webhooks.Unregister(id)
+}
+
+func (s *MainService) Webhooks() ([]webhook.Webhook, error) {
+	if s.webhooks == nil {
+		return nil, ErrWebhooksDisabled
+	}
+	return s.webhooks.Webhooks(), nil
+}
+
+func (s *MainService) DeadLetters(webhookID string) ([]webhook.DeadLetter, error) {
+	if s.webhooks == nil {
+		return nil, ErrWebhooksDisabled
+	}
+	return s.webhooks.DeadLetters(webhookID), nil
+}
+
+// ReplayDeadLetters retries the given dead letters one by one and returns the
+// ones that failed again with their error
+func (s *MainService) ReplayDeadLetters(ids []string) (map[string]string, error) {
+	if s.webhooks == nil {
+		return nil, ErrWebhooksDisabled
+	}
+
+	failed := make(map[string]string)
+	for _, id := range ids {
+		if err := s.webhooks.Replay(id); err != nil {
+			failed[id] = err.Error()
+		}
+	}
+	return failed, nil
+}
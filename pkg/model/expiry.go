@@ -0,0 +1,50 @@
+package model
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ExpiresAt travels between nodes as an RFC3339 string, empty means no TTL
+
+func FormatExpiry(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func ParseExpiry(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expiresAt '%s': %w", s, err)
+	}
+	return &t, nil
+}
+
+// ParseTTL accepts a go duration ("90s", "1h") or a plain number of seconds
+func ParseTTL(s string) (time.Duration, error) {
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if secs <= 0 {
+			return 0, fmt.Errorf("ttl must be positive")
+		}
+		return time.Duration(secs) * time.Second, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ttl '%s': %w", s, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("ttl must be positive")
+	}
+	return d, nil
+}
+
+func IsExpired(expiresAt *time.Time, now time.Time) bool {
+	return expiresAt != nil && !now.Before(*expiresAt)
+}
@@ -5,10 +5,14 @@ import (
 	"time"
 )
 
+// KeyValue is one version of a key, the tables and their indexes are created by
+// the migrations in storage/migrations.go and not from these tags
 type KeyValue struct {
 	gorm.Model
-	Key         string `gorm:"not null; index"`
+	Key         string `gorm:"not null"`
 	Value       string `gorm:"type:text"`
 	VectorClock string `gorm:"type:text"`
 	CreatedAt   time.Time
+	ExpiresAt   *time.Time //nil means the version never expires
+	Tombstone   bool       `gorm:"not null;default:false"` //a delete, kept as a version so it wins over stale replicas
 }
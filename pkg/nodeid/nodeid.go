@@ -0,0 +1,48 @@
+package nodeid
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// LoadOrCreate returns the node id stored at path, generating and persisting a
+// new uuid on first boot. The id outlives address changes, so vector clocks and
+// ring positions stay the same when a node moves to another host or port
+func LoadOrCreate(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		id := strings.TrimSpace(string(data))
+		if _, err := uuid.Parse(id); err != nil {
+			return "", fmt.Errorf("corrupt node id in %s: %w", path, err)
+		}
+		return id, nil
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("failed to read node id: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", fmt.Errorf("failed to create node dir: %w", err)
+	}
+
+	id := uuid.NewString()
+
+	//write then rename so a crash never leaves a half written id behind
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(id+"\n"), 0o644); err != nil {
+		return "", fmt.Errorf("failed to write node id: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return "", fmt.Errorf("failed to persist node id: %w", err)
+	}
+
+	log.Printf("[NODE] Generated new node id=%s at %s", id, path)
+	return id, nil
+}
@@ -0,0 +1,187 @@
+package paxos
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/rupeshx80/consistent-hashing/pkg/storage"
+)
+
+// Ballot orders proposals, ties on the counter are broken by proposer id
+type Ballot struct {
+	Counter  int64  `json:"counter"`
+	Proposer string `json:"proposer"`
+}
+
+func (b Ballot) Less(other Ballot) bool {
+	if b.Counter != other.Counter {
+		return b.Counter < other.Counter
+	}
+	return b.Proposer < other.Proposer
+}
+
+func (b Ballot) IsZero() bool {
+	return b.Counter == 0 && b.Proposer == ""
+}
+
+type Proposal struct {
+	Ballot Ballot `json:"ballot"`
+	Value  string `json:"value"`
+}
+
+type PrepareResponse struct {
+	Promised  bool      `json:"promised"`
+	Promise   Ballot    `json:"promise"`            //highest ballot this acceptor has promised
+	Accepted  *Proposal `json:"accepted,omitempty"`  //accepted but maybe not committed yet
+	Committed *Proposal `json:"committed,omitempty"` //most recent committed value
+}
+
+type AcceptResponse struct {
+	Accepted bool   `json:"accepted"`
+	Promise  Ballot `json:"promise"`
+}
+
+type keyState struct {
+	Promised  Ballot    `json:"promised"`
+	Accepted  *Proposal `json:"accepted,omitempty"`
+	Committed *Proposal `json:"committed,omitempty"`
+}
+
+// Acceptor keeps paxos state per key, lwt keys live here and not in the
+// versioned store so they never mix with sibling versions. Every promise and
+// accept is written to store before it is answered, an acceptor that forgot
+// a promise after a restart could accept a lower ballot
+type Acceptor struct {
+	state map[string]*keyState
+	store storage.Storage //nil keeps the state in memory only
+	mu    sync.Mutex
+}
+
+func NewAcceptor(store storage.Storage) *Acceptor {
+	return &Acceptor{state: make(map[string]*keyState), store: store}
+}
+
+func stateKey(key string) string {
+	return storage.SystemPrefix + "paxos/" + key
+}
+
+//get loads the key's state from the store the first time it is used
+func (a *Acceptor) get(key string) (*keyState, error) {
+	if st, ok := a.state[key]; ok {
+		return st, nil
+	}
+
+	st := &keyState{}
+	if a.store != nil {
+		data, ok, err := storage.LatestValue(a.store, stateKey(key))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			if err := json.Unmarshal([]byte(data), st); err != nil {
+				return nil, fmt.Errorf("corrupt paxos state for key '%s': %w", key, err)
+			}
+		}
+	}
+	a.state[key] = st
+	return st, nil
+}
+
+//save makes next the key's state, on disk first so nothing is answered from
+//state a restart could lose
+func (a *Acceptor) save(key string, next keyState) error {
+	if a.store != nil {
+		data, err := json.Marshal(next)
+		if err != nil {
+			return err
+		}
+		if err := storage.ReplaceValue(a.store, stateKey(key), string(data)); err != nil {
+			return fmt.Errorf("failed to persist paxos state for key '%s': %w", key, err)
+		}
+	}
+	a.state[key] = &next
+	return nil
+}
+
+func (a *Acceptor) Prepare(key string, ballot Ballot) (PrepareResponse, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	st, err := a.get(key)
+	if err != nil {
+		return PrepareResponse{}, err
+	}
+	if ballot.Less(st.Promised) || ballot == st.Promised {
+		log.Printf("[PAXOS] Rejected prepare key='%s' ballot=%v promised=%v", key, ballot, st.Promised)
+		return PrepareResponse{Promised: false, Promise: st.Promised}, nil
+	}
+
+	next := *st
+	next.Promised = ballot
+	if err := a.save(key, next); err != nil {
+		return PrepareResponse{}, err
+	}
+	return PrepareResponse{
+		Promised:  true,
+		Promise:   ballot,
+		Accepted:  copyProposal(next.Accepted),
+		Committed: copyProposal(next.Committed),
+	}, nil
+}
+
+func (a *Acceptor) Accept(key string, p Proposal) (AcceptResponse, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	st, err := a.get(key)
+	if err != nil {
+		return AcceptResponse{}, err
+	}
+	if p.Ballot.Less(st.Promised) {
+		log.Printf("[PAXOS] Rejected accept key='%s' ballot=%v promised=%v", key, p.Ballot, st.Promised)
+		return AcceptResponse{Accepted: false, Promise: st.Promised}, nil
+	}
+
+	next := *st
+	next.Promised = p.Ballot
+	next.Accepted = copyProposal(&p)
+	if err := a.save(key, next); err != nil {
+		return AcceptResponse{}, err
+	}
+	return AcceptResponse{Accepted: true, Promise: p.Ballot}, nil
+}
+
+func (a *Acceptor) Commit(key string, p Proposal) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	st, err := a.get(key)
+	if err != nil {
+		return err
+	}
+
+	next := *st
+	if next.Committed == nil || next.Committed.Ballot.Less(p.Ballot) {
+		next.Committed = copyProposal(&p)
+	}
+
+	//the accepted proposal is now decided, it must not be re-proposed
+	if next.Accepted != nil && !p.Ballot.Less(next.Accepted.Ballot) {
+		next.Accepted = nil
+	}
+	if err := a.save(key, next); err != nil {
+		return err
+	}
+	log.Printf("[PAXOS] Committed key='%s' ballot=%v", key, p.Ballot)
+	return nil
+}
+
+func copyProposal(p *Proposal) *Proposal {
+	if p == nil {
+		return nil
+	}
+	cp := *p
+	return &cp
+}
@@ -0,0 +1,69 @@
+package paxos
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AcceptorController struct {
+	acceptor *Acceptor
+}
+
+type prepareRequest struct {
+	Key    string `json:"key" binding:"required"`
+	Ballot Ballot `json:"ballot"`
+}
+
+type proposalRequest struct {
+	Key      string   `json:"key" binding:"required"`
+	Proposal Proposal `json:"proposal"`
+}
+
+func NewAcceptorController(acceptor *Acceptor) *AcceptorController {
+	return &AcceptorController{acceptor: acceptor}
+}
+
+func (ac *AcceptorController) Prepare(ctx *gin.Context) {
+	var req prepareRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := ac.acceptor.Prepare(req.Key, req.Ballot)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, resp)
+}
+
+func (ac *AcceptorController) Accept(ctx *gin.Context) {
+	var req proposalRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := ac.acceptor.Accept(req.Key, req.Proposal)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, resp)
+}
+
+func (ac *AcceptorController) Commit(ctx *gin.Context) {
+	var req proposalRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := ac.acceptor.Commit(req.Key, req.Proposal); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "committed"})
+}
@@ -0,0 +1,289 @@
+package paxos
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var ErrContention = errors.New("paxos: gave up after repeated ballot conflicts")
+
+// Result of a linearizable operation, Value is only meaningful when Exists is true
+type Result struct {
+	Applied bool   `json:"applied"`
+	Exists  bool   `json:"exists"`
+	Value   string `json:"value,omitempty"`
+}
+
+// Proposer drives single key paxos rounds over a key's preference list,
+// the same shape as cassandra's lightweight transactions
+type Proposer struct {
+	id          string
+	httpClient  *http.Client
+	maxAttempts int
+
+	mu          sync.Mutex
+	lastCounter int64
+}
+
+func NewProposer(id string) *Proposer {
+	return &Proposer{
+		id:          id,
+		httpClient:  &http.Client{Timeout: 5 * time.Second},
+		maxAttempts: 10,
+	}
+}
+
+func (p *Proposer) nextBallot(seen Ballot) Ballot {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	counter := time.Now().UnixNano()
+	if counter <= p.lastCounter {
+		counter = p.lastCounter + 1
+	}
+	if counter <= seen.Counter {
+		counter = seen.Counter + 1
+	}
+	p.lastCounter = counter
+	return Ballot{Counter: counter, Proposer: p.id}
+}
+
+// CompareAndSet writes value only if the committed value equals expected,
+// a nil expected means the key must not exist yet
+func (p *Proposer) CompareAndSet(ctx context.Context, nodes []string, key string, expected *string, value string) (*Result, error) {
+	return p.run(ctx, nodes, key, func(current *Proposal) (*string, bool) {
+		if expected == nil {
+			return &value, current == nil
+		}
+		return &value, current != nil && current.Value == *expected
+	})
+}
+
+// Read returns the latest committed value, finishing any in-flight proposal
+// first so the read can't observe a value that later disappears
+func (p *Proposer) Read(ctx context.Context, nodes []string, key string) (*Result, error) {
+	return p.run(ctx, nodes, key, func(current *Proposal) (*string, bool) {
+		return nil, false
+	})
+}
+
+// run loops until one round completes, decide gets the committed state and
+// returns the value to propose plus whether the condition holds
+func (p *Proposer) run(ctx context.Context, nodes []string, key string, decide func(current *Proposal) (*string, bool)) (*Result, error) {
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no nodes available for key: %s", key)
+	}
+
+	seen := Ballot{}
+	for attempt := 1; attempt <= p.maxAttempts; attempt++ {
+		ballot := p.nextBallot(seen)
+
+		committed, retry, highest, err := p.prepare(ctx, nodes, key, ballot)
+		if err != nil {
+			//not enough nodes answered, retrying won't help
+			if !ballot.Less(highest) {
+				return nil, err
+			}
+			seen = highest
+			log.Printf("[PAXOS] Ballot %v preempted on key='%s', attempt=%d", ballot, key, attempt)
+			p.backoff(ctx, attempt)
+			continue
+		}
+
+		//prepare finished an in-flight proposal, start over on a fresh ballot
+		if retry {
+			continue
+		}
+
+		newValue, ok := decide(committed)
+
+		result := &Result{Applied: false}
+		if committed != nil {
+			result.Exists = true
+			result.Value = committed.Value
+		}
+
+		if !ok || newValue == nil {
+			return result, nil
+		}
+
+		proposal := Proposal{Ballot: ballot, Value: *newValue}
+		if highest, err := p.accept(ctx, nodes, key, proposal); err != nil {
+			if !ballot.Less(highest) {
+				return nil, err
+			}
+			seen = highest
+			log.Printf("[PAXOS] Proposal %v rejected on key='%s', attempt=%d", ballot, key, attempt)
+			p.backoff(ctx, attempt)
+			continue
+		}
+
+		if err := p.commit(ctx, nodes, key, proposal); err != nil {
+			return nil, err
+		}
+
+		log.Printf("[PAXOS] CAS applied on key='%s' ballot=%v", key, ballot)
+		return &Result{Applied: true, Exists: true, Value: *newValue}, nil
+	}
+
+	return nil, ErrContention
+}
+
+// prepare gathers promises from a majority and returns the latest committed value,
+// on rejection it returns the highest ballot seen. When it had to complete an
+// accepted but uncommitted proposal it asks the caller to retry
+func (p *Proposer) prepare(ctx context.Context, nodes []string, key string, ballot Ballot) (*Proposal, bool, Ballot, error) {
+	payload, _ := json.Marshal(prepareRequest{Key: key, Ballot: ballot})
+	responses := p.broadcast(ctx, nodes, "/paxos/prepare", payload)
+
+	promised := 0
+	highest := ballot
+	var committed, inProgress *Proposal
+	for _, raw := range responses {
+		var resp PrepareResponse
+		if json.Unmarshal(raw, &resp) != nil {
+			continue
+		}
+		if !resp.Promised {
+			if highest.Less(resp.Promise) {
+				highest = resp.Promise
+			}
+			continue
+		}
+
+		promised++
+		if resp.Committed != nil && (committed == nil || committed.Ballot.Less(resp.Committed.Ballot)) {
+			committed = resp.Committed
+		}
+		if resp.Accepted != nil && (inProgress == nil || inProgress.Ballot.Less(resp.Accepted.Ballot)) {
+			inProgress = resp.Accepted
+		}
+	}
+
+	if promised < majority(nodes) {
+		return nil, false, highest, fmt.Errorf("paxos prepare failed: got %d promises, needed %d", promised, majority(nodes))
+	}
+
+	//an accepted value newer than the last commit may already be decided, finish it first
+	if inProgress != nil && (committed == nil || committed.Ballot.Less(inProgress.Ballot)) {
+		log.Printf("[PAXOS] Finishing in-progress proposal %v on key='%s'", inProgress.Ballot, key)
+		repair := Proposal{Ballot: ballot, Value: inProgress.Value}
+		if highest, err := p.accept(ctx, nodes, key, repair); err != nil {
+			return nil, false, highest, err
+		}
+		if err := p.commit(ctx, nodes, key, repair); err != nil {
+			return nil, false, ballot, err
+		}
+		return nil, true, ballot, nil
+	}
+
+	//bring replicas that missed the last commit up to date
+	if committed != nil {
+		if err := p.commit(ctx, nodes, key, *committed); err != nil {
+			return nil, false, ballot, err
+		}
+	}
+
+	return committed, false, ballot, nil
+}
+
+func (p *Proposer) accept(ctx context.Context, nodes []string, key string, proposal Proposal) (Ballot, error) {
+	payload, _ := json.Marshal(proposalRequest{Key: key, Proposal: proposal})
+	responses := p.broadcast(ctx, nodes, "/paxos/accept", payload)
+
+	accepted := 0
+	highest := proposal.Ballot
+	for _, raw := range responses {
+		var resp AcceptResponse
+		if json.Unmarshal(raw, &resp) != nil {
+			continue
+		}
+		if resp.Accepted {
+			accepted++
+		} else if highest.Less(resp.Promise) {
+			highest = resp.Promise
+		}
+	}
+
+	if accepted < majority(nodes) {
+		return highest, fmt.Errorf("paxos accept failed: got %d accepts, needed %d", accepted, majority(nodes))
+	}
+	return highest, nil
+}
+
+func (p *Proposer) commit(ctx context.Context, nodes []string, key string, proposal Proposal) error {
+	payload, _ := json.Marshal(proposalRequest{Key: key, Proposal: proposal})
+	responses := p.broadcast(ctx, nodes, "/paxos/commit", payload)
+
+	if len(responses) < majority(nodes) {
+		return fmt.Errorf("paxos commit failed: got %d acks, needed %d", len(responses), majority(nodes))
+	}
+	return nil
+}
+
+// broadcast posts payload to every node and returns the bodies of the 200 responses
+func (p *Proposer) broadcast(ctx context.Context, nodes []string, path string, payload []byte) [][]byte {
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		out [][]byte
+	)
+
+	for _, node := range nodes {
+		wg.Add(1)
+		go func(n string) {
+			defer wg.Done()
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://127.0.0.1"+n+path, bytes.NewReader(payload))
+			if err != nil {
+				return
+			}
+			req.Header.Set("Content-Type", "application/json")
+
+			resp, err := p.httpClient.Do(req)
+			if err != nil {
+				log.Printf("[PAXOS] Error calling %s on node=%s, err=%v", path, n, err)
+				return
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				log.Printf("[PAXOS] Node=%s returned non-200=%d for %s", n, resp.StatusCode, path)
+				return
+			}
+
+			var body bytes.Buffer
+			if _, err := body.ReadFrom(resp.Body); err != nil {
+				return
+			}
+
+			mu.Lock()
+			out = append(out, body.Bytes())
+			mu.Unlock()
+		}(node)
+	}
+
+	wg.Wait()
+	return out
+}
+
+//randomised backoff so competing proposers stop preempting each other
+func (p *Proposer) backoff(ctx context.Context, attempt int) {
+	wait := time.Duration(rand.Intn(10*attempt)+1) * time.Millisecond
+	select {
+	case <-ctx.Done():
+	case <-time.After(wait):
+	}
+}
+
+func majority(nodes []string) int {
+	return len(nodes)/2 + 1
+}
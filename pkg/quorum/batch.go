@@ -0,0 +1,203 @@
+package quorum
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// BatchItem is one key of a batch write, every item of a batch shares the same replica set
+type BatchItem struct {
+	Key string
+	VersionedValue
+}
+
+type batchNodeResponse struct {
+	node   string
+	err    error
+	failed map[string]string           //per key errors of a batch write
+	found  map[string][]VersionedValue //versions of a batch read
+}
+
+// WriteQuorumBatch sends all items to every node of the preference list in one
+// request per node and returns the keys that did not reach W acknowledgements
+func (qm *QuorumManager) WriteQuorumBatch(ctx context.Context, nodes []string, items []BatchItem) map[string]error {
+	errs := make(map[string]error)
+
+	required := qm.config.W
+	if required <= 0 || required > len(nodes) {
+		for _, item := range items {
+			errs[item.Key] = fmt.Errorf("invalid W=%d for %d nodes", qm.config.W, len(nodes))
+		}
+		return errs
+	}
+	if len(items) == 0 {
+		return errs
+	}
+
+	payloadItems := make([]map[string]interface{}, 0, len(items))
+	for _, item := range items {
+		payloadItems = append(payloadItems, map[string]interface{}{
+			"key":         item.Key,
+			"value":       item.Value,
+			"vectorClock": item.VectorClock,
+			"expiresAt":   item.ExpiresAt,
+			"tombstone":   item.Tombstone,
+		})
+	}
+	payload, err := json.Marshal(map[string]interface{}{"items": payloadItems})
+	if err != nil {
+		for _, item := range items {
+			errs[item.Key] = fmt.Errorf("failed to marshal payload: %w", err)
+		}
+		return errs
+	}
+
+	log.Printf("[WRITE-BATCH] Writing %d keys to replicas=%v, required=%d", len(items), nodes, required)
+	responses := qm.broadcastBatch(ctx, nodes, "/mset", payload)
+
+	acks := make(map[string]int, len(items))
+	pending := len(items)
+	for r := range responses {
+		if r.err != nil {
+			log.Printf("[WRITE-BATCH] Node=%s failed, err=%v", r.node, r.err)
+			continue
+		}
+		for _, item := range items {
+			if msg, failed := r.failed[item.Key]; failed {
+				log.Printf("[WRITE-BATCH] Node=%s rejected key='%s': %s", r.node, item.Key, msg)
+				continue
+			}
+			acks[item.Key]++
+			if acks[item.Key] == required {
+				pending--
+			}
+		}
+		//you get low latency by returning as soon as every key has its quorum
+		if pending == 0 {
+			break
+		}
+	}
+
+	for _, item := range items {
+		if acks[item.Key] < required {
+			errs[item.Key] = fmt.Errorf("write quorum failed: got %d successes, needed %d", acks[item.Key], required)
+		}
+	}
+	return errs
+}
+
+// ReadQuorumBatch reads all keys from the replica set in one request per node, a key
+// missing from every answer comes back with no versions. When fewer than R nodes
+// answer the whole batch fails
+func (qm *QuorumManager) ReadQuorumBatch(ctx context.Context, nodes []string, keys []string) (map[string][]VersionedValue, error) {
+	required := qm.config.R
+
+	payload, err := json.Marshal(map[string]interface{}{"keys": keys})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	log.Printf("[READ-BATCH] Reading %d keys from nodes=%v, required=%d", len(keys), nodes, required)
+	responses := qm.broadcastBatch(ctx, nodes, "/mget", payload)
+
+	collected := make(map[string][]VersionedValue, len(keys))
+	byKey := make(map[string]map[string][]VersionedValue, len(keys))
+	successCount := 0
+	failedNodes := make([]string, 0)
+	for r := range responses {
+		if r.err != nil {
+			failedNodes = append(failedNodes, r.node)
+			log.Printf("[READ-BATCH] Node=%s failed, err=%v", r.node, r.err)
+			continue
+		}
+
+		for key, versions := range r.found {
+			for i := range versions {
+				versions[i].NodeID = r.node
+			}
+			collected[key] = append(collected[key], versions...)
+		}
+		for _, key := range keys {
+			if byKey[key] == nil {
+				byKey[key] = make(map[string][]VersionedValue)
+			}
+			byKey[key][r.node] = r.found[key]
+		}
+		successCount++
+		if successCount >= required {
+			break
+		}
+	}
+
+	if successCount < required {
+		return nil, fmt.Errorf("read quorum failed: got %d successes, needed %d (failed nodes: %v)",
+			successCount, required, failedNodes)
+	}
+
+	out := make(map[string][]VersionedValue, len(keys))
+	for _, key := range keys {
+		qm.repair(key, byKey[key])
+		out[key] = qm.deduplicateVersions(collected[key])
+	}
+	return out, nil
+}
+
+// broadcastBatch posts payload to every node, the channel closes once all nodes
+// answered or the quorum timeout passed
+func (qm *QuorumManager) broadcastBatch(ctx context.Context, nodes []string, path string, payload []byte) <-chan batchNodeResponse {
+	ctx, cancel := context.WithTimeout(ctx, qm.timeout)
+
+	responses := make(chan batchNodeResponse, len(nodes))
+	out := make(chan batchNodeResponse, len(nodes))
+
+	for _, node := range nodes {
+		go func(n string) {
+			responses <- qm.postBatch(ctx, n, path, payload)
+		}(node)
+	}
+
+	go func() {
+		defer cancel()
+		defer close(out)
+		for range nodes {
+			select {
+			case r := <-responses:
+				out <- r
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func (qm *QuorumManager) postBatch(ctx context.Context, node, path string, payload []byte) batchNodeResponse {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://127.0.0.1"+node+path, bytes.NewReader(payload))
+	if err != nil {
+		return batchNodeResponse{node: node, err: err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := qm.httpClient.Do(req)
+	if err != nil {
+		return batchNodeResponse{node: node, err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return batchNodeResponse{node: node, err: fmt.Errorf("status=%d", resp.StatusCode)}
+	}
+
+	var body struct {
+		Errors  map[string]string           `json:"errors"`
+		Results map[string][]VersionedValue `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return batchNodeResponse{node: node, err: err}
+	}
+	return batchNodeResponse{node: node, failed: body.Errors, found: body.Results}
+}
@@ -8,6 +8,7 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -33,6 +34,7 @@ type QuorumManager struct {
 	config     *QuorumConfig
 	httpClient *http.Client
 	timeout    time.Duration
+	onRepair   RepairHook
 }
 
 func NewQuorumManager(config *QuorumConfig) *QuorumManager {
@@ -43,27 +45,25 @@ func NewQuorumManager(config *QuorumConfig) *QuorumManager {
 	}
 }
 
-func (qm *QuorumManager) WriteQuorum(ctx context.Context, nodes []string, key, value, vc string) error {
-	log.Printf("[WRITE] Starting write quorum for key='%s', value='%s', vc='%s'", key, value, vc)
+// WriteQuorum writes to every node of the preference list, the coordinator node
+// included, and waits for W of them. The coordinator's DB copy doesn't count, it
+// isn't a ring copy readers can reach
+func (qm *QuorumManager) WriteQuorum(ctx context.Context, nodes []string, key string, v VersionedValue) error {
+	log.Printf("[WRITE] Starting write quorum for key='%s', value='%s', vc='%s'", key, v.Value, v.VectorClock)
 
-	// We need W-1 successful replica writes (coordinator already wrote locally)
-	required := qm.config.W - 1
-	log.Printf("[WRITE] Required successful replica writes=%d", required)
+	required := qm.config.W
+	log.Printf("[WRITE] Required successful node writes=%d", required)
 
-	if required < 0 {
-		return fmt.Errorf("invalid W=%d", qm.config.W)
+	if required <= 0 || required > len(nodes) {
+		return fmt.Errorf("invalid W=%d for %d nodes", qm.config.W, len(nodes))
 	}
 
-	// If no replicas needed, return success
-	if required == 0 {
-		log.Printf("[WRITE] No replica writes required")
-		return nil
-	}
-
-	payload, err := json.Marshal(map[string]string{
+	payload, err := json.Marshal(map[string]interface{}{
 		"key":         key,
-		"value":       value,
-		"vectorClock": vc,
+		"value":       v.Value,
+		"vectorClock": v.VectorClock,
+		"expiresAt":   v.ExpiresAt,
+		"tombstone":   v.Tombstone,
 	})
 
 	if err != nil {
@@ -74,7 +74,7 @@ func (qm *QuorumManager) WriteQuorum(ctx context.Context, nodes []string, key, v
 	responses := make(chan QuorumResponse, len(nodes))
 	var wg sync.WaitGroup
 
-	// Send requests to all replica nodes
+	// Send requests to every node of the preference list
 	for _, node := range nodes {
 		log.Printf("[WRITE] Sending write request to replica node=%s", node)
 		wg.Add(1)
@@ -163,11 +163,24 @@ type VersionedValue struct {
 	Value       string `json:"value"`
 	VectorClock string `json:"vectorClock"`
 	CreatedAt   string `json:"createdAt"`
-	NodeID      string `json:"nodeId,omitempty"` //tracks which node returned this
+	ExpiresAt   string `json:"expiresAt,omitempty"`
+	Tombstone   bool   `json:"tombstone,omitempty"` //the key was deleted at this clock
+	NodeID      string `json:"nodeId,omitempty"`    //tracks which node returned this
 }
 
 func (qm *QuorumManager) ReadQuorum(ctx context.Context, nodes []string, key string) ([]VersionedValue, error) {
-	log.Printf("[READ] Starting read quorum for key='%s', R=%d", key, qm.config.R)
+	return qm.ReadQuorumN(ctx, nodes, key, qm.config.R)
+}
+
+// WriteQuorumSize is W, conditional writes read this many replicas so the
+// precondition is checked against every version a write quorum could have accepted
+func (qm *QuorumManager) WriteQuorumSize() int {
+	return qm.config.W
+}
+
+// ReadQuorumN is ReadQuorum with an explicit number of required responses
+func (qm *QuorumManager) ReadQuorumN(ctx context.Context, nodes []string, key string, required int) ([]VersionedValue, error) {
+	log.Printf("[READ] Starting read quorum for key='%s', required=%d", key, required)
 
 	responses := make(chan QuorumResponse, len(nodes))
 	var wg sync.WaitGroup
@@ -188,6 +201,13 @@ func (qm *QuorumManager) ReadQuorum(ctx context.Context, nodes []string, key str
 			}
 			defer resp.Body.Close() //for preventing http resource leak
 
+			//a replica without the key answered, it just holds no versions
+			if resp.StatusCode == http.StatusNotFound {
+				log.Printf("[READ] Node=%s has no versions", n)
+				responses <- QuorumResponse{Success: true, Data: []VersionedValue{}, NodeID: n}
+				return
+			}
+
 			if resp.StatusCode != http.StatusOK {
 				log.Printf("[READ] Node=%s returned non-200=%d", n, resp.StatusCode)
 				responses <- QuorumResponse{Success: false, Error: fmt.Errorf("status=%d", resp.StatusCode), NodeID: n}
@@ -227,10 +247,17 @@ func (qm *QuorumManager) ReadQuorum(ctx context.Context, nodes []string, key str
 	}()
 
 	var allVersions []VersionedValue
+	byNode := make(map[string][]VersionedValue, len(nodes))
 	successCount := 0
 	failedNodes := make([]string, 0)
 	deadline := time.Now().Add(qm.timeout)
 
+	//the nodes that answered get whatever current version they were missing
+	done := func() []VersionedValue {
+		qm.repair(key, byNode)
+		return qm.deduplicateVersions(allVersions)
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -240,26 +267,27 @@ func (qm *QuorumManager) ReadQuorum(ctx context.Context, nodes []string, key str
 		case r, ok := <-responses:
 			if !ok {
 				// All responses received
-				log.Printf("[READ] All responses received, success=%d, required=%d", successCount, qm.config.R)
-				if successCount >= qm.config.R {
+				log.Printf("[READ] All responses received, success=%d, required=%d", successCount, required)
+				if successCount >= required {
 					log.Printf("[READ] Read quorum satisfied, returning %d versions", len(allVersions))
-					return qm.deduplicateVersions(allVersions), nil
+					return done(), nil
 				}
 				return nil, fmt.Errorf("read quorum failed: got %d successes, needed %d (failed nodes: %v)",
-					successCount, qm.config.R, failedNodes)
+					successCount, required, failedNodes)
 			}
 
 			if r.Success {
 				if versions, ok := r.Data.([]VersionedValue); ok {
 					log.Printf("[READ] Adding %d versions from node=%s", len(versions), r.NodeID)
 					allVersions = append(allVersions, versions...)
+					byNode[r.NodeID] = versions
 				}
 				successCount++
 				log.Printf("[READ] Success from node=%s, total success=%d", r.NodeID, successCount)
 
-				if successCount >= qm.config.R {
+				if successCount >= required {
 					log.Printf("[READ] Read quorum satisfied, returning %d versions", len(allVersions))
-					return qm.deduplicateVersions(allVersions), nil
+					return done(), nil
 				}
 			} else {
 				failedNodes = append(failedNodes, r.NodeID)
@@ -268,12 +296,12 @@ func (qm *QuorumManager) ReadQuorum(ctx context.Context, nodes []string, key str
 
 		default:
 			if time.Now().After(deadline) {
-				log.Printf("[READ] Timeout reached, success=%d required=%d", successCount, qm.config.R)
-				if successCount >= qm.config.R {
-					return qm.deduplicateVersions(allVersions), nil
+				log.Printf("[READ] Timeout reached, success=%d required=%d", successCount, required)
+				if successCount >= required {
+					return done(), nil
 				}
 				return nil, fmt.Errorf("read quorum timeout: got %d successes, needed %d (failed nodes: %v)",
-					successCount, qm.config.R, failedNodes)
+					successCount, required, failedNodes)
 			}
 			time.Sleep(10 * time.Millisecond)
 		}
@@ -285,7 +313,7 @@ func (qm *QuorumManager) deduplicateVersions(versions []VersionedValue) []Versio
 	seen := make(map[string]VersionedValue)
 
 	for _, v := range versions {
-		key := v.Value + "|" + v.VectorClock + "|" + v.CreatedAt
+		key := v.Value + "|" + v.VectorClock + "|" + v.CreatedAt + "|" + strconv.FormatBool(v.Tombstone)
 		if existing, exists := seen[key]; !exists {
 			seen[key] = v
 		} else {
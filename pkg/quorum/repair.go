@@ -0,0 +1,174 @@
+package quorum
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rupeshx80/consistent-hashing/pkg/crdt"
+	"github.com/rupeshx80/consistent-hashing/pkg/model"
+	"github.com/rupeshx80/consistent-hashing/pkg/vclock"
+)
+
+// RepairHook is told about every version read repair wrote to a node that was
+// missing it, a node answering /get with it afterwards is a change readers can see
+type RepairHook func(key string, v VersionedValue)
+
+// SetRepairHook installs fn, call it before the manager serves reads
+func (qm *QuorumManager) SetRepairHook(fn RepairHook) {
+	qm.onRepair = fn
+}
+
+//one write seen by two nodes, CreatedAt is stamped per node and left out
+func versionIdentity(v VersionedValue) string {
+	return v.VectorClock + "|" + v.Value + "|" + strconv.FormatBool(v.Tombstone)
+}
+
+// repair writes the current versions of key to every node that answered the read
+// without them, CRDT siblings are merged first. It runs in the background, the
+// read already has its answer
+func (qm *QuorumManager) repair(key string, byNode map[string][]VersionedValue) {
+	seen := make(map[string]bool)
+	var all []VersionedValue
+	for _, versions := range byNode {
+		for _, v := range versions {
+			if id := versionIdentity(v); !seen[id] {
+				seen[id] = true
+				all = append(all, v)
+			}
+		}
+	}
+
+	clocks := make([]string, len(all))
+	for i, v := range all {
+		clocks[i] = v.VectorClock
+	}
+	same := func(a, b int) bool {
+		return all[a].Value == all[b].Value && all[a].Tombstone == all[b].Tombstone
+	}
+
+	now := time.Now()
+	var current []VersionedValue
+	for i, v := range all {
+		if vclock.Dominated(clocks, i, same) {
+			continue
+		}
+		//an expired version is purged anyway, pushing it would only bring it back for a moment
+		if expiresAt, err := model.ParseExpiry(v.ExpiresAt); err == nil && model.IsExpired(expiresAt, now) {
+			continue
+		}
+		current = append(current, v)
+	}
+	current = mergeCRDTs(current)
+
+	missing := make(map[string][]VersionedValue)
+	for node, versions := range byNode {
+		held := make(map[string]bool, len(versions))
+		for _, v := range versions {
+			held[versionIdentity(v)] = true
+		}
+		for _, v := range current {
+			if !held[versionIdentity(v)] {
+				missing[node] = append(missing[node], v)
+			}
+		}
+	}
+	if len(missing) == 0 {
+		return
+	}
+
+	go func() {
+		repaired := make(map[string]VersionedValue)
+		for node, versions := range missing {
+			for _, v := range versions {
+				if err := qm.writeVersion(node, key, v); err != nil {
+					log.Printf("[REPAIR] Failed to repair key='%s' on node=%s: %v", key, node, err)
+					continue
+				}
+				log.Printf("[REPAIR] Wrote key='%s' vc='%s' to stale node=%s", key, v.VectorClock, node)
+				repaired[versionIdentity(v)] = v
+			}
+		}
+
+		if qm.onRepair == nil {
+			return
+		}
+		for _, v := range repaired {
+			qm.onRepair(key, v)
+		}
+	}()
+}
+
+// mergeCRDTs turns concurrent CRDT siblings into one version whose clock covers
+// all of them, repair then spreads the merge and the nodes drop the siblings it
+// supersedes. Plain values and tombstones are left alone, only a client resolves those
+func mergeCRDTs(current []VersionedValue) []VersionedValue {
+	if len(current) < 2 {
+		return current
+	}
+
+	values := make([]string, 0, len(current))
+	clock := map[string]int{}
+	var expiresAt *time.Time
+	expires := true
+	for _, v := range current {
+		if v.Tombstone {
+			return current
+		}
+		values = append(values, v.Value)
+		vclock.Merge(clock, vclock.Parse(v.VectorClock))
+
+		//the merge lives as long as its longest lived sibling
+		t, err := model.ParseExpiry(v.ExpiresAt)
+		if err != nil || t == nil {
+			expires = false
+		} else if expiresAt == nil || t.After(*expiresAt) {
+			expiresAt = t
+		}
+	}
+
+	merged, ok, err := crdt.MergeValues(values)
+	if !ok || err != nil {
+		return current
+	}
+	encoded, err := crdt.Encode(merged)
+	if err != nil {
+		return current
+	}
+	if !expires {
+		expiresAt = nil
+	}
+	return []VersionedValue{{
+		Value:       encoded,
+		VectorClock: vclock.Serialize(clock),
+		ExpiresAt:   model.FormatExpiry(expiresAt),
+	}}
+}
+
+func (qm *QuorumManager) writeVersion(node, key string, v VersionedValue) error {
+	payload, err := json.Marshal(map[string]interface{}{
+		"key":         key,
+		"value":       v.Value,
+		"vectorClock": v.VectorClock,
+		"expiresAt":   v.ExpiresAt,
+		"tombstone":   v.Tombstone,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	resp, err := qm.httpClient.Post("http://127.0.0.1"+node+"/set", "application/json", bytes.NewBuffer(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status=%d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package replication
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SourceHeader marks a /set as a replicated write, its value is the sending site.
+// Such a write keeps its vector clock instead of getting a new one
+const SourceHeader = "X-Replication-Source"
+
+// SecretHeader carries the secret the sites share, without it anyone could send
+// a tombstone or an arbitrary clock through the replicated path
+const SecretHeader = "X-Replication-Secret"
+
+const cursorFile = "cursor"
+
+type Config struct {
+	Peer        string //base url of the peer cluster's coordinator, empty disables replication
+	Site        string //name of this cluster, the peer records it as the origin of what it receives
+	Secret      string //shared by both sites, empty refuses every replicated write
+	Dir         string
+	BaseBackoff time.Duration //doubled after every failed attempt
+	MaxBackoff  time.Duration
+	Timeout     time.Duration
+}
+
+// ConfigFromEnv reads REPLICATION_PEER, REPLICATION_SITE (the hostname),
+// REPLICATION_SECRET, REPLICATION_DIR (data/replication) and REPLICATION_TIMEOUT (10s).
+// The receiving site needs REPLICATION_SECRET even when it has no peer of its own
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Peer:        strings.TrimRight(os.Getenv("REPLICATION_PEER"), "/"),
+		Site:        os.Getenv("REPLICATION_SITE"),
+		Secret:      os.Getenv("REPLICATION_SECRET"),
+		Dir:         "data/replication",
+		BaseBackoff: time.Second,
+		MaxBackoff:  time.Minute,
+		Timeout:     10 * time.Second,
+	}
+
+	if cfg.Site == "" {
+		cfg.Site, _ = os.Hostname()
+	}
+	if v := os.Getenv("REPLICATION_DIR"); v != "" {
+		cfg.Dir = v
+	}
+	if v := os.Getenv("REPLICATION_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Printf("[REPLICATION] Ignoring invalid REPLICATION_TIMEOUT=%s", v)
+		} else {
+			cfg.Timeout = d
+		}
+	}
+	return cfg
+}
+
+func (c Config) Enabled() bool {
+	return c.Peer != ""
+}
+
+// Authenticate reports whether secret is the one the sites share, in constant time
+func (c Config) Authenticate(secret string) bool {
+	if c.Secret == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(secret), []byte(c.Secret)) == 1
+}
+
+//the offset of the last change the peer accepted, 0 when nothing was shipped yet
+func loadCursor(dir string) (uint64, error) {
+	data, err := os.ReadFile(filepath.Join(dir, cursorFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read replication cursor: %w", err)
+	}
+
+	offset, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("corrupt replication cursor: %w", err)
+	}
+	return offset, nil
+}
+
+func saveCursor(dir string, offset uint64) error {
+	path := filepath.Join(dir, cursorFile)
+	tmp := path + ".tmp"
+
+	if err := os.WriteFile(tmp, []byte(strconv.FormatUint(offset, 10)+"\n"), 0o644); err != nil {
+		return fmt.Errorf("failed to write replication cursor: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to install replication cursor: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package replication
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rupeshx80/consistent-hashing/pkg/changelog"
+)
+
+const shipBatch = 100
+
+// ErrRejected is a change the peer refused outright, retrying it would never succeed
+var ErrRejected = errors.New("peer rejected the change")
+
+// Status is what /replication/status reports
+type Status struct {
+	Peer      string    `json:"peer"`
+	Site      string    `json:"site"`
+	Shipped   uint64    `json:"shipped"` //offset of the last change the peer accepted
+	Last      uint64    `json:"last"`    //newest offset in the local change log
+	Lag       uint64    `json:"lag"`
+	Skipped   uint64    `json:"skipped"` //changes dropped because the peer rejected them or retention overtook us
+	LastError string    `json:"lastError,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt,omitempty"`
+}
+
+// Replicator ships the coordinator's own writes and deletes to a peer cluster in
+// change log order. Changes that arrived from the peer are not sent back and
+// lwt compare-and-sets stay local, they have no vector clock to merge
+type Replicator struct {
+	cfg     Config
+	changes *changelog.Log
+	client  *http.Client
+
+	mu     sync.Mutex
+	status Status
+}
+
+func NewReplicator(cfg Config, changes *changelog.Log) (*Replicator, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create replication dir: %w", err)
+	}
+	cursor, err := loadCursor(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Replicator{
+		cfg:     cfg,
+		changes: changes,
+		client:  &http.Client{Timeout: cfg.Timeout},
+		status:  Status{Peer: cfg.Peer, Site: cfg.Site, Shipped: cursor},
+	}, nil
+}
+
+func (r *Replicator) Start() {
+	log.Printf("[REPLICATION] Shipping changes after offset %d from site '%s' to %s", r.Cursor(), r.cfg.Site, r.cfg.Peer)
+	go r.run()
+}
+
+func (r *Replicator) Cursor() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status.Shipped
+}
+
+func (r *Replicator) Status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := r.status
+	s.Last = r.changes.Last()
+	if s.Last > s.Shipped {
+		s.Lag = s.Last - s.Shipped
+	}
+	return s
+}
+
+func (r *Replicator) run() {
+	for {
+		cursor := r.Cursor()
+		notify := r.changes.Wait()
+
+		batch, next, err := r.changes.Read(cursor, "", shipBatch)
+		if errors.Is(err, changelog.ErrTruncated) {
+			oldest := r.changes.Oldest()
+			log.Printf("[REPLICATION] Fell behind retention, changes %d to %d never reached the peer", cursor+1, oldest-1)
+			r.skip(oldest-1-cursor, err)
+			r.advance(oldest - 1)
+			continue
+		}
+
+		for _, c := range batch {
+			//a repair moves a version that was already recorded when it was written
+			if c.Origin != "" || c.Op == changelog.OpCAS || c.Op == changelog.OpRepair {
+				continue
+			}
+			r.ship(c)
+			r.advance(c.Offset)
+		}
+		//changes that stay local still move the cursor
+		if next > r.Cursor() {
+			r.advance(next)
+		}
+		if len(batch) == shipBatch {
+			continue
+		}
+		<-notify
+	}
+}
+
+// ship retries until the peer takes the change, only a rejected change is given up
+func (r *Replicator) ship(c changelog.Change) {
+	for attempt := 1; ; attempt++ {
+		err := r.send(c)
+		if err == nil {
+			return
+		}
+		if errors.Is(err, ErrRejected) {
+			log.Printf("[REPLICATION] Skipping offset %d key='%s': %v", c.Offset, c.Key, err)
+			r.skip(1, err)
+			return
+		}
+
+		log.Printf("[REPLICATION] Attempt %d for offset %d key='%s' failed: %v", attempt, c.Offset, c.Key, err)
+		r.setError(err)
+		time.Sleep(r.backoff(attempt))
+	}
+}
+
+// send is one PUT of the version to the peer's /set, the clock travels as it is
+func (r *Replicator) send(c changelog.Change) error {
+	body := map[string]string{
+		"key":         c.Key,
+		"value":       c.Value,
+		"vectorClock": c.VectorClock,
+		"createdAt":   c.Timestamp.Format(time.RFC3339Nano),
+	}
+	if c.ExpiresAt != "" {
+		body["expiresAt"] = c.ExpiresAt
+	}
+	if c.Op == changelog.OpDelete {
+		body["tombstone"] = "true"
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, r.cfg.Peer+"/set", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SourceHeader, r.cfg.Site)
+	req.Header.Set(SecretHeader, r.cfg.Secret)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+		return nil
+	case resp.StatusCode == http.StatusBadRequest:
+		return fmt.Errorf("%w: %s", ErrRejected, bytes.TrimSpace(msg))
+	}
+	return fmt.Errorf("peer answered %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+}
+
+// advance saves the cursor, on a crash before the save the change is shipped
+// again and the peer stores nothing new since it already has that version
+func (r *Replicator) advance(offset uint64) {
+	r.mu.Lock()
+	r.status.Shipped = offset
+	r.status.UpdatedAt = time.Now().UTC()
+	r.mu.Unlock()
+
+	if err := saveCursor(r.cfg.Dir, offset); err != nil {
+		log.Printf("[REPLICATION] %v", err)
+	}
+}
+
+func (r *Replicator) skip(n uint64, cause error) {
+	r.mu.Lock()
+	r.status.Skipped += n
+	r.status.LastError = cause.Error()
+	r.mu.Unlock()
+}
+
+func (r *Replicator) setError(err error) {
+	r.mu.Lock()
+	r.status.LastError = err.Error()
+	r.mu.Unlock()
+}
+
+//exponential with up to 20% jitter, capped at MaxBackoff
+func (r *Replicator) backoff(attempt int) time.Duration {
+	wait := r.cfg.BaseBackoff << (attempt - 1)
+	if wait <= 0 || wait > r.cfg.MaxBackoff {
+		wait = r.cfg.MaxBackoff
+	}
+	return wait + time.Duration(rand.Int63n(int64(wait)/5+1))
+}
@@ -0,0 +1,724 @@
+package storage
+
+import (
+	"bufio"
+	"container/heap"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rupeshx80/consistent-hashing/pkg/model"
+)
+
+const (
+	lsmWALFile         = "lsm.wal"
+	memtableLimit      = 4 << 20 //bytes buffered before the memtable is flushed to a segment
+	compactionTrigger  = 4       //segments on disk before they are merged into one
+	compactionInterval = time.Minute
+)
+
+var errStopScan = errors.New("stop scan")
+
+// LSMStorage is an embedded log structured engine, writes go to a wal and an
+// in memory table that is flushed into immutable sorted segments, a background
+// compaction merges segments and drops deleted versions
+type LSMStorage struct {
+	dir string
+
+	mu       sync.RWMutex
+	wal      *os.File
+	memtable map[string][]lsmRecord //records per key in sequence order
+	memBytes int
+	segments []*segment //oldest first
+	seq      uint64
+	nextID   uint64
+
+	compactMu sync.Mutex //one compaction at a time
+	compactCh chan struct{}
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// OpenLSM recovers the segments in dir and replays the wal into the memtable
+func OpenLSM(dir string) (*LSMStorage, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create lsm dir: %w", err)
+	}
+
+	s := &LSMStorage{
+		dir:       dir,
+		memtable:  make(map[string][]lsmRecord),
+		nextID:    1,
+		compactCh: make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+
+	if err := s.loadSegments(); err != nil {
+		s.closeSegments()
+		return nil, err
+	}
+	if err := s.replayWAL(); err != nil {
+		s.closeSegments()
+		return nil, err
+	}
+
+	s.wg.Add(1)
+	go s.compactor()
+
+	log.Printf("[LSM] Opened %s with %d segments and %d keys in the memtable, seq=%d", dir, len(s.segments), len(s.memtable), s.seq)
+	return s, nil
+}
+
+func (s *LSMStorage) loadSegments() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("failed to list lsm dir: %w", err)
+	}
+
+	byID := make(map[uint64]*segment)
+	for _, e := range entries {
+		//half written segment from a crashed flush or compaction
+		if strings.HasSuffix(e.Name(), ".tmp") {
+			os.Remove(filepath.Join(s.dir, e.Name()))
+			continue
+		}
+
+		id, ok := segmentID(e.Name())
+		if !ok {
+			continue
+		}
+		seg, err := openSegment(filepath.Join(s.dir, e.Name()))
+		if err != nil {
+			return err
+		}
+		byID[id] = seg
+		s.segments = append(s.segments, seg)
+	}
+
+	//a compaction that crashed before deleting its inputs leaves them behind
+	for _, seg := range s.segments {
+		for _, id := range seg.footer.Replaces {
+			if old, ok := byID[id]; ok {
+				log.Printf("[LSM] Removing segment %d already merged into %d", id, seg.footer.ID)
+				old.obsolete.Store(true)
+				delete(byID, id)
+			}
+		}
+	}
+
+	live := s.segments[:0]
+	for _, seg := range s.segments {
+		if seg.obsolete.Load() {
+			seg.release()
+			continue
+		}
+		live = append(live, seg)
+		if seg.footer.MaxSeq > s.seq {
+			s.seq = seg.footer.MaxSeq
+		}
+		if seg.footer.ID >= s.nextID {
+			s.nextID = seg.footer.ID + 1
+		}
+	}
+	s.segments = live
+
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i].footer.ID < s.segments[j].footer.ID })
+	return nil
+}
+
+// replayWAL loads the records that were not flushed yet, a torn record at the
+// tail is cut off the same way the cache wal does it
+func (s *LSMStorage) replayWAL() error {
+	f, err := os.OpenFile(filepath.Join(s.dir, lsmWALFile), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open lsm wal: %w", err)
+	}
+
+	flushed := s.seq
+	var goodOffset int64
+	replayed := 0
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			if line != "" {
+				log.Printf("[LSM] Dropping torn wal record at offset %d", goodOffset)
+			}
+			break
+		}
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("failed to read lsm wal: %w", err)
+		}
+
+		rec, ok := decodeRecord(line)
+		if !ok {
+			log.Printf("[LSM] Corrupt wal record at offset %d, ignoring the rest of the log", goodOffset)
+			break
+		}
+		goodOffset += int64(len(line))
+
+		//a crash between a flush and the wal reset leaves records that are
+		//already in a segment, replaying them could undo a later compaction
+		if rec.Seq <= flushed {
+			continue
+		}
+		s.applyLocked(rec)
+		if rec.Seq > s.seq {
+			s.seq = rec.Seq
+		}
+		replayed++
+	}
+
+	if err := f.Truncate(goodOffset); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to truncate lsm wal: %w", err)
+	}
+	if _, err := f.Seek(goodOffset, io.SeekStart); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to seek lsm wal: %w", err)
+	}
+	s.wal = f
+
+	if replayed > 0 {
+		log.Printf("[LSM] Replayed %d wal records", replayed)
+	}
+	return nil
+}
+
+func (s *LSMStorage) PutVersion(kv model.KeyValue) error {
+	if kv.CreatedAt.IsZero() {
+		kv.CreatedAt = time.Now()
+	}
+
+	return s.write(lsmRecord{
+		Op:          opPut,
+		Key:         kv.Key,
+		Value:       kv.Value,
+		VectorClock: kv.VectorClock,
+		CreatedAt:   kv.CreatedAt,
+		ExpiresAt:   kv.ExpiresAt,
+		Tombstone:   kv.Tombstone,
+	})
+}
+
+func (s *LSMStorage) Delete(key string) error {
+	return s.write(lsmRecord{Op: opDelete, Key: key})
+}
+
+func (s *LSMStorage) DeleteVersions(key string, ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	drop := make([]uint64, 0, len(ids))
+	for _, id := range ids {
+		drop = append(drop, uint64(id))
+	}
+	return s.write(lsmRecord{Op: opDelete, Key: key, Drop: drop})
+}
+
+// write logs the record before it becomes visible, same rule as the cache nodes
+func (s *LSMStorage) write(rec lsmRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.wal == nil {
+		return fmt.Errorf("lsm storage is closed")
+	}
+
+	rec.Seq = s.seq + 1
+	line, err := encodeRecord(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := s.wal.Write(line); err != nil {
+		return fmt.Errorf("failed to append lsm wal: %w", err)
+	}
+	if err := s.wal.Sync(); err != nil {
+		return fmt.Errorf("failed to sync lsm wal: %w", err)
+	}
+
+	s.seq = rec.Seq
+	s.applyLocked(rec)
+
+	if s.memBytes >= memtableLimit {
+		if err := s.flushLocked(); err != nil {
+			//the records are safe in the wal, the next write retries the flush
+			log.Printf("[LSM] Flush failed: %v", err)
+		}
+	}
+	return nil
+}
+
+func (s *LSMStorage) applyLocked(rec lsmRecord) {
+	s.memtable[rec.Key] = append(s.memtable[rec.Key], rec)
+	s.memBytes += rec.size()
+}
+
+// flushLocked writes the memtable out as a new segment and resets the wal
+func (s *LSMStorage) flushLocked() error {
+	if len(s.memtable) == 0 {
+		return nil
+	}
+
+	records := sortedRecords(s.memtable, "", "")
+	w, err := newSegmentWriter(s.dir, s.nextID, len(s.memtable))
+	if err != nil {
+		return err
+	}
+	for _, rec := range records {
+		if err := w.Add(rec); err != nil {
+			w.Abort()
+			return err
+		}
+	}
+	seg, err := w.Finish(nil)
+	if err != nil {
+		return err
+	}
+
+	s.nextID++
+	s.segments = append(s.segments, seg)
+	s.memtable = make(map[string][]lsmRecord)
+	s.memBytes = 0
+
+	if err := s.wal.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate lsm wal: %w", err)
+	}
+	if _, err := s.wal.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek lsm wal: %w", err)
+	}
+
+	log.Printf("[LSM] Flushed %d records to segment %d", len(records), seg.footer.ID)
+
+	if len(s.segments) >= compactionTrigger {
+		select {
+		case s.compactCh <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (s *LSMStorage) GetAllVersions(key string) ([]model.KeyValue, error) {
+	s.mu.RLock()
+	var records []lsmRecord
+	for _, seg := range s.segments {
+		found, err := seg.get(key)
+		if err != nil {
+			s.mu.RUnlock()
+			return nil, err
+		}
+		records = append(records, found...)
+	}
+	records = append(records, s.memtable[key]...)
+	s.mu.RUnlock()
+
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Seq < records[j].Seq })
+
+	versions := resolveRecords(records)
+	if len(versions) == 0 {
+		return nil, ErrNotFound
+	}
+	return versions, nil
+}
+
+func (s *LSMStorage) ScanKeys(prefix, after string, limit int) ([]string, error) {
+	start := prefix
+	if after != "" && after >= start {
+		start = after + "\x00"
+	}
+	end := ""
+	if prefix != "" {
+		end = prefixEnd(prefix)
+	}
+
+	var out []string
+	err := s.scan(start, end, func(key string, versions []model.KeyValue) error {
+		out = append(out, key)
+		if limit > 0 && len(out) == limit {
+			return errStopScan
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopScan) {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *LSMStorage) IterateRange(start, end string, fn func(kv model.KeyValue) error) error {
+	return s.scan(start, end, func(key string, versions []model.KeyValue) error {
+		for _, kv := range versions {
+			if err := fn(kv); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *LSMStorage) GetVersionsSince(since time.Time) ([]model.KeyValue, error) {
+	var out []model.KeyValue
+	err := s.scan("", "", func(key string, versions []model.KeyValue) error {
+		for _, kv := range versions {
+			if kv.CreatedAt.After(since) {
+				out = append(out, kv)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out, nil
+}
+
+// PurgeExpired writes a delete naming the expired versions and the ones they
+// superseded, the space comes back when compaction rewrites the segments
+func (s *LSMStorage) PurgeExpired(now time.Time) (int64, error) {
+	drops := make(map[string][]uint64)
+	err := s.scan("", "", func(key string, versions []model.KeyValue) error {
+		for _, id := range expiredIDs(versions, now) {
+			drops[key] = append(drops[key], uint64(id))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var purged int64
+	for key, ids := range drops {
+		if err := s.write(lsmRecord{Op: opDelete, Key: key, Drop: ids}); err != nil {
+			return purged, err
+		}
+		purged += int64(len(ids))
+	}
+	return purged, nil
+}
+
+// scan calls fn with the live versions of every key in [start, end) in key order,
+// it works on a copy of the memtable and pinned segments so fn may write back
+func (s *LSMStorage) scan(start, end string, fn func(key string, versions []model.KeyValue) error) error {
+	s.mu.RLock()
+	sources := []recordIterator{&sliceIterator{records: sortedRecords(s.memtable, start, end)}}
+	var pinned []*segment
+	for _, seg := range s.segments {
+		if seg.overlaps(start, end) {
+			seg.acquire()
+			pinned = append(pinned, seg)
+			sources = append(sources, seg.iterator(start))
+		}
+	}
+	s.mu.RUnlock()
+
+	defer func() {
+		for _, seg := range pinned {
+			seg.release()
+		}
+	}()
+
+	return mergeRecords(sources, end, func(key string, records []lsmRecord) error {
+		versions := resolveRecords(records)
+		if len(versions) == 0 {
+			return nil
+		}
+		return fn(key, versions)
+	})
+}
+
+func (s *LSMStorage) compactor() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(compactionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.compactCh:
+		case <-ticker.C:
+		}
+
+		if err := s.Compact(); err != nil {
+			log.Printf("[LSM] Compaction failed: %v", err)
+		}
+	}
+}
+
+// Compact merges every segment on disk into one, versions hidden by a delete
+// and the deletes themselves are dropped since nothing older is left to hide
+func (s *LSMStorage) Compact() error {
+	s.compactMu.Lock()
+	defer s.compactMu.Unlock()
+
+	s.mu.Lock()
+	if len(s.segments) < 2 {
+		s.mu.Unlock()
+		return nil
+	}
+	inputs := append([]*segment(nil), s.segments...)
+	for _, seg := range inputs {
+		seg.acquire()
+	}
+	id := s.nextID
+	s.nextID++
+	s.mu.Unlock()
+
+	defer func() {
+		for _, seg := range inputs {
+			seg.release()
+		}
+	}()
+
+	expected := 0
+	var maxSeq uint64
+	replaces := make([]uint64, 0, len(inputs))
+	sources := make([]recordIterator, 0, len(inputs))
+	for _, seg := range inputs {
+		expected += seg.footer.Records
+		if seg.footer.MaxSeq > maxSeq {
+			maxSeq = seg.footer.MaxSeq
+		}
+		replaces = append(replaces, seg.footer.ID)
+		sources = append(sources, seg.iterator(""))
+	}
+
+	w, err := newSegmentWriter(s.dir, id, expected)
+	if err != nil {
+		return err
+	}
+	//dropped deletes may carry the highest sequence, recovery still has to see it
+	w.footer.MaxSeq = maxSeq
+
+	kept := 0
+	err = mergeRecords(sources, "", func(key string, records []lsmRecord) error {
+		for _, kv := range resolveRecords(records) {
+			rec := lsmRecord{
+				Seq:         uint64(kv.ID),
+				Op:          opPut,
+				Key:         kv.Key,
+				Value:       kv.Value,
+				VectorClock: kv.VectorClock,
+				CreatedAt:   kv.CreatedAt,
+				ExpiresAt:   kv.ExpiresAt,
+				Tombstone:   kv.Tombstone,
+			}
+			if err := w.Add(rec); err != nil {
+				return err
+			}
+			kept++
+		}
+		return nil
+	})
+	if err != nil {
+		w.Abort()
+		return err
+	}
+
+	merged, err := w.Finish(replaces)
+	if err != nil {
+		return err
+	}
+
+	//segments flushed while we were merging are newer, they stay after the merged one
+	s.mu.Lock()
+	replaced := make(map[*segment]bool, len(inputs))
+	for _, seg := range inputs {
+		replaced[seg] = true
+	}
+	live := []*segment{merged}
+	for _, seg := range s.segments {
+		if !replaced[seg] {
+			live = append(live, seg)
+		}
+	}
+	s.segments = live
+	s.mu.Unlock()
+
+	for _, seg := range inputs {
+		seg.obsolete.Store(true)
+		seg.release() //the reference the engine held
+	}
+
+	log.Printf("[LSM] Compacted %d segments into segment %d, kept %d of %d records", len(inputs), id, kept, expected)
+	return nil
+}
+
+func (s *LSMStorage) Close() error {
+	close(s.done)
+	s.wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closeSegments()
+	if s.wal == nil {
+		return nil
+	}
+	err := s.wal.Close()
+	s.wal = nil
+	return err
+}
+
+func (s *LSMStorage) closeSegments() {
+	for _, seg := range s.segments {
+		seg.release()
+	}
+	s.segments = nil
+}
+
+// resolveRecords replays one key's records in sequence order and returns the
+// versions that survive, oldest first like every other engine
+func resolveRecords(records []lsmRecord) []model.KeyValue {
+	var live []lsmRecord
+	var lastSeq uint64
+	for i, rec := range records {
+		if i > 0 && rec.Seq == lastSeq {
+			continue
+		}
+		lastSeq = rec.Seq
+
+		if rec.Op == opPut {
+			live = append(live, rec)
+			continue
+		}
+		if len(rec.Drop) == 0 {
+			live = nil
+			continue
+		}
+
+		dropped := make(map[uint64]bool, len(rec.Drop))
+		for _, seq := range rec.Drop {
+			dropped[seq] = true
+		}
+		kept := live[:0]
+		for _, v := range live {
+			if !dropped[v.Seq] {
+				kept = append(kept, v)
+			}
+		}
+		live = kept
+	}
+
+	versions := make([]model.KeyValue, 0, len(live))
+	for _, rec := range live {
+		versions = append(versions, rec.keyValue())
+	}
+	sort.SliceStable(versions, func(i, j int) bool { return versions[i].CreatedAt.Before(versions[j].CreatedAt) })
+	return versions
+}
+
+//copy of the memtable records in [start, end) sorted by key and sequence
+func sortedRecords(memtable map[string][]lsmRecord, start, end string) []lsmRecord {
+	keys := make([]string, 0, len(memtable))
+	for k := range memtable {
+		if k >= start && (end == "" || k < end) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var out []lsmRecord
+	for _, k := range keys {
+		out = append(out, memtable[k]...)
+	}
+	return out
+}
+
+type recordIterator interface {
+	next() (lsmRecord, bool, error)
+}
+
+type sliceIterator struct {
+	records []lsmRecord
+	pos     int
+}
+
+func (it *sliceIterator) next() (lsmRecord, bool, error) {
+	if it.pos >= len(it.records) {
+		return lsmRecord{}, false, nil
+	}
+	it.pos++
+	return it.records[it.pos-1], true, nil
+}
+
+type mergeItem struct {
+	rec    lsmRecord
+	source int
+}
+
+type mergeHeap []mergeItem
+
+func (h mergeHeap) Len() int { return len(h) }
+func (h mergeHeap) Less(i, j int) bool {
+	if h[i].rec.Key != h[j].rec.Key {
+		return h[i].rec.Key < h[j].rec.Key
+	}
+	return h[i].rec.Seq < h[j].rec.Seq
+}
+func (h mergeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x interface{}) { *h = append(*h, x.(mergeItem)) }
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// mergeRecords k-way merges sorted sources and hands fn every record of one
+// key at a time in sequence order, stopping at end when it is set
+func mergeRecords(sources []recordIterator, end string, fn func(key string, records []lsmRecord) error) error {
+	h := &mergeHeap{}
+	for i, src := range sources {
+		rec, ok, err := src.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Push(h, mergeItem{rec: rec, source: i})
+		}
+	}
+
+	var key string
+	var group []lsmRecord
+	for h.Len() > 0 {
+		item := heap.Pop(h).(mergeItem)
+		if end != "" && item.rec.Key >= end {
+			break
+		}
+
+		if len(group) > 0 && item.rec.Key != key {
+			if err := fn(key, group); err != nil {
+				return err
+			}
+			group = nil
+		}
+		key = item.rec.Key
+		group = append(group, item.rec)
+
+		rec, ok, err := sources[item.source].next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Push(h, mergeItem{rec: rec, source: item.source})
+		}
+	}
+
+	if len(group) > 0 {
+		return fn(key, group)
+	}
+	return nil
+}
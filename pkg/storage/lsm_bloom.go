@@ -0,0 +1,56 @@
+package storage
+
+import "hash/fnv"
+
+const (
+	bloomBitsPerKey = 10
+	bloomHashes     = 7 //~1% false positives at 10 bits per key
+)
+
+// bloomFilter lets a point read skip segments that can't hold the key
+type bloomFilter struct {
+	Bits []uint64 `json:"bits"`
+	K    int      `json:"k"`
+}
+
+func newBloomFilter(expectedKeys int) *bloomFilter {
+	words := (expectedKeys*bloomBitsPerKey + 63) / 64
+	if words < 1 {
+		words = 1
+	}
+	return &bloomFilter{Bits: make([]uint64, words), K: bloomHashes}
+}
+
+func (b *bloomFilter) positions(key string) []uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+
+	//double hashing, same trick as the cache's count-min sketch
+	lo, hi := sum&0xffffffff, (sum>>32)|1
+	size := uint64(len(b.Bits) * 64)
+
+	out := make([]uint64, b.K)
+	for i := range out {
+		out[i] = (lo + uint64(i)*hi) % size
+	}
+	return out
+}
+
+func (b *bloomFilter) Add(key string) {
+	for _, pos := range b.positions(key) {
+		b.Bits[pos/64] |= 1 << (pos % 64)
+	}
+}
+
+func (b *bloomFilter) MayContain(key string) bool {
+	if len(b.Bits) == 0 {
+		return true
+	}
+	for _, pos := range b.positions(key) {
+		if b.Bits[pos/64]&(1<<(pos%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
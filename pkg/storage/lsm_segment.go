@@ -0,0 +1,378 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/rupeshx80/consistent-hashing/pkg/model"
+	"gorm.io/gorm"
+)
+
+const (
+	segmentIndexInterval = 32 //records between two sparse index entries
+	segmentTrailerSize   = 17 //"%016x\n" offset of the footer
+)
+
+const (
+	opPut    = "put"
+	opDelete = "delete"
+)
+
+// lsmRecord is one entry of the wal and of a segment, a delete hides every
+// older version of its key unless Drop names the versions it removes
+type lsmRecord struct {
+	Seq         uint64     `json:"seq"`
+	Op          string     `json:"op"`
+	Key         string     `json:"key"`
+	Value       string     `json:"value,omitempty"`
+	VectorClock string     `json:"vectorClock,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt,omitempty"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+	Tombstone   bool       `json:"tombstone,omitempty"`
+	Drop        []uint64   `json:"drop,omitempty"`
+}
+
+func (r lsmRecord) keyValue() model.KeyValue {
+	return model.KeyValue{
+		Model:       gorm.Model{ID: uint(r.Seq), UpdatedAt: r.CreatedAt},
+		Key:         r.Key,
+		Value:       r.Value,
+		VectorClock: r.VectorClock,
+		CreatedAt:   r.CreatedAt,
+		ExpiresAt:   r.ExpiresAt,
+		Tombstone:   r.Tombstone,
+	}
+}
+
+func (r lsmRecord) size() int {
+	return len(r.Key) + len(r.Value) + len(r.VectorClock) + 8*len(r.Drop) + 64
+}
+
+//records are framed like the cache wal: crc32 of the json, a space and the json
+func encodeRecord(rec lsmRecord) ([]byte, error) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal lsm record: %w", err)
+	}
+	return []byte(fmt.Sprintf("%08x %s\n", crc32.ChecksumIEEE(data), data)), nil
+}
+
+func decodeRecord(line string) (lsmRecord, bool) {
+	var rec lsmRecord
+
+	checksum, data, ok := strings.Cut(strings.TrimSuffix(line, "\n"), " ")
+	if !ok {
+		return rec, false
+	}
+	if fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(data))) != checksum {
+		return rec, false
+	}
+	if err := json.Unmarshal([]byte(data), &rec); err != nil {
+		return rec, false
+	}
+	return rec, true
+}
+
+type segmentIndexEntry struct {
+	Key    string `json:"key"`
+	Offset int64  `json:"offset"`
+}
+
+// segmentFooter sits after the records, Replaces lists the segments a compaction
+// merged into this one so a crash before they were deleted can't resurrect them
+type segmentFooter struct {
+	ID       uint64              `json:"id"`
+	Records  int                 `json:"records"`
+	MinKey   string              `json:"minKey"`
+	MaxKey   string              `json:"maxKey"`
+	MaxSeq   uint64              `json:"maxSeq"`
+	Replaces []uint64            `json:"replaces,omitempty"`
+	Index    []segmentIndexEntry `json:"index"`
+	Bloom    *bloomFilter        `json:"bloom"`
+}
+
+// segment is an immutable file of records sorted by key and then sequence,
+// it is shared by readers through a reference count and deleted once a
+// compaction replaced it and the last reader let go
+type segment struct {
+	path    string
+	file    *os.File
+	dataEnd int64
+	footer  segmentFooter
+
+	refs     int32
+	obsolete atomic.Bool
+}
+
+func segmentPath(dir string, id uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("seg-%06d.sst", id))
+}
+
+func segmentID(name string) (uint64, bool) {
+	if !strings.HasPrefix(name, "seg-") || !strings.HasSuffix(name, ".sst") {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, "seg-"), ".sst"), 10, 64)
+	return id, err == nil
+}
+
+func openSegment(path string) (*segment, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open segment: %w", err)
+	}
+
+	seg, err := readSegment(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("segment %s: %w", filepath.Base(path), err)
+	}
+	seg.path = path
+	return seg, nil
+}
+
+func readSegment(f *os.File) (*segment, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < segmentTrailerSize {
+		return nil, fmt.Errorf("file too short")
+	}
+
+	trailer := make([]byte, segmentTrailerSize)
+	if _, err := f.ReadAt(trailer, info.Size()-segmentTrailerSize); err != nil {
+		return nil, err
+	}
+	footerAt, err := strconv.ParseInt(strings.TrimSpace(string(trailer)), 16, 64)
+	if err != nil || footerAt < 0 || footerAt > info.Size()-segmentTrailerSize {
+		return nil, fmt.Errorf("corrupt trailer")
+	}
+
+	raw := make([]byte, info.Size()-segmentTrailerSize-footerAt)
+	if _, err := f.ReadAt(raw, footerAt); err != nil {
+		return nil, err
+	}
+
+	seg := &segment{file: f, dataEnd: footerAt, refs: 1}
+	if err := json.Unmarshal(raw, &seg.footer); err != nil {
+		return nil, fmt.Errorf("corrupt footer: %w", err)
+	}
+	return seg, nil
+}
+
+func (s *segment) acquire() {
+	atomic.AddInt32(&s.refs, 1)
+}
+
+func (s *segment) release() {
+	if atomic.AddInt32(&s.refs, -1) > 0 {
+		return
+	}
+	s.file.Close()
+	if s.obsolete.Load() {
+		os.Remove(s.path)
+	}
+}
+
+func (s *segment) overlaps(start, end string) bool {
+	if s.footer.Records == 0 {
+		return false
+	}
+	if s.footer.MaxKey < start {
+		return false
+	}
+	return end == "" || s.footer.MinKey < end
+}
+
+//offset of the last indexed key that is <= key, reading from there finds key if present
+func (s *segment) seek(key string) int64 {
+	i := sort.Search(len(s.footer.Index), func(i int) bool { return s.footer.Index[i].Key > key })
+	if i == 0 {
+		return 0
+	}
+	return s.footer.Index[i-1].Offset
+}
+
+func (s *segment) get(key string) ([]lsmRecord, error) {
+	if s.footer.Records == 0 || key < s.footer.MinKey || key > s.footer.MaxKey || !s.footer.Bloom.MayContain(key) {
+		return nil, nil
+	}
+
+	it := s.iterator(key)
+	var out []lsmRecord
+	for {
+		rec, ok, err := it.next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok || rec.Key > key {
+			return out, nil
+		}
+		if rec.Key == key {
+			out = append(out, rec)
+		}
+	}
+}
+
+func (s *segment) iterator(start string) *segmentIterator {
+	offset := s.seek(start)
+	return &segmentIterator{
+		seg:    s,
+		start:  start,
+		reader: bufio.NewReader(io.NewSectionReader(s.file, offset, s.dataEnd-offset)),
+	}
+}
+
+type segmentIterator struct {
+	seg    *segment
+	start  string
+	reader *bufio.Reader
+}
+
+func (it *segmentIterator) next() (lsmRecord, bool, error) {
+	for {
+		line, err := it.reader.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return lsmRecord{}, false, nil
+		}
+		if err != nil && err != io.EOF {
+			return lsmRecord{}, false, fmt.Errorf("failed to read segment %s: %w", filepath.Base(it.seg.path), err)
+		}
+
+		rec, ok := decodeRecord(line)
+		if !ok {
+			return lsmRecord{}, false, fmt.Errorf("corrupt record in segment %s", filepath.Base(it.seg.path))
+		}
+		if rec.Key < it.start {
+			continue
+		}
+		return rec, true, nil
+	}
+}
+
+// segmentWriter streams sorted records into a temp file and installs it
+// with a rename once the footer is synced
+type segmentWriter struct {
+	path   string
+	file   *os.File
+	writer *bufio.Writer
+	offset int64
+
+	footer     segmentFooter
+	lastKey    string
+	sinceIndex int
+}
+
+func newSegmentWriter(dir string, id uint64, expectedKeys int) (*segmentWriter, error) {
+	path := segmentPath(dir, id)
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create segment: %w", err)
+	}
+
+	return &segmentWriter{
+		path:   path,
+		file:   f,
+		writer: bufio.NewWriter(f),
+		footer: segmentFooter{ID: id, Bloom: newBloomFilter(expectedKeys)},
+	}, nil
+}
+
+func (w *segmentWriter) Add(rec lsmRecord) error {
+	line, err := encodeRecord(rec)
+	if err != nil {
+		return err
+	}
+
+	if w.footer.Records == 0 || rec.Key != w.lastKey {
+		if w.footer.Records == 0 {
+			w.footer.MinKey = rec.Key
+		}
+		if w.footer.Records == 0 || w.sinceIndex >= segmentIndexInterval {
+			w.footer.Index = append(w.footer.Index, segmentIndexEntry{Key: rec.Key, Offset: w.offset})
+			w.sinceIndex = 0
+		}
+		w.footer.Bloom.Add(rec.Key)
+		w.lastKey = rec.Key
+	}
+
+	if _, err := w.writer.Write(line); err != nil {
+		return fmt.Errorf("failed to write segment: %w", err)
+	}
+
+	w.offset += int64(len(line))
+	w.sinceIndex++
+	w.footer.Records++
+	w.footer.MaxKey = rec.Key
+	if rec.Seq > w.footer.MaxSeq {
+		w.footer.MaxSeq = rec.Seq
+	}
+	return nil
+}
+
+func (w *segmentWriter) Finish(replaces []uint64) (*segment, error) {
+	w.footer.Replaces = replaces
+
+	footer, err := json.Marshal(w.footer)
+	if err != nil {
+		w.Abort()
+		return nil, fmt.Errorf("failed to marshal segment footer: %w", err)
+	}
+
+	if _, err := w.writer.Write(footer); err != nil {
+		w.Abort()
+		return nil, fmt.Errorf("failed to write segment footer: %w", err)
+	}
+	if _, err := fmt.Fprintf(w.writer, "%016x\n", w.offset); err != nil {
+		w.Abort()
+		return nil, fmt.Errorf("failed to write segment trailer: %w", err)
+	}
+	if err := w.writer.Flush(); err != nil {
+		w.Abort()
+		return nil, fmt.Errorf("failed to flush segment: %w", err)
+	}
+	if err := w.file.Sync(); err != nil {
+		w.Abort()
+		return nil, fmt.Errorf("failed to sync segment: %w", err)
+	}
+	w.file.Close()
+
+	if err := os.Rename(w.path+".tmp", w.path); err != nil {
+		os.Remove(w.path + ".tmp")
+		return nil, fmt.Errorf("failed to install segment: %w", err)
+	}
+	//the rename has to be on disk before the wal is reset or merged segments are
+	//removed, otherwise a power loss can keep those and lose the segment
+	if err := syncDir(filepath.Dir(w.path)); err != nil {
+		return nil, err
+	}
+	return openSegment(w.path)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open dir for sync: %w", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync dir: %w", err)
+	}
+	return nil
+}
+
+func (w *segmentWriter) Abort() {
+	w.file.Close()
+	os.Remove(w.path + ".tmp")
+}
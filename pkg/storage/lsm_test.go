@@ -0,0 +1,269 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rupeshx80/consistent-hashing/pkg/model"
+)
+
+func openLSM(t *testing.T, dir string) *LSMStorage {
+	t.Helper()
+	s, err := OpenLSM(dir)
+	if err != nil {
+		t.Fatalf("open lsm: %v", err)
+	}
+	return s
+}
+
+func closeLSM(t *testing.T, s *LSMStorage) {
+	t.Helper()
+	if err := s.Close(); err != nil {
+		t.Fatalf("close lsm: %v", err)
+	}
+}
+
+func flushLSM(t *testing.T, s *LSMStorage) {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.flushLocked(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+}
+
+func mustValues(t *testing.T, s Storage, key string) []string {
+	t.Helper()
+	versions, err := s.GetAllVersions(key)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		t.Fatalf("get %s: %v", key, err)
+	}
+	return values(versions)
+}
+
+func segmentFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(dir, "seg-*.sst"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return matches
+}
+
+func appendToFile(t *testing.T, path, data string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLSMReplaysWALWithTornTail(t *testing.T) {
+	cases := map[string]string{
+		"partial line":   `0badc0de {"seq":3,"op":"put","key":"k","val`,
+		"bad checksum":   "00000000 {\"seq\":3,\"op\":\"put\",\"key\":\"k\",\"value\":\"lost\"}\n",
+		"garbage line":   "not a record\n",
+		"half a newline": "\n",
+	}
+
+	for name, tail := range cases {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			s := openLSM(t, dir)
+			put(t, s, model.KeyValue{Key: "k", Value: "a"})
+			put(t, s, model.KeyValue{Key: "k", Value: "b"})
+			closeLSM(t, s)
+
+			//a crash in the middle of a wal append
+			appendToFile(t, filepath.Join(dir, lsmWALFile), tail)
+
+			s = openLSM(t, dir)
+			if got := mustValues(t, s, "k"); !reflect.DeepEqual(got, []string{"a", "b"}) {
+				t.Fatalf("after torn tail = %v, want [a b]", got)
+			}
+
+			//the tail was cut off, so a new record isn't stuck behind it
+			put(t, s, model.KeyValue{Key: "k", Value: "c"})
+			closeLSM(t, s)
+
+			s = openLSM(t, dir)
+			defer closeLSM(t, s)
+			if got := mustValues(t, s, "k"); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+				t.Fatalf("after reopen = %v, want [a b c]", got)
+			}
+		})
+	}
+}
+
+func TestLSMCrashBetweenSegmentRenameAndWALTruncate(t *testing.T) {
+	dir := t.TempDir()
+	walPath := filepath.Join(dir, lsmWALFile)
+
+	s := openLSM(t, dir)
+	base := time.Now().Add(-time.Hour)
+	put(t, s, model.KeyValue{Key: "k", Value: "a", CreatedAt: base})
+	put(t, s, model.KeyValue{Key: "k", Value: "b", CreatedAt: base.Add(time.Second)})
+	put(t, s, model.KeyValue{Key: "gone", Value: "x", CreatedAt: base})
+	if err := s.Delete("gone"); err != nil {
+		t.Fatal(err)
+	}
+
+	unflushedWAL, err := os.ReadFile(walPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flushLSM(t, s)
+	if len(segmentFiles(t, dir)) != 1 {
+		t.Fatalf("flush didn't write a segment")
+	}
+
+	//the segment is on disk but the wal reset was lost, every record it holds
+	//is in both places
+	closeLSM(t, s)
+	if err := os.WriteFile(walPath, unflushedWAL, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openLSM(t, dir)
+	if got := mustValues(t, s, "k"); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Fatalf("k = %v, want [a b] without replayed duplicates", got)
+	}
+	if got := mustValues(t, s, "gone"); got != nil {
+		t.Fatalf("deleted key came back: %v", got)
+	}
+
+	//new writes continue after the replayed sequence numbers
+	put(t, s, model.KeyValue{Key: "k", Value: "c", CreatedAt: base.Add(2 * time.Second)})
+	versions, err := s.GetAllVersions("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := map[uint]bool{}
+	for _, kv := range versions {
+		if ids[kv.ID] {
+			t.Fatalf("duplicate version id %d after recovery", kv.ID)
+		}
+		ids[kv.ID] = true
+	}
+	closeLSM(t, s)
+
+	s = openLSM(t, dir)
+	defer closeLSM(t, s)
+	if got := mustValues(t, s, "k"); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+		t.Fatalf("k after reopen = %v, want [a b c]", got)
+	}
+}
+
+func TestLSMReopenAfterFlushAndCompaction(t *testing.T) {
+	dir := t.TempDir()
+	s := openLSM(t, dir)
+	base := time.Now().Add(-time.Hour)
+	at := func(i int) time.Time { return base.Add(time.Duration(i) * time.Second) }
+
+	//three segments: plain versions, a full delete, a delete of single versions
+	put(t, s, model.KeyValue{Key: "a", Value: "a1", CreatedAt: at(1)})
+	put(t, s, model.KeyValue{Key: "b", Value: "b1", CreatedAt: at(2)})
+	put(t, s, model.KeyValue{Key: "c", Value: "c1", CreatedAt: at(3)})
+	flushLSM(t, s)
+
+	put(t, s, model.KeyValue{Key: "a", Value: "a2", CreatedAt: at(4)})
+	if err := s.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+	flushLSM(t, s)
+
+	versions, err := s.GetAllVersions("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteVersions("a", []uint{versions[0].ID}); err != nil {
+		t.Fatal(err)
+	}
+	put(t, s, model.KeyValue{Key: "c", Value: "c2", CreatedAt: at(5)})
+	flushLSM(t, s)
+
+	beforeCompaction := segmentFiles(t, dir)
+	if len(beforeCompaction) != 3 {
+		t.Fatalf("segments = %d, want 3", len(beforeCompaction))
+	}
+	saved := make(map[string][]byte, len(beforeCompaction))
+	for _, path := range beforeCompaction {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		saved[path] = data
+	}
+
+	if err := s.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if got := len(segmentFiles(t, dir)); got != 1 {
+		t.Fatalf("segments after compaction = %d, want 1", got)
+	}
+
+	//a write that stays in the wal only
+	put(t, s, model.KeyValue{Key: "d", Value: "d1", CreatedAt: at(6)})
+
+	want := map[string][]string{
+		"a": {"a2"},
+		"b": nil,
+		"c": {"c1", "c2"},
+		"d": {"d1"},
+	}
+	check := func(s Storage, when string) {
+		t.Helper()
+		for key, w := range want {
+			if got := mustValues(t, s, key); !reflect.DeepEqual(got, w) {
+				t.Fatalf("%s: %s = %v, want %v", when, key, got, w)
+			}
+		}
+		keys, err := s.ScanKeys("", "", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(keys, []string{"a", "c", "d"}) {
+			t.Fatalf("%s: keys = %v", when, keys)
+		}
+	}
+
+	check(s, "before reopen")
+	closeLSM(t, s)
+
+	s = openLSM(t, dir)
+	check(s, "after reopen")
+	closeLSM(t, s)
+
+	//a compaction that crashed after installing its output but before removing
+	//its inputs, and a flush that crashed before the rename
+	for path, data := range saved {
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(segmentPath(dir, 99)+".tmp", []byte("half written"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openLSM(t, dir)
+	defer closeLSM(t, s)
+	check(s, "after crashed compaction")
+	if got := len(segmentFiles(t, dir)); got != 1 {
+		t.Fatalf("merged inputs left on disk: %d segments", got)
+	}
+	if _, err := os.Stat(segmentPath(dir, 99) + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("half written segment not removed: %v", err)
+	}
+}
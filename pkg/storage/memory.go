@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rupeshx80/consistent-hashing/pkg/model"
+)
+
+// MemoryStorage keeps everything in process, meant for tests and throwaway nodes
+type MemoryStorage struct {
+	data   map[string][]model.KeyValue
+	nextID uint
+	mu     sync.RWMutex
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{data: make(map[string][]model.KeyValue)}
+}
+
+func (m *MemoryStorage) PutVersion(kv model.KeyValue) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextID++
+	kv.ID = m.nextID
+	if kv.CreatedAt.IsZero() {
+		kv.CreatedAt = time.Now()
+	}
+	kv.UpdatedAt = kv.CreatedAt
+
+	m.data[kv.Key] = append(m.data[kv.Key], kv)
+
+	//keep oldest first even when an older version arrives late (restore, replication)
+	versions := m.data[kv.Key]
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].CreatedAt.Before(versions[j].CreatedAt)
+	})
+	return nil
+}
+
+func (m *MemoryStorage) GetAllVersions(key string) ([]model.KeyValue, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	versions, ok := m.data[key]
+	if !ok || len(versions) == 0 {
+		return nil, ErrNotFound
+	}
+
+	out := make([]model.KeyValue, len(versions))
+	copy(out, versions)
+	return out, nil
+}
+
+func (m *MemoryStorage) sortedKeys() []string {
+	keys := make([]string, 0, len(m.data))
+	for k := range m.data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (m *MemoryStorage) ScanKeys(prefix, after string, limit int) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	end := prefixEnd(prefix)
+	var out []string
+	for _, k := range m.sortedKeys() {
+		if k < prefix || k <= after {
+			continue
+		}
+		if prefix != "" && end != "" && k >= end {
+			break
+		}
+		out = append(out, k)
+		if limit > 0 && len(out) == limit {
+			break
+		}
+	}
+	return out, nil
+}
+
+func (m *MemoryStorage) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.data, key)
+	return nil
+}
+
+func (m *MemoryStorage) DeleteVersions(key string, ids []uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	drop := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		drop[id] = true
+	}
+
+	var kept []model.KeyValue
+	for _, kv := range m.data[key] {
+		if !drop[kv.ID] {
+			kept = append(kept, kv)
+		}
+	}
+	if len(kept) == 0 {
+		delete(m.data, key)
+		return nil
+	}
+	m.data[key] = kept
+	return nil
+}
+
+func (m *MemoryStorage) IterateRange(start, end string, fn func(kv model.KeyValue) error) error {
+	m.mu.RLock()
+	var batch []model.KeyValue
+	for _, k := range m.sortedKeys() {
+		if k < start || (end != "" && k >= end) {
+			continue
+		}
+		batch = append(batch, m.data[k]...)
+	}
+	m.mu.RUnlock()
+
+	//callbacks run without the lock so they may write back into the store
+	for _, kv := range batch {
+		if err := fn(kv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStorage) GetVersionsSince(since time.Time) ([]model.KeyValue, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var out []model.KeyValue
+	for _, versions := range m.data {
+		for _, kv := range versions {
+			if kv.CreatedAt.After(since) {
+				out = append(out, kv)
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out, nil
+}
+
+func (m *MemoryStorage) PurgeExpired(now time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var purged int64
+	for key, versions := range m.data {
+		ids := expiredIDs(versions, now)
+		if len(ids) == 0 {
+			continue
+		}
+
+		drop := make(map[uint]bool, len(ids))
+		for _, id := range ids {
+			drop[id] = true
+		}
+		var kept []model.KeyValue
+		for _, kv := range versions {
+			if !drop[kv.ID] {
+				kept = append(kept, kv)
+			}
+		}
+
+		purged += int64(len(ids))
+		if len(kept) == 0 {
+			delete(m.data, key)
+			continue
+		}
+		m.data[key] = kept
+	}
+	return purged, nil
+}
+
+func (m *MemoryStorage) Close() error {
+	return nil
+}
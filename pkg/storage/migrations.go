@@ -0,0 +1,294 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migration is one schema step of a key/value table. Steps take the table name
+// since ring nodes on postgres each have their own table in the same database
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB, table string) error
+	Down    func(tx *gorm.DB, table string) error
+}
+
+// SchemaVersion is a row of schema_version, one per applied step per table
+type SchemaVersion struct {
+	Table     string `gorm:"column:table_name;primaryKey"`
+	Version   int    `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (SchemaVersion) TableName() string {
+	return "schema_version"
+}
+
+// ErrSchemaBehind means the table needs migrations AUTO_MIGRATE=false won't run on boot
+var ErrSchemaBehind = errors.New("schema is behind, run kvctl migrate up")
+
+//the table as it looked at each step, migrations must not follow model.KeyValue
+//as it changes or an old step would create a newer schema
+type keyValueV1 struct {
+	gorm.Model
+	Key         string `gorm:"not null"`
+	Value       string `gorm:"type:text"`
+	VectorClock string `gorm:"type:text"`
+	CreatedAt   time.Time
+}
+
+type keyValueV2 struct {
+	ExpiresAt *time.Time
+}
+
+type keyValueV3 struct {
+	Tombstone bool `gorm:"not null;default:false"`
+}
+
+func quote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func indexName(table, suffix string) string {
+	return "idx_" + table + "_" + suffix
+}
+
+func createIndex(tx *gorm.DB, table, suffix string, columns ...string) error {
+	quoted := make([]string, len(columns))
+	for i, c := range columns {
+		quoted[i] = quote(c)
+	}
+	return tx.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s)",
+		quote(indexName(table, suffix)), quote(table), strings.Join(quoted, ", "))).Error
+}
+
+func dropIndex(tx *gorm.DB, table, suffix string) error {
+	return tx.Exec("DROP INDEX IF EXISTS " + quote(indexName(table, suffix))).Error
+}
+
+//every step checks what is there first, tables created by the old AutoMigrate
+//boot are adopted by running the steps over them
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create key/value table",
+		Up: func(tx *gorm.DB, table string) error {
+			if !tx.Migrator().HasTable(table) {
+				if err := tx.Table(table).Migrator().CreateTable(&keyValueV1{}); err != nil {
+					return err
+				}
+			}
+			//older boots created a unique index on key, a key holds many versions
+			if err := dropIndex(tx, table, "key"); err != nil {
+				return err
+			}
+			return createIndex(tx, table, "key", "key")
+		},
+		Down: func(tx *gorm.DB, table string) error {
+			return tx.Migrator().DropTable(table)
+		},
+	},
+	{
+		Version: 2,
+		Name:    "add expires_at",
+		Up: func(tx *gorm.DB, table string) error {
+			if !tx.Table(table).Migrator().HasColumn(&keyValueV2{}, "ExpiresAt") {
+				if err := tx.Table(table).Migrator().AddColumn(&keyValueV2{}, "ExpiresAt"); err != nil {
+					return err
+				}
+			}
+			return createIndex(tx, table, "expires_at", "expires_at")
+		},
+		Down: func(tx *gorm.DB, table string) error {
+			if err := dropIndex(tx, table, "expires_at"); err != nil {
+				return err
+			}
+			return tx.Table(table).Migrator().DropColumn(&keyValueV2{}, "ExpiresAt")
+		},
+	},
+	{
+		Version: 3,
+		Name:    "add tombstone",
+		Up: func(tx *gorm.DB, table string) error {
+			if tx.Table(table).Migrator().HasColumn(&keyValueV3{}, "Tombstone") {
+				return nil
+			}
+			return tx.Table(table).Migrator().AddColumn(&keyValueV3{}, "Tombstone")
+		},
+		Down: func(tx *gorm.DB, table string) error {
+			return tx.Table(table).Migrator().DropColumn(&keyValueV3{}, "Tombstone")
+		},
+	},
+	{
+		//reads are "versions of key by created_at", the composite index answers them
+		//in order and still serves key only lookups, so the single column one goes
+		Version: 4,
+		Name:    "index (key, created_at)",
+		Up: func(tx *gorm.DB, table string) error {
+			if err := createIndex(tx, table, "key_created_at", "key", "created_at"); err != nil {
+				return err
+			}
+			return dropIndex(tx, table, "key")
+		},
+		Down: func(tx *gorm.DB, table string) error {
+			if err := createIndex(tx, table, "key", "key"); err != nil {
+				return err
+			}
+			return dropIndex(tx, table, "key_created_at")
+		},
+	},
+}
+
+// LatestSchemaVersion is the version a table has after every migration
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// SchemaMigrator moves one key/value table between schema versions
+type SchemaMigrator struct {
+	db    *gorm.DB
+	table string
+}
+
+func NewSchemaMigrator(gdb *gorm.DB, table string) *SchemaMigrator {
+	return &SchemaMigrator{db: gdb, table: table}
+}
+
+func (m *SchemaMigrator) init() error {
+	return m.db.AutoMigrate(&SchemaVersion{})
+}
+
+// Current is the highest applied version, 0 for a table no migration touched
+func (m *SchemaMigrator) Current() (int, error) {
+	if err := m.init(); err != nil {
+		return 0, fmt.Errorf("failed to create schema_version: %w", err)
+	}
+
+	var version int
+	err := m.db.Model(&SchemaVersion{}).
+		Where("table_name = ?", m.table).
+		Select("COALESCE(MAX(version), 0)").
+		Scan(&version).Error
+	return version, err
+}
+
+// Up applies every step above the current version up to target, 0 means latest.
+// Each step runs in its own transaction together with its schema_version row
+func (m *SchemaMigrator) Up(target int) error {
+	if target <= 0 {
+		target = LatestSchemaVersion()
+	}
+
+	current, err := m.Current()
+	if err != nil {
+		return err
+	}
+
+	for _, mig := range migrations {
+		if mig.Version <= current || mig.Version > target {
+			continue
+		}
+
+		err := m.db.Transaction(func(tx *gorm.DB) error {
+			if err := mig.Up(tx, m.table); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaVersion{
+				Table:     m.table,
+				Version:   mig.Version,
+				Name:      mig.Name,
+				AppliedAt: time.Now().UTC(),
+			}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d (%s) on %s failed: %w", mig.Version, mig.Name, m.table, err)
+		}
+		log.Printf("[MIGRATE] %s: applied %d %s", m.table, mig.Version, mig.Name)
+	}
+	return nil
+}
+
+// Down rolls back every applied step above target, newest first
+func (m *SchemaMigrator) Down(target int) error {
+	if target < 0 {
+		return fmt.Errorf("invalid target version %d", target)
+	}
+
+	current, err := m.Current()
+	if err != nil {
+		return err
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		mig := migrations[i]
+		if mig.Version > current || mig.Version <= target {
+			continue
+		}
+
+		err := m.db.Transaction(func(tx *gorm.DB) error {
+			if err := mig.Down(tx, m.table); err != nil {
+				return err
+			}
+			return tx.Where("table_name = ? AND version = ?", m.table, mig.Version).Delete(&SchemaVersion{}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("rollback of %d (%s) on %s failed: %w", mig.Version, mig.Name, m.table, err)
+		}
+		log.Printf("[MIGRATE] %s: rolled back %d %s", m.table, mig.Version, mig.Name)
+	}
+	return nil
+}
+
+// MigrationStatus is a step and when it was applied, zero if it wasn't
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt time.Time
+}
+
+func (m *SchemaMigrator) Status() ([]MigrationStatus, error) {
+	if err := m.init(); err != nil {
+		return nil, fmt.Errorf("failed to create schema_version: %w", err)
+	}
+
+	var applied []SchemaVersion
+	if err := m.db.Where("table_name = ?", m.table).Find(&applied).Error; err != nil {
+		return nil, err
+	}
+	at := make(map[int]time.Time, len(applied))
+	for _, v := range applied {
+		at[v.Version] = v.AppliedAt
+	}
+
+	out := make([]MigrationStatus, 0, len(migrations))
+	for _, mig := range migrations {
+		out = append(out, MigrationStatus{Version: mig.Version, Name: mig.Name, AppliedAt: at[mig.Version]})
+	}
+	return out, nil
+}
+
+// migrateOnOpen brings the table to the latest schema, or with AUTO_MIGRATE=false
+// only checks that someone already did
+func migrateOnOpen(gdb *gorm.DB, table string) error {
+	m := NewSchemaMigrator(gdb, table)
+	if os.Getenv("AUTO_MIGRATE") != "false" {
+		return m.Up(0)
+	}
+
+	current, err := m.Current()
+	if err != nil {
+		return err
+	}
+	if current < LatestSchemaVersion() {
+		return fmt.Errorf("%w: %s is at %d, latest is %d", ErrSchemaBehind, table, current, LatestSchemaVersion())
+	}
+	return nil
+}
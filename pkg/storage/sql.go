@@ -0,0 +1,226 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/rupeshx80/consistent-hashing/pkg/db"
+	"github.com/rupeshx80/consistent-hashing/pkg/model"
+	"gorm.io/gorm"
+)
+
+// SQLStorage is the GORM engine shared by postgres and the embedded sqlite file
+type SQLStorage struct {
+	db *gorm.DB
+}
+
+func NewSQLStorage(gdb *gorm.DB) *SQLStorage {
+	return &SQLStorage{db: gdb}
+}
+
+//table model.KeyValue maps to when no table is given
+const defaultTable = "key_values"
+
+// connectPostgres returns a handle for table without touching its schema, the
+// shared key_values table when table is empty
+func connectPostgres(table string) (*gorm.DB, string) {
+	if db.RJ == nil {
+		db.Connect()
+	}
+	if table == "" {
+		return db.RJ, defaultTable
+	}
+	//a fresh session pinned to the table, safe to reuse for every query
+	return db.RJ.Table(table).Session(&gorm.Session{}), table
+}
+
+func connectSQLite(path string) (*gorm.DB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create sqlite dir: %w", err)
+	}
+
+	gdb, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite %s: %w", path, err)
+	}
+	return gdb, nil
+}
+
+// OpenPostgres uses the shared key_values table when table is empty, ring nodes
+// pass their own table so every node keeps its own copy of the data
+func OpenPostgres(table string) (*SQLStorage, error) {
+	gdb, table := connectPostgres(table)
+	if err := migrateOnOpen(db.RJ, table); err != nil {
+		return nil, err
+	}
+
+	log.Printf("[STORAGE] Postgres table %s ready", table)
+	return NewSQLStorage(gdb), nil
+}
+
+func OpenSQLite(path string) (*SQLStorage, error) {
+	gdb, err := connectSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := migrateOnOpen(gdb, defaultTable); err != nil {
+		return nil, err
+	}
+
+	log.Printf("[STORAGE] SQLite database ready at %s", path)
+	return NewSQLStorage(gdb), nil
+}
+
+// OpenSchemaMigrator opens the sql table cfg points at without migrating it,
+// close releases the connection
+func OpenSchemaMigrator(cfg Config) (m *SchemaMigrator, close func() error, err error) {
+	switch cfg.Engine {
+	case EnginePostgres:
+		_, table := connectPostgres(cfg.Table)
+		return NewSchemaMigrator(db.RJ, table), NewSQLStorage(db.RJ).Close, nil
+	case EngineSQLite:
+		gdb, err := connectSQLite(cfg.Path)
+		if err != nil {
+			return nil, nil, err
+		}
+		return NewSchemaMigrator(gdb, defaultTable), NewSQLStorage(gdb).Close, nil
+	}
+	return nil, nil, fmt.Errorf("engine %s has no sql schema to migrate", cfg.Engine)
+}
+
+func (s *SQLStorage) PutVersion(kv model.KeyValue) error {
+	return s.db.Create(&kv).Error
+}
+
+func (s *SQLStorage) GetAllVersions(key string) ([]model.KeyValue, error) {
+	var versions []model.KeyValue
+
+	result := s.db.Where("key = ?", key).Order("created_at asc").Find(&versions)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	if len(versions) == 0 {
+		return nil, ErrNotFound
+	}
+	return versions, nil
+}
+
+func (s *SQLStorage) ScanKeys(prefix, after string, limit int) ([]string, error) {
+	var keys []string
+
+	query := s.db.Model(&model.KeyValue{}).Distinct("key")
+	if prefix != "" {
+		query = query.Where("key >= ?", prefix)
+		if end := prefixEnd(prefix); end != "" {
+			query = query.Where("key < ?", end)
+		}
+	}
+	if after != "" {
+		query = query.Where("key > ?", after)
+	}
+	query = query.Order("key asc")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	if err := query.Pluck("key", &keys).Error; err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (s *SQLStorage) Delete(key string) error {
+	return s.db.Where("key = ?", key).Delete(&model.KeyValue{}).Error
+}
+
+func (s *SQLStorage) DeleteVersions(key string, ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return s.db.Unscoped().Where("key = ? AND id IN ?", key, ids).Delete(&model.KeyValue{}).Error
+}
+
+func (s *SQLStorage) IterateRange(start, end string, fn func(kv model.KeyValue) error) error {
+	query := s.db.Model(&model.KeyValue{}).Where("key >= ?", start)
+	if end != "" {
+		query = query.Where("key < ?", end)
+	}
+
+	rows, err := query.Order("key asc, created_at asc").Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var kv model.KeyValue
+		if err := s.db.ScanRows(rows, &kv); err != nil {
+			return err
+		}
+		if err := fn(kv); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *SQLStorage) GetVersionsSince(since time.Time) ([]model.KeyValue, error) {
+	var versions []model.KeyValue
+	result := s.db.Where("created_at > ?", since).Order("created_at asc").Find(&versions)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return versions, nil
+}
+
+// PurgeExpired hard deletes expired versions together with the versions they
+// superseded, otherwise those would reappear
+func (s *SQLStorage) PurgeExpired(now time.Time) (int64, error) {
+	var keys []string
+
+	result := s.db.Model(&model.KeyValue{}).Distinct("key").
+		Where("expires_at IS NOT NULL AND expires_at <= ?", now).
+		Pluck("key", &keys)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	//which versions an expired one supersedes depends on the clocks, so every
+	//affected key is loaded and checked with the shared rule
+	var purged int64
+	for _, key := range keys {
+		versions, err := s.GetAllVersions(key)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return purged, err
+		}
+
+		ids := expiredIDs(versions, now)
+		if len(ids) == 0 {
+			continue
+		}
+		res := s.db.Unscoped().Where("key = ? AND id IN ?", key, ids).Delete(&model.KeyValue{})
+		if res.Error != nil {
+			return purged, res.Error
+		}
+		purged += res.RowsAffected
+	}
+	return purged, nil
+}
+
+func (s *SQLStorage) Close() error {
+	sqlDB, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rupeshx80/consistent-hashing/pkg/model"
+	"github.com/rupeshx80/consistent-hashing/pkg/vclock"
+)
+
+var ErrNotFound = errors.New("key not found")
+
+// Storage is the contract every engine implements, versions of one key are
+// returned oldest first and keys are always scanned in byte order
+type Storage interface {
+	PutVersion(kv model.KeyValue) error
+	GetAllVersions(key string) ([]model.KeyValue, error)
+	// ScanKeys returns up to limit distinct keys with prefix that sort after "after", limit <= 0 means all
+	ScanKeys(prefix, after string, limit int) ([]string, error)
+	Delete(key string) error
+	// DeleteVersions removes single versions of key by their ID
+	DeleteVersions(key string, ids []uint) error
+	// IterateRange calls fn for every version with start <= key < end, an empty end is unbounded
+	IterateRange(start, end string, fn func(kv model.KeyValue) error) error
+	GetVersionsSince(since time.Time) ([]model.KeyValue, error)
+	// PurgeExpired removes expired versions and what they superseded, see expiredIDs
+	PurgeExpired(now time.Time) (int64, error)
+	Close() error
+}
+
+const (
+	EnginePostgres = "postgres"
+	EngineSQLite   = "sqlite"
+	EngineMemory   = "memory"
+	EngineLSM      = "lsm"
+)
+
+type Config struct {
+	Engine string
+	Path   string //sqlite file or lsm directory, ignored by the other engines
+	Table  string //postgres table, empty is the shared key_values table
+}
+
+// ConfigFromEnv reads STORAGE_ENGINE (postgres by default) and STORAGE_PATH
+func ConfigFromEnv() Config {
+	cfg := Config{Engine: EnginePostgres}
+
+	if v := os.Getenv("STORAGE_ENGINE"); v != "" {
+		cfg.Engine = strings.ToLower(v)
+	}
+
+	cfg.Path = os.Getenv("STORAGE_PATH")
+	if cfg.Path == "" {
+		cfg.Path = "data/main.db"
+		if cfg.Engine == EngineLSM {
+			cfg.Path = "data/main-lsm"
+		}
+	}
+	return cfg
+}
+
+// NodeConfigFromEnv is the engine ring nodes store their replicas in, read from
+// NODE_STORAGE_ENGINE (lsm by default). Paths are per node, see ForNode
+func NodeConfigFromEnv() Config {
+	cfg := Config{Engine: EngineLSM}
+	if v := os.Getenv("NODE_STORAGE_ENGINE"); v != "" {
+		cfg.Engine = strings.ToLower(v)
+	}
+	return cfg
+}
+
+// ForNode gives a node its own file, directory or table so no two nodes share data
+func (c Config) ForNode(name, dir string) Config {
+	switch c.Engine {
+	case EngineSQLite:
+		c.Path = filepath.Join(dir, "store.db")
+	case EngineLSM:
+		c.Path = filepath.Join(dir, "store")
+	case EnginePostgres:
+		c.Table = "key_values_" + strings.NewReplacer("-", "_", ".", "_").Replace(name)
+	}
+	return c
+}
+
+func Open(cfg Config) (Storage, error) {
+	log.Printf("[STORAGE] Opening %s engine", cfg.Engine)
+
+	switch cfg.Engine {
+	case EnginePostgres:
+		return OpenPostgres(cfg.Table)
+	case EngineSQLite:
+		return OpenSQLite(cfg.Path)
+	case EngineMemory:
+		return NewMemoryStorage(), nil
+	case EngineLSM:
+		return OpenLSM(cfg.Path)
+	}
+	return nil, fmt.Errorf("unknown storage engine: %s", cfg.Engine)
+}
+
+// expiredIDs is what PurgeExpired removes from the versions of one key, every
+// engine applies the same vclock.Purgeable rule
+func expiredIDs(versions []model.KeyValue, now time.Time) []uint {
+	clocks := make([]string, len(versions))
+	expired := make([]bool, len(versions))
+	found := false
+	for i, kv := range versions {
+		clocks[i] = kv.VectorClock
+		expired[i] = model.IsExpired(kv.ExpiresAt, now)
+		found = found || expired[i]
+	}
+	if !found {
+		return nil
+	}
+
+	var ids []uint
+	for i, purge := range vclock.Purgeable(clocks, expired) {
+		if purge {
+			ids = append(ids, versions[i].ID)
+		}
+	}
+	return ids
+}
+
+// prefixEnd is the smallest key greater than every key starting with prefix,
+// so a prefix scan becomes an index friendly range scan
+func prefixEnd(prefix string) string {
+	b := []byte(prefix)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < 0xff {
+			b[i]++
+			return string(b[:i+1])
+		}
+	}
+	return ""
+}
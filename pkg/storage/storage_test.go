@@ -0,0 +1,268 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rupeshx80/consistent-hashing/pkg/model"
+)
+
+// every embedded engine has to pass the same contract, postgres needs a server
+// and is left out
+func forEachEngine(t *testing.T, fn func(t *testing.T, s Storage)) {
+	engines := []struct {
+		name string
+		cfg  func(dir string) Config
+	}{
+		{EngineMemory, func(dir string) Config { return Config{Engine: EngineMemory} }},
+		{EngineSQLite, func(dir string) Config { return Config{Engine: EngineSQLite, Path: filepath.Join(dir, "store.db")} }},
+		{EngineLSM, func(dir string) Config { return Config{Engine: EngineLSM, Path: filepath.Join(dir, "store")} }},
+	}
+
+	for _, e := range engines {
+		t.Run(e.name, func(t *testing.T) {
+			s, err := Open(e.cfg(t.TempDir()))
+			if err != nil {
+				t.Fatalf("open: %v", err)
+			}
+			t.Cleanup(func() { s.Close() })
+			fn(t, s)
+		})
+	}
+}
+
+func put(t *testing.T, s Storage, kv model.KeyValue) {
+	t.Helper()
+	if err := s.PutVersion(kv); err != nil {
+		t.Fatalf("put %s=%s: %v", kv.Key, kv.Value, err)
+	}
+}
+
+func values(versions []model.KeyValue) []string {
+	out := make([]string, len(versions))
+	for i, kv := range versions {
+		out[i] = kv.Value
+	}
+	return out
+}
+
+func TestStorageVersionsOldestFirst(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, s Storage) {
+		base := time.Now().Add(-time.Hour)
+		put(t, s, model.KeyValue{Key: "k", Value: "b", VectorClock: `{"n":2}`, CreatedAt: base.Add(2 * time.Second)})
+		put(t, s, model.KeyValue{Key: "k", Value: "a", VectorClock: `{"n":1}`, CreatedAt: base.Add(time.Second)})
+		put(t, s, model.KeyValue{Key: "k", Value: "c", VectorClock: `{"n":3}`, CreatedAt: base.Add(3 * time.Second), Tombstone: true})
+
+		versions, err := s.GetAllVersions("k")
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		if got := values(versions); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+			t.Fatalf("versions = %v, want oldest first", got)
+		}
+
+		ids := map[uint]bool{}
+		for _, kv := range versions {
+			if kv.ID == 0 || ids[kv.ID] {
+				t.Fatalf("version ids must be unique and set, got %d", kv.ID)
+			}
+			ids[kv.ID] = true
+		}
+		if versions[1].VectorClock != `{"n":2}` || !versions[2].Tombstone {
+			t.Fatalf("fields not kept: %+v", versions)
+		}
+
+		if _, err := s.GetAllVersions("missing"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("missing key err = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestStorageScanKeys(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, s Storage) {
+		for _, key := range []string{"user:2", "user:1", "order:1", "user:10", "user:1"} {
+			put(t, s, model.KeyValue{Key: key, Value: "v"})
+		}
+
+		cases := []struct {
+			prefix, after string
+			limit         int
+			want          []string
+		}{
+			{"", "", 0, []string{"order:1", "user:1", "user:10", "user:2"}},
+			{"user:", "", 0, []string{"user:1", "user:10", "user:2"}},
+			{"user:", "user:1", 0, []string{"user:10", "user:2"}},
+			{"user:", "", 2, []string{"user:1", "user:10"}},
+			{"nope", "", 0, nil},
+		}
+		for _, c := range cases {
+			got, err := s.ScanKeys(c.prefix, c.after, c.limit)
+			if err != nil {
+				t.Fatalf("scan: %v", err)
+			}
+			if len(got) == 0 && len(c.want) == 0 {
+				continue
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("ScanKeys(%q, %q, %d) = %v, want %v", c.prefix, c.after, c.limit, got, c.want)
+			}
+		}
+	})
+}
+
+func TestStorageDelete(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, s Storage) {
+		put(t, s, model.KeyValue{Key: "k", Value: "a"})
+		put(t, s, model.KeyValue{Key: "k", Value: "b"})
+		put(t, s, model.KeyValue{Key: "other", Value: "x"})
+
+		if err := s.Delete("k"); err != nil {
+			t.Fatalf("delete: %v", err)
+		}
+		if _, err := s.GetAllVersions("k"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("deleted key err = %v, want ErrNotFound", err)
+		}
+		if keys, _ := s.ScanKeys("", "", 0); !reflect.DeepEqual(keys, []string{"other"}) {
+			t.Fatalf("keys after delete = %v", keys)
+		}
+
+		//a key can be written again after a delete
+		put(t, s, model.KeyValue{Key: "k", Value: "c"})
+		versions, err := s.GetAllVersions("k")
+		if err != nil || !reflect.DeepEqual(values(versions), []string{"c"}) {
+			t.Fatalf("rewritten key = %v, %v", values(versions), err)
+		}
+	})
+}
+
+func TestStorageDeleteVersions(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, s Storage) {
+		base := time.Now().Add(-time.Hour)
+		for i, v := range []string{"a", "b", "c"} {
+			put(t, s, model.KeyValue{Key: "k", Value: v, CreatedAt: base.Add(time.Duration(i) * time.Second)})
+		}
+
+		versions, _ := s.GetAllVersions("k")
+		if err := s.DeleteVersions("k", []uint{versions[0].ID, versions[2].ID}); err != nil {
+			t.Fatalf("delete versions: %v", err)
+		}
+
+		versions, err := s.GetAllVersions("k")
+		if err != nil || !reflect.DeepEqual(values(versions), []string{"b"}) {
+			t.Fatalf("after DeleteVersions = %v, %v", values(versions), err)
+		}
+	})
+}
+
+func TestStorageIterateRange(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, s Storage) {
+		for _, key := range []string{"a", "b", "c", "d"} {
+			put(t, s, model.KeyValue{Key: key, Value: key})
+		}
+		put(t, s, model.KeyValue{Key: "b", Value: "b2"})
+
+		collect := func(start, end string) []string {
+			var got []string
+			err := s.IterateRange(start, end, func(kv model.KeyValue) error {
+				got = append(got, kv.Value)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("iterate: %v", err)
+			}
+			return got
+		}
+
+		if got := collect("b", "d"); !reflect.DeepEqual(got, []string{"b", "b2", "c"}) {
+			t.Fatalf("[b, d) = %v", got)
+		}
+		if got := collect("c", ""); !reflect.DeepEqual(got, []string{"c", "d"}) {
+			t.Fatalf("[c, end) = %v", got)
+		}
+
+		stop := errors.New("stop")
+		if err := s.IterateRange("", "", func(kv model.KeyValue) error { return stop }); !errors.Is(err, stop) {
+			t.Fatalf("callback error = %v, want it returned", err)
+		}
+	})
+}
+
+func TestStorageGetVersionsSince(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, s Storage) {
+		since := time.Now().Add(-time.Minute)
+		put(t, s, model.KeyValue{Key: "old", Value: "old", CreatedAt: since.Add(-time.Minute)})
+		put(t, s, model.KeyValue{Key: "b", Value: "new2", CreatedAt: since.Add(2 * time.Second)})
+		put(t, s, model.KeyValue{Key: "a", Value: "new1", CreatedAt: since.Add(time.Second)})
+
+		versions, err := s.GetVersionsSince(since)
+		if err != nil {
+			t.Fatalf("since: %v", err)
+		}
+		if got := values(versions); !reflect.DeepEqual(got, []string{"new1", "new2"}) {
+			t.Fatalf("versions since = %v, want the two newer ones oldest first", got)
+		}
+	})
+}
+
+func TestStoragePurgeExpired(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, s Storage) {
+		now := time.Now()
+		past := now.Add(-time.Second)
+		future := now.Add(time.Hour)
+		base := now.Add(-time.Hour)
+
+		//"old" is what the expired write replaced, "sibling" was written
+		//concurrently and never seen by it
+		put(t, s, model.KeyValue{Key: "k", Value: "old", VectorClock: `{"n":1,"@n":0}`, CreatedAt: base})
+		put(t, s, model.KeyValue{Key: "k", Value: "expired", VectorClock: `{"n":2,"@n":1}`, CreatedAt: base.Add(time.Second), ExpiresAt: &past})
+		put(t, s, model.KeyValue{Key: "k", Value: "sibling", VectorClock: `{"n":3,"@n":0}`, CreatedAt: base.Add(2 * time.Second)})
+
+		put(t, s, model.KeyValue{Key: "gone", Value: "x", VectorClock: `{"n":1}`, ExpiresAt: &past})
+		put(t, s, model.KeyValue{Key: "live", Value: "y", VectorClock: `{"n":1}`, ExpiresAt: &future})
+
+		purged, err := s.PurgeExpired(now)
+		if err != nil {
+			t.Fatalf("purge: %v", err)
+		}
+		if purged != 3 {
+			t.Fatalf("purged = %d, want 3", purged)
+		}
+
+		versions, err := s.GetAllVersions("k")
+		if err != nil || !reflect.DeepEqual(values(versions), []string{"sibling"}) {
+			t.Fatalf("k after purge = %v, %v, want only the concurrent sibling", values(versions), err)
+		}
+		if _, err := s.GetAllVersions("gone"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("fully expired key err = %v, want ErrNotFound", err)
+		}
+		if _, err := s.GetAllVersions("live"); err != nil {
+			t.Fatalf("unexpired key: %v", err)
+		}
+	})
+}
+
+func TestStorageReplaceValue(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, s Storage) {
+		key := SystemPrefix + "test"
+		if _, ok, err := LatestValue(s, key); ok || err != nil {
+			t.Fatalf("empty LatestValue = %v, %v", ok, err)
+		}
+
+		for _, v := range []string{"one", "two", "three"} {
+			if err := ReplaceValue(s, key, v); err != nil {
+				t.Fatalf("replace: %v", err)
+			}
+		}
+
+		value, ok, err := LatestValue(s, key)
+		if err != nil || !ok || value != "three" {
+			t.Fatalf("LatestValue = %q, %v, %v", value, ok, err)
+		}
+		if versions, _ := s.GetAllVersions(key); len(versions) != 1 {
+			t.Fatalf("ReplaceValue kept %d versions, want 1", len(versions))
+		}
+	})
+}
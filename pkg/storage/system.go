@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rupeshx80/consistent-hashing/pkg/model"
+)
+
+// SystemPrefix marks keys a node keeps for its own bookkeeping, like paxos and
+// transaction state. They share the node's store but are never replicas, so
+// scans and range transfers skip them and clients can't write them. It starts
+// with DEL rather than NUL, postgres refuses NUL in text columns
+const SystemPrefix = "\x7fsys/"
+
+func IsSystemKey(key string) bool {
+	return strings.HasPrefix(key, SystemPrefix)
+}
+
+// ReplaceValue makes value the only version of key. The new version is written
+// before the old ones are removed, a crash in between leaves both and the newest
+// one, last in the list, still wins
+func ReplaceValue(s Storage, key, value string) error {
+	old, err := s.GetAllVersions(key)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("failed to read %s: %w", key, err)
+	}
+
+	if err := s.PutVersion(model.KeyValue{Key: key, Value: value, CreatedAt: time.Now()}); err != nil {
+		return fmt.Errorf("failed to write %s: %w", key, err)
+	}
+
+	if len(old) == 0 {
+		return nil
+	}
+	ids := make([]uint, len(old))
+	for i, kv := range old {
+		ids[i] = kv.ID
+	}
+	return s.DeleteVersions(key, ids)
+}
+
+// LatestValue returns the value ReplaceValue last stored under key, ok is false when there is none
+func LatestValue(s Storage, key string) (value string, ok bool, err error) {
+	versions, err := s.GetAllVersions(key)
+	if errors.Is(err, ErrNotFound) || (err == nil && len(versions) == 0) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read %s: %w", key, err)
+	}
+	return versions[len(versions)-1].Value, true, nil
+}
@@ -0,0 +1,72 @@
+package txn
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ParticipantController struct {
+	participant *Participant
+}
+
+type prepareRequest struct {
+	ID          string  `json:"id" binding:"required"`
+	Coordinator string  `json:"coordinator"` //address the participant asks when the decision doesn't come
+	Writes      []Write `json:"writes" binding:"required"`
+}
+
+type decisionRequest struct {
+	ID string `json:"id" binding:"required"`
+}
+
+func NewParticipantController(participant *Participant) *ParticipantController {
+	return &ParticipantController{participant: participant}
+}
+
+// Prepare answers 200 with the vote, a no vote is not an error
+func (pc *ParticipantController) Prepare(ctx *gin.Context) {
+	var req prepareRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, pc.participant.Prepare(req.ID, req.Coordinator, req.Writes))
+}
+
+func (pc *ParticipantController) Commit(ctx *gin.Context) {
+	var req decisionRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := pc.participant.Commit(req.ID); err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, ErrUnknownTransaction):
+			status = http.StatusNotFound
+		case errors.Is(err, ErrAborted):
+			status = http.StatusConflict
+		}
+		ctx.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "committed"})
+}
+
+func (pc *ParticipantController) Abort(ctx *gin.Context) {
+	var req decisionRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := pc.participant.Abort(req.ID); err != nil {
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "aborted"})
+}
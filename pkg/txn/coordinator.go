@@ -0,0 +1,305 @@
+package txn
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rupeshx80/consistent-hashing/pkg/storage"
+)
+
+// outcomes a participant can learn from the coordinator's /txn/status
+const (
+	StateCommitted = "committed"
+	StateAborted   = "aborted"
+	StatePending   = "pending" //still collecting votes, ask again later
+)
+
+//a commit decision with the replicas that haven't acknowledged it yet
+const commitPrefix = storage.SystemPrefix + "txn/commit/"
+
+//the longest pause between two attempts to deliver a commit
+const maxCommitBackoff = 30 * time.Second
+
+type commitRecord struct {
+	Nodes []string `json:"nodes"` //replicas still missing the commit
+}
+
+// Coordinator drives two-phase commit over the replicas of one partition, every
+// replica has to vote yes for the transaction to commit. A commit decision is
+// saved in store before any replica hears it and stays until every replica took
+// it, with no saved decision a transaction is aborted (presumed abort)
+type Coordinator struct {
+	httpClient *http.Client
+	store      storage.Storage
+	addr       string //where participants reach this coordinator
+
+	mu      sync.Mutex
+	active  map[string]bool //transactions still collecting votes
+	pending map[string]*commitRecord
+}
+
+func NewCoordinator(store storage.Storage, addr string) *Coordinator {
+	return &Coordinator{
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+		store:      store,
+		addr:       addr,
+		active:     make(map[string]bool),
+		pending:    make(map[string]*commitRecord),
+	}
+}
+
+// Recover loads the commit decisions that didn't reach every replica before a
+// restart and keeps delivering them
+func (c *Coordinator) Recover() error {
+	keys, err := c.store.ScanKeys(commitPrefix, "", 0)
+	if err != nil {
+		return fmt.Errorf("failed to scan commit decisions: %w", err)
+	}
+
+	for _, key := range keys {
+		raw, ok, err := storage.LatestValue(c.store, key)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		var rec commitRecord
+		if err := json.Unmarshal([]byte(raw), &rec); err != nil {
+			return fmt.Errorf("failed to recover %s: %w", key, err)
+		}
+
+		id := strings.TrimPrefix(key, commitPrefix)
+		c.mu.Lock()
+		c.pending[id] = &rec
+		c.mu.Unlock()
+
+		decision, _ := json.Marshal(decisionRequest{ID: id})
+		for _, node := range rec.Nodes {
+			go c.retryCommit(node, id, decision)
+		}
+		log.Printf("[TXN] %s recovered as committed, delivering to %v", id, rec.Nodes)
+	}
+	return nil
+}
+
+// Status is the outcome participants ask for when the decision didn't reach them.
+// Commit records go once every participant acknowledged, so an unknown id is
+// aborted: no participant can still be waiting on a commit that was dropped
+func (c *Coordinator) Status(id string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.active[id] {
+		return StatePending
+	}
+	if _, ok := c.pending[id]; ok {
+		return StateCommitted
+	}
+	return StateAborted
+}
+
+func NewID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+type nodeAnswer struct {
+	node   string
+	vote   Vote
+	status int
+	err    error
+}
+
+// Run prepares the writes on every node and commits once all of them voted yes,
+// otherwise it aborts them and returns ErrAborted. After the commit decision a
+// replica that missed the commit is retried in the background
+func (c *Coordinator) Run(ctx context.Context, nodes []string, id string, writes []Write) error {
+	payload, err := json.Marshal(prepareRequest{ID: id, Coordinator: c.addr, Writes: writes})
+	if err != nil {
+		return fmt.Errorf("failed to marshal prepare: %w", err)
+	}
+
+	c.mu.Lock()
+	c.active[id] = true
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.active, id)
+		c.mu.Unlock()
+	}()
+
+	var reasons []string
+	for _, a := range c.broadcast(ctx, nodes, "/txn/prepare", payload) {
+		switch {
+		case a.err != nil:
+			reasons = append(reasons, fmt.Sprintf("%s: %v", a.node, a.err))
+		case !a.vote.Prepared:
+			reasons = append(reasons, fmt.Sprintf("%s: %s", a.node, a.vote.Reason))
+		}
+	}
+
+	decision, _ := json.Marshal(decisionRequest{ID: id})
+	if len(reasons) > 0 {
+		sort.Strings(reasons)
+		log.Printf("[TXN] %s aborting, votes against: %v", id, reasons)
+		//nodes that voted no or never answered get the abort too, it is a no-op there
+		c.broadcast(context.Background(), nodes, "/txn/abort", decision)
+		return fmt.Errorf("%w: %s", ErrAborted, strings.Join(reasons, "; "))
+	}
+
+	//the decision is durable before any replica applies it, a replica that misses
+	//the commit learns it from a retry or by asking /txn/status
+	rec := &commitRecord{Nodes: append([]string(nil), nodes...)}
+	if err := c.saveCommit(id, rec); err != nil {
+		log.Printf("[TXN] %s failed to save the commit decision, aborting: %v", id, err)
+		c.broadcast(context.Background(), nodes, "/txn/abort", decision)
+		return fmt.Errorf("%w: failed to save the commit decision: %v", ErrAborted, err)
+	}
+	c.mu.Lock()
+	c.pending[id] = rec
+	c.mu.Unlock()
+
+	log.Printf("[TXN] %s prepared on %v, committing", id, nodes)
+	for _, a := range c.broadcast(context.Background(), nodes, "/txn/commit", decision) {
+		if a.err != nil {
+			log.Printf("[TXN] %s commit on %s failed, retrying in background: %v", id, a.node, a.err)
+			go c.retryCommit(a.node, id, decision)
+			continue
+		}
+		c.acknowledged(id, a.node)
+	}
+	return nil
+}
+
+// retryCommit keeps sending the decision until the replica takes it, a replica
+// that voted yes stays blocked on the transaction until then
+func (c *Coordinator) retryCommit(node, id string, decision []byte) {
+	wait := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		time.Sleep(wait)
+		a := c.post(context.Background(), node, "/txn/commit", decision)
+		if a.err == nil || a.status == http.StatusNotFound {
+			//not found: it committed and already forgot, it voted yes so it can't have missed the prepare
+			log.Printf("[TXN] %s commit reached %s after %d retries", id, node, attempt)
+			c.acknowledged(id, node)
+			return
+		}
+		if a.status == http.StatusConflict {
+			log.Printf("[TXN] %s was aborted on %s although it voted yes, not retrying: %v", id, node, a.err)
+			c.acknowledged(id, node)
+			return
+		}
+		if wait < maxCommitBackoff {
+			wait *= 2
+		}
+		log.Printf("[TXN] %s commit on %s failed, retry %d in %v: %v", id, node, attempt, wait, a.err)
+	}
+}
+
+//drops node from the decision's pending replicas, the decision goes once all took it
+func (c *Coordinator) acknowledged(id, node string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	rec, ok := c.pending[id]
+	if !ok {
+		return
+	}
+	left := make([]string, 0, len(rec.Nodes))
+	for _, n := range rec.Nodes {
+		if n != node {
+			left = append(left, n)
+		}
+	}
+
+	next := &commitRecord{Nodes: left}
+	if len(left) == 0 {
+		if err := c.store.Delete(commitPrefix + id); err != nil {
+			log.Printf("[TXN] %s failed to drop the delivered decision: %v", id, err)
+			return
+		}
+		delete(c.pending, id)
+		return
+	}
+	if err := c.saveCommit(id, next); err != nil {
+		//the old record still lists node, after a restart it just gets the commit again
+		log.Printf("[TXN] %s failed to save delivery to %s: %v", id, node, err)
+	}
+	c.pending[id] = next
+}
+
+func (c *Coordinator) saveCommit(id string, rec *commitRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return storage.ReplaceValue(c.store, commitPrefix+id, string(data))
+}
+
+func (c *Coordinator) broadcast(ctx context.Context, nodes []string, path string, payload []byte) []nodeAnswer {
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		out []nodeAnswer
+	)
+
+	for _, node := range nodes {
+		wg.Add(1)
+		go func(n string) {
+			defer wg.Done()
+			a := c.post(ctx, n, path, payload)
+
+			mu.Lock()
+			out = append(out, a)
+			mu.Unlock()
+		}(node)
+	}
+
+	wg.Wait()
+	return out
+}
+
+func (c *Coordinator) post(ctx context.Context, node, path string, payload []byte) nodeAnswer {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://127.0.0.1"+node+path, bytes.NewReader(payload))
+	if err != nil {
+		return nodeAnswer{node: node, err: err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nodeAnswer{node: node, err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var body struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return nodeAnswer{node: node, status: resp.StatusCode, err: fmt.Errorf("status=%d %s", resp.StatusCode, body.Error)}
+	}
+
+	var a nodeAnswer
+	a.node = node
+	if path == "/txn/prepare" {
+		if err := json.NewDecoder(resp.Body).Decode(&a.vote); err != nil {
+			a.err = err
+		}
+	}
+	return a
+}
@@ -0,0 +1,379 @@
+package txn
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rupeshx80/consistent-hashing/pkg/model"
+	"github.com/rupeshx80/consistent-hashing/pkg/storage"
+)
+
+// InDoubtAfter is how long a replica waits for the decision on a transaction it
+// voted yes on before it asks the coordinator. It never aborts by itself, after
+// a yes vote only the coordinator's decision releases the keys
+const InDoubtAfter = 30 * time.Second
+
+//how long decided transactions are remembered so a retried commit or abort is answered the same way
+const decisionRetention = 10 * time.Minute
+
+//participant state in the node's store, prepared writes survive a restart with their locks
+const (
+	preparedPrefix = storage.SystemPrefix + "txn/prepared/"
+	decidedPrefix  = storage.SystemPrefix + "txn/decided/"
+)
+
+var (
+	ErrUnknownTransaction = errors.New("unknown transaction")
+	ErrAborted            = errors.New("transaction aborted")
+)
+
+// Write is one key of a transaction, the fields a replica /set takes
+type Write struct {
+	Key         string `json:"key"`
+	Value       string `json:"value"`
+	VectorClock string `json:"vectorClock"`
+	ExpiresAt   string `json:"expiresAt,omitempty"`
+	Tombstone   bool   `json:"tombstone,omitempty"`
+}
+
+type Vote struct {
+	Prepared bool   `json:"prepared"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// ApplyFunc stores one committed write on the replica
+type ApplyFunc func(key, value, vectorClock string, expiresAt *time.Time, tombstone bool) error
+
+type preparedTxn struct {
+	Writes      []Write   `json:"writes"`
+	Coordinator string    `json:"coordinator"` //address to ask for the decision
+	PreparedAt  time.Time `json:"preparedAt"`
+}
+
+type decision struct {
+	Committed bool      `json:"committed"`
+	At        time.Time `json:"at"`
+}
+
+// Participant is the replica side of two-phase commit. A prepare locks the keys
+// until commit or abort, like the paxos acceptor the state is saved in the node's
+// store before it is answered. A nil store keeps it in memory only
+type Participant struct {
+	mu         sync.Mutex
+	store      storage.Storage
+	apply      ApplyFunc
+	httpClient *http.Client
+	prepared   map[string]*preparedTxn
+	locks      map[string]string //key -> id of the transaction holding it
+	decided    map[string]decision
+}
+
+// NewParticipant recovers the prepared and decided transactions from store, the
+// keys of every prepared one are locked again until its decision arrives
+func NewParticipant(store storage.Storage, apply ApplyFunc) (*Participant, error) {
+	p := &Participant{
+		store:      store,
+		apply:      apply,
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+		prepared:   make(map[string]*preparedTxn),
+		locks:      make(map[string]string),
+		decided:    make(map[string]decision),
+	}
+	if err := p.load(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Participant) load() error {
+	if p.store == nil {
+		return nil
+	}
+
+	if err := p.loadEach(decidedPrefix, func(id, raw string) error {
+		var d decision
+		if err := json.Unmarshal([]byte(raw), &d); err != nil {
+			return err
+		}
+		p.decided[id] = d
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if err := p.loadEach(preparedPrefix, func(id, raw string) error {
+		//a crash after the decision was saved but before the prepared record was removed
+		if _, ok := p.decided[id]; ok {
+			return p.store.Delete(preparedPrefix + id)
+		}
+		var t preparedTxn
+		if err := json.Unmarshal([]byte(raw), &t); err != nil {
+			return err
+		}
+		p.prepared[id] = &t
+		for _, w := range t.Writes {
+			p.locks[w.Key] = id
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if len(p.prepared) > 0 {
+		log.Printf("[TXN] Recovered %d prepared transactions, their keys stay locked until decided", len(p.prepared))
+	}
+	return nil
+}
+
+func (p *Participant) loadEach(prefix string, fn func(id, raw string) error) error {
+	keys, err := p.store.ScanKeys(prefix, "", 0)
+	if err != nil {
+		return fmt.Errorf("failed to scan %s: %w", prefix, err)
+	}
+	for _, key := range keys {
+		raw, ok, err := storage.LatestValue(p.store, key)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if err := fn(strings.TrimPrefix(key, prefix), raw); err != nil {
+			return fmt.Errorf("failed to recover %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+func (p *Participant) save(key string, v interface{}) error {
+	if p.store == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return storage.ReplaceValue(p.store, key, string(data))
+}
+
+func (p *Participant) remove(key string) error {
+	if p.store == nil {
+		return nil
+	}
+	return p.store.Delete(key)
+}
+
+// Prepare votes yes when every key is free and every write can be applied. The
+// prepared writes are durable before the yes vote, from then on the transaction
+// only ends with the coordinator's decision
+func (p *Participant) Prepare(id, coordinator string, writes []Write) Vote {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.expireLocked(time.Now())
+
+	if _, ok := p.prepared[id]; ok {
+		return Vote{Prepared: true}
+	}
+	if d, ok := p.decided[id]; ok {
+		return Vote{Reason: fmt.Sprintf("transaction already %s", d.outcome())}
+	}
+
+	for _, w := range writes {
+		if w.Key == "" {
+			return Vote{Reason: "key is required"}
+		}
+		if _, err := model.ParseExpiry(w.ExpiresAt); err != nil {
+			return Vote{Reason: err.Error()}
+		}
+		if holder, ok := p.locks[w.Key]; ok {
+			return Vote{Reason: fmt.Sprintf("key '%s' is locked by transaction %s", w.Key, holder)}
+		}
+	}
+
+	t := &preparedTxn{Writes: writes, Coordinator: coordinator, PreparedAt: time.Now()}
+	if err := p.save(preparedPrefix+id, t); err != nil {
+		log.Printf("[TXN] Failed to save prepared %s: %v", id, err)
+		return Vote{Reason: fmt.Sprintf("failed to save prepared writes: %v", err)}
+	}
+
+	for _, w := range writes {
+		p.locks[w.Key] = id
+	}
+	p.prepared[id] = t
+
+	log.Printf("[TXN] Prepared %s with %d keys", id, len(writes))
+	return Vote{Prepared: true}
+}
+
+// Commit applies the prepared writes, committing twice is fine. When a write
+// can't be stored the transaction stays prepared so the coordinator's retry
+// applies it again
+func (p *Participant) Commit(id string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.expireLocked(time.Now())
+
+	t, ok := p.prepared[id]
+	if !ok {
+		if d, seen := p.decided[id]; seen {
+			if d.Committed {
+				return nil
+			}
+			return ErrAborted
+		}
+		return ErrUnknownTransaction
+	}
+
+	var failed []string
+	for _, w := range t.Writes {
+		expiresAt, _ := model.ParseExpiry(w.ExpiresAt)
+		if err := p.apply(w.Key, w.Value, w.VectorClock, expiresAt, w.Tombstone); err != nil {
+			log.Printf("[TXN] %s failed to apply key='%s': %v", id, w.Key, err)
+			failed = append(failed, w.Key)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("commit of %s could not store keys %v", id, failed)
+	}
+
+	if err := p.decideLocked(id, true); err != nil {
+		return err
+	}
+	log.Printf("[TXN] Committed %s", id)
+	return nil
+}
+
+// Abort drops the prepared writes. An abort for a transaction this replica never
+// prepared is remembered too, so a prepare that arrives late is refused
+func (p *Participant) Abort(id string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if d, ok := p.decided[id]; ok {
+		if d.Committed {
+			return fmt.Errorf("transaction %s is already committed", id)
+		}
+		return nil
+	}
+	if err := p.decideLocked(id, false); err != nil {
+		return err
+	}
+	log.Printf("[TXN] Aborted %s", id)
+	return nil
+}
+
+//the decision is saved before the prepared record goes, a crash in between
+//leaves both and recovery keeps the decision
+func (p *Participant) decideLocked(id string, committed bool) error {
+	d := decision{Committed: committed, At: time.Now()}
+	if err := p.save(decidedPrefix+id, d); err != nil {
+		return fmt.Errorf("failed to save decision of %s: %w", id, err)
+	}
+	if t, ok := p.prepared[id]; ok {
+		if err := p.remove(preparedPrefix + id); err != nil {
+			log.Printf("[TXN] Failed to remove prepared %s, recovery drops it: %v", id, err)
+		}
+		for _, w := range t.Writes {
+			if p.locks[w.Key] == id {
+				delete(p.locks, w.Key)
+			}
+		}
+		delete(p.prepared, id)
+	}
+	p.decided[id] = d
+	return nil
+}
+
+func (p *Participant) expireLocked(now time.Time) {
+	for id, d := range p.decided {
+		if now.Sub(d.At) <= decisionRetention {
+			continue
+		}
+		if err := p.remove(decidedPrefix + id); err != nil {
+			log.Printf("[TXN] Failed to drop decision of %s: %v", id, err)
+			continue
+		}
+		delete(p.decided, id)
+	}
+}
+
+// Start asks the coordinator for the outcome of every transaction that has been
+// prepared for longer than InDoubtAfter, until it gets an answer
+func (p *Participant) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			p.resolveInDoubt()
+		}
+	}()
+}
+
+func (p *Participant) resolveInDoubt() {
+	p.mu.Lock()
+	inDoubt := make(map[string]string)
+	for id, t := range p.prepared {
+		if time.Since(t.PreparedAt) > InDoubtAfter {
+			inDoubt[id] = t.Coordinator
+		}
+	}
+	p.mu.Unlock()
+
+	for id, coordinator := range inDoubt {
+		state, err := p.askCoordinator(coordinator, id)
+		if err != nil {
+			log.Printf("[TXN] %s is in doubt and the coordinator %s can't be asked, keys stay locked: %v", id, coordinator, err)
+			continue
+		}
+
+		switch state {
+		case StateCommitted:
+			err = p.Commit(id)
+		case StateAborted:
+			err = p.Abort(id)
+		default:
+			log.Printf("[TXN] %s is still undecided on the coordinator", id)
+			continue
+		}
+		if err != nil {
+			log.Printf("[TXN] %s failed to apply the %s decision: %v", id, state, err)
+		} else {
+			log.Printf("[TXN] %s resolved as %s by asking the coordinator", id, state)
+		}
+	}
+}
+
+func (p *Participant) askCoordinator(coordinator, id string) (string, error) {
+	if coordinator == "" {
+		return "", fmt.Errorf("no coordinator recorded")
+	}
+	resp, err := p.httpClient.Get("http://127.0.0.1" + coordinator + "/txn/status/" + id)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("status=%d", resp.StatusCode)
+	}
+	var body struct {
+		State string `json:"state"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	return body.State, nil
+}
+
+func (d decision) outcome() string {
+	if d.Committed {
+		return StateCommitted
+	}
+	return StateAborted
+}
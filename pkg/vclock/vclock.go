@@ -0,0 +1,162 @@
+package vclock
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// A version's vector clock is a JSON map of node id -> counter. Clocks written by
+// the coordinator also carry the write's own event (its dot) as "@<node>": the
+// counter for that node the client had seen before this write. The clock minus
+// the dot is what the write supersedes, so two writes made from the same or an
+// older context stay siblings even though one coordinator stamps both.
+// Clocks without a dot (older data, other writers) compare as plain vectors
+const dotPrefix = "@"
+
+type dot struct {
+	node    string
+	counter int
+	base    int
+}
+
+// Parse returns the node counters of a clock, the dot marker is dropped so the
+// result can be merged into a causal context
+func Parse(vc string) map[string]int {
+	out := map[string]int{}
+	for node, counter := range parseRaw(vc) {
+		if !strings.HasPrefix(node, dotPrefix) {
+			out[node] = counter
+		}
+	}
+	return out
+}
+
+func Serialize(vc map[string]int) string {
+	b, _ := json.Marshal(vc)
+	return string(b)
+}
+
+// Merge takes the max counter per node into dst
+func Merge(dst, src map[string]int) {
+	for k, v := range src {
+		if cur, ok := dst[k]; !ok || v > cur {
+			dst[k] = v
+		}
+	}
+}
+
+// Descends reports whether a has seen every event b has seen
+func Descends(a, b map[string]int) bool {
+	for node, counter := range b {
+		if a[node] < counter {
+			return false
+		}
+	}
+	return true
+}
+
+// New is the clock of a write by node made after seeing context. counter has to
+// be above every counter node already used for the key so the dot is unique
+func New(context map[string]int, node string, counter int) string {
+	out := make(map[string]int, len(context)+2)
+	for k, v := range context {
+		out[k] = v
+	}
+	out[dotPrefix+node] = context[node]
+	out[node] = counter
+	return Serialize(out)
+}
+
+// Supersedes reports whether the write with clock b had seen the write with clock a,
+// equal clocks are the same write and never supersede each other
+func Supersedes(b, a string) bool {
+	if a == b {
+		return false
+	}
+	rawA, rawB := parseRaw(a), parseRaw(b)
+	ctxB := context(rawB)
+
+	if d, ok := findDot(rawA); ok {
+		return ctxB[d.node] >= d.counter
+	}
+
+	//no dot, every event of a has to be in b's context
+	actorsA := Parse(a)
+	if !Descends(ctxB, actorsA) {
+		return false
+	}
+	_, dotted := findDot(rawB)
+	return dotted || !Descends(actorsA, Parse(b))
+}
+
+// Dominated reports whether clocks[i] is superseded by another clock of the set.
+// Equal clocks are one write seen twice unless same says the versions differ,
+// of a duplicate only the first copy is kept
+func Dominated(clocks []string, i int, same func(a, b int) bool) bool {
+	for j := range clocks {
+		if i == j {
+			continue
+		}
+		if Supersedes(clocks[j], clocks[i]) {
+			return true
+		}
+		if j < i && clocks[j] == clocks[i] && same(i, j) {
+			return true
+		}
+	}
+	return false
+}
+
+// Purgeable marks what an expiry purge removes from the versions of one key:
+// the expired ones and every version an expired one supersedes, so an expired
+// write or a collected tombstone doesn't bring back what it replaced. Siblings
+// the expired write never saw are still current and stay
+func Purgeable(clocks []string, expired []bool) []bool {
+	out := make([]bool, len(clocks))
+	for i := range clocks {
+		if expired[i] {
+			out[i] = true
+			continue
+		}
+		for j := range clocks {
+			if expired[j] && Supersedes(clocks[j], clocks[i]) {
+				out[i] = true
+				break
+			}
+		}
+	}
+	return out
+}
+
+func parseRaw(vc string) map[string]int {
+	out := map[string]int{}
+	if vc == "" {
+		return out
+	}
+	_ = json.Unmarshal([]byte(vc), &out)
+	return out
+}
+
+//context is what a write had seen: its clock with the dot counter put back to the base
+func context(raw map[string]int) map[string]int {
+	out := map[string]int{}
+	for node, counter := range raw {
+		if !strings.HasPrefix(node, dotPrefix) {
+			out[node] = counter
+		}
+	}
+	if d, ok := findDot(raw); ok {
+		out[d.node] = d.base
+	}
+	return out
+}
+
+func findDot(raw map[string]int) (dot, bool) {
+	for k, base := range raw {
+		if strings.HasPrefix(k, dotPrefix) {
+			node := strings.TrimPrefix(k, dotPrefix)
+			return dot{node: node, counter: raw[node], base: base}, true
+		}
+	}
+	return dot{}, false
+}
@@ -0,0 +1,129 @@
+package vclock
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDropsDot(t *testing.T) {
+	got := Parse(New(map[string]int{"a": 3, "b": 1}, "a", 4))
+	want := map[string]int{"a": 4, "b": 1}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Parse = %v, want %v", got, want)
+	}
+	if got := Parse(""); len(got) != 0 {
+		t.Fatalf("Parse(\"\") = %v, want empty", got)
+	}
+}
+
+func TestDescends(t *testing.T) {
+	cases := []struct {
+		name string
+		a, b map[string]int
+		want bool
+	}{
+		{"equal", map[string]int{"a": 1, "b": 2}, map[string]int{"a": 1, "b": 2}, true},
+		{"ahead", map[string]int{"a": 2, "b": 2}, map[string]int{"a": 1, "b": 2}, true},
+		{"behind", map[string]int{"a": 1}, map[string]int{"a": 2}, false},
+		{"missing node", map[string]int{"a": 1}, map[string]int{"a": 1, "b": 1}, false},
+		{"extra node", map[string]int{"a": 1, "b": 1}, map[string]int{"a": 1}, true},
+		{"concurrent", map[string]int{"a": 2, "b": 1}, map[string]int{"a": 1, "b": 2}, false},
+		{"empty", map[string]int{"a": 1}, map[string]int{}, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := Descends(c.a, c.b); got != c.want {
+				t.Fatalf("Descends(%v, %v) = %v, want %v", c.a, c.b, got, c.want)
+			}
+		})
+	}
+}
+
+func TestSupersedes(t *testing.T) {
+	first := New(nil, "a", 1)                     //blind write
+	second := New(map[string]int{"a": 1}, "a", 2) //read first, then wrote
+	blind := New(nil, "a", 2)                     //same coordinator, never saw first
+	other := New(nil, "b", 1)                     //another coordinator, never saw first
+
+	cases := []struct {
+		name string
+		b, a string
+		want bool
+	}{
+		{"later write from read context", second, first, true},
+		{"earlier write", first, second, false},
+		{"concurrent dots of one node", blind, first, false},
+		{"concurrent dots of one node reversed", first, blind, false},
+		{"concurrent dots of two nodes", other, first, false},
+		{"equal clocks", first, first, false},
+		{"equal plain clocks", `{"a":1}`, `{"a":1}`, false},
+		{"plain ahead", `{"a":2}`, `{"a":1}`, true},
+		{"plain behind", `{"a":1}`, `{"a":2}`, false},
+		{"plain concurrent", `{"a":2,"b":1}`, `{"a":1,"b":2}`, false},
+		{"dotted write over plain context", New(map[string]int{"a": 2}, "b", 1), `{"a":2}`, true},
+		{"plain merge over its dotted siblings", `{"a":2}`, blind, true},
+		{"plain merge over older dotted sibling", `{"a":2}`, first, true},
+		{"anything over empty", first, "", true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := Supersedes(c.b, c.a); got != c.want {
+				t.Fatalf("Supersedes(%s, %s) = %v, want %v", c.b, c.a, got, c.want)
+			}
+		})
+	}
+}
+
+func TestDominated(t *testing.T) {
+	first := New(nil, "a", 1)
+	second := New(map[string]int{"a": 1}, "a", 2)
+	blind := New(nil, "a", 2)
+
+	cases := []struct {
+		name   string
+		clocks []string
+		values []string
+		want   []bool
+	}{
+		{"superseded write", []string{first, second}, []string{"x", "y"}, []bool{true, false}},
+		{"siblings", []string{first, blind}, []string{"x", "y"}, []bool{false, false}},
+		{"one write seen twice keeps the first copy", []string{first, first}, []string{"x", "x"}, []bool{false, true}},
+		{"equal clocks with different values stay", []string{first, first}, []string{"x", "y"}, []bool{false, false}},
+		{"single version", []string{first}, []string{"x"}, []bool{false}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			same := func(a, b int) bool { return c.values[a] == c.values[b] }
+			for i := range c.clocks {
+				if got := Dominated(c.clocks, i, same); got != c.want[i] {
+					t.Fatalf("Dominated(%d) = %v, want %v", i, got, c.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPurgeable(t *testing.T) {
+	first := New(nil, "a", 1)
+	second := New(map[string]int{"a": 1}, "a", 2)
+	blind := New(nil, "a", 3)
+
+	cases := []struct {
+		name    string
+		clocks  []string
+		expired []bool
+		want    []bool
+	}{
+		{"nothing expired", []string{first, second}, []bool{false, false}, []bool{false, false}},
+		{"expired write takes what it replaced", []string{first, second}, []bool{false, true}, []bool{true, true}},
+		{"expired old write leaves its successor", []string{first, second}, []bool{true, false}, []bool{true, false}},
+		{"sibling the expired write never saw stays", []string{first, second, blind}, []bool{false, true, false}, []bool{true, true, false}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := Purgeable(c.clocks, c.expired); !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("Purgeable = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
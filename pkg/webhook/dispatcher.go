@@ -0,0 +1,265 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rupeshx80/consistent-hashing/pkg/changelog"
+)
+
+// PayloadFunc builds the data sent for a change, the main server sends the key's
+// sibling set as it is once the change is applied
+type PayloadFunc func(c changelog.Change) interface{}
+
+// Dispatcher runs one worker per webhook tailing the change log from the
+// webhook's cursor, so deliveries are at least once and survive restarts.
+// A failing endpoint only holds up its own webhook
+type Dispatcher struct {
+	cfg     Config
+	store   *Store
+	changes *changelog.Log
+	payload PayloadFunc
+	client  *http.Client
+
+	mu      sync.Mutex
+	workers map[string]context.CancelFunc
+}
+
+func NewDispatcher(cfg Config, store *Store, changes *changelog.Log, payload PayloadFunc) *Dispatcher {
+	return &Dispatcher{
+		cfg:     cfg,
+		store:   store,
+		changes: changes,
+		payload: payload,
+		client:  &http.Client{Timeout: cfg.Timeout},
+		workers: make(map[string]context.CancelFunc),
+	}
+}
+
+// Start resumes every registered webhook where it left off
+func (d *Dispatcher) Start() {
+	for _, hook := range d.store.List() {
+		d.startWorker(hook)
+	}
+}
+
+// Register adds a webhook that hears about changes from now on
+func (d *Dispatcher) Register(hook Webhook) (Webhook, error) {
+	hook, err := d.store.Add(hook, d.changes.Last())
+	if err != nil {
+		return hook, err
+	}
+	d.startWorker(hook)
+	log.Printf("[WEBHOOK] Registered %s for prefix='%s' -> %s", hook.ID, hook.Prefix, hook.URL)
+	return hook, nil
+}
+
+func (d *Dispatcher) Unregister(id string) error {
+	d.mu.Lock()
+	if cancel, ok := d.workers[id]; ok {
+		cancel()
+		delete(d.workers, id)
+	}
+	d.mu.Unlock()
+	return d.store.Remove(id)
+}
+
+func (d *Dispatcher) startWorker(hook Webhook) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	d.mu.Lock()
+	d.workers[hook.ID] = cancel
+	d.mu.Unlock()
+
+	go d.run(ctx, hook)
+}
+
+const deliveryBatch = 100
+
+func (d *Dispatcher) run(ctx context.Context, hook Webhook) {
+	cursor := d.store.Cursor(hook.ID)
+
+	for ctx.Err() == nil {
+		notify := d.changes.Wait()
+		batch, next, err := d.changes.Read(cursor, hook.Prefix, deliveryBatch)
+		if errors.Is(err, changelog.ErrTruncated) {
+			oldest := d.changes.Oldest()
+			log.Printf("[WEBHOOK] %s fell behind retention, changes %d to %d were never delivered", hook.ID, cursor+1, oldest-1)
+			cursor = oldest - 1
+			continue
+		}
+
+		handled := true
+		for _, c := range batch {
+			if ctx.Err() != nil {
+				return
+			}
+			if !d.handle(ctx, hook, c) {
+				handled = false
+				break
+			}
+			cursor = c.Offset
+		}
+
+		//a change that is neither delivered nor a dead letter keeps the cursor
+		//in front of it and is tried again
+		if !handled {
+			select {
+			case <-time.After(d.cfg.MaxBackoff):
+			case <-ctx.Done():
+			}
+			continue
+		}
+
+		//changes the prefix filtered out still move the cursor
+		if next > cursor {
+			cursor = next
+			if err := d.store.Advance(hook.ID, cursor); err != nil {
+				log.Printf("[WEBHOOK] %s failed to save cursor: %v", hook.ID, err)
+			}
+		}
+		if len(batch) == deliveryBatch {
+			continue
+		}
+
+		select {
+		case <-notify:
+		case <-ctx.Done():
+		}
+	}
+}
+
+//delivers c with retries, a change that never gets through becomes a dead letter.
+//false means neither happened and the cursor must not move past c
+func (d *Dispatcher) handle(ctx context.Context, hook Webhook, c changelog.Change) bool {
+	body, err := json.Marshal(map[string]interface{}{
+		"delivery":  deliveryID(hook.ID, c.Offset),
+		"event":     c.Op,
+		"offset":    c.Offset,
+		"key":       c.Key,
+		"timestamp": c.Timestamp,
+		"data":      d.payload(c),
+	})
+	if err != nil {
+		//no retry can build it either
+		log.Printf("[WEBHOOK] %s failed to build payload for offset %d: %v", hook.ID, c.Offset, err)
+		return true
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= d.cfg.MaxAttempts; attempt++ {
+		if lastErr = d.deliver(hook, deliveryID(hook.ID, c.Offset), c.Op, body); lastErr == nil {
+			if err := d.store.Advance(hook.ID, c.Offset); err != nil {
+				log.Printf("[WEBHOOK] %s failed to save cursor: %v", hook.ID, err)
+			}
+			return true
+		}
+		log.Printf("[WEBHOOK] %s attempt %d/%d for offset %d failed: %v", hook.ID, attempt, d.cfg.MaxAttempts, c.Offset, lastErr)
+
+		if attempt == d.cfg.MaxAttempts {
+			break
+		}
+		select {
+		case <-time.After(d.backoff(attempt)):
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	err = d.store.AddDeadLetter(DeadLetter{
+		WebhookID: hook.ID,
+		Offset:    c.Offset,
+		Key:       c.Key,
+		Event:     c.Op,
+		Payload:   body,
+		Attempts:  d.cfg.MaxAttempts,
+		LastError: lastErr.Error(),
+		FailedAt:  time.Now().UTC(),
+	})
+	if err != nil {
+		log.Printf("[WEBHOOK] %s failed to store dead letter for offset %d, retrying it: %v", hook.ID, c.Offset, err)
+		return false
+	}
+	log.Printf("[WEBHOOK] %s gave up on offset %d key='%s', moved to dead letters", hook.ID, c.Offset, c.Key)
+	return true
+}
+
+//exponential with up to 20% jitter so retries of many hooks don't line up
+func (d *Dispatcher) backoff(attempt int) time.Duration {
+	wait := d.cfg.BaseBackoff << (attempt - 1)
+	if wait <= 0 || wait > d.cfg.MaxBackoff {
+		wait = d.cfg.MaxBackoff
+	}
+	return wait + time.Duration(rand.Int63n(int64(wait)/5+1))
+}
+
+func deliveryID(hookID string, offset uint64) string {
+	return hookID + "-" + strconv.FormatUint(offset, 10)
+}
+
+// deliver makes one signed POST, any 2xx counts as delivered
+func (d *Dispatcher) deliver(hook Webhook, delivery, event string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(DeliveryHeader, delivery)
+	req.Header.Set(EventHeader, event)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(hook.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("endpoint answered %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Replay sends a dead letter once more, it is removed when the endpoint accepts it
+func (d *Dispatcher) Replay(id string) error {
+	letter, err := d.store.DeadLetter(id)
+	if err != nil {
+		return err
+	}
+	hook, ok := d.store.Get(letter.WebhookID)
+	if !ok {
+		return ErrWebhookNotFound
+	}
+
+	err = d.deliver(hook, deliveryID(hook.ID, letter.Offset), letter.Event, letter.Payload)
+	if resolveErr := d.store.ResolveDeadLetter(id, err == nil, err); resolveErr != nil {
+		return resolveErr
+	}
+	if err != nil {
+		return fmt.Errorf("replay failed: %w", err)
+	}
+	log.Printf("[WEBHOOK] Replayed dead letter %s of %s (offset %d)", id, hook.ID, letter.Offset)
+	return nil
+}
+
+func (d *Dispatcher) Webhooks() []Webhook {
+	return d.store.List()
+}
+
+func (d *Dispatcher) DeadLetters(webhookID string) []DeadLetter {
+	return d.store.DeadLetters(webhookID)
+}
@@ -0,0 +1,226 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrWebhookNotFound    = errors.New("webhook not found")
+	ErrDeadLetterNotFound = errors.New("dead letter not found")
+)
+
+//everything the store keeps, small enough to rewrite on every change
+type storeState struct {
+	Webhooks    []Webhook         `json:"webhooks"`
+	Cursors     map[string]uint64 `json:"cursors"` //last change offset handled per webhook
+	DeadLetters []DeadLetter      `json:"deadLetters"`
+}
+
+// Store keeps webhooks, their delivery cursors and dead letters in one json file
+type Store struct {
+	mu    sync.Mutex
+	path  string
+	state storeState
+}
+
+func OpenStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create webhook dir: %w", err)
+	}
+
+	s := &Store{path: filepath.Join(dir, "webhooks.json")}
+	s.state.Cursors = make(map[string]uint64)
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhooks: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.state); err != nil {
+		return nil, fmt.Errorf("corrupt webhook store: %w", err)
+	}
+	if s.state.Cursors == nil {
+		s.state.Cursors = make(map[string]uint64)
+	}
+	return s, nil
+}
+
+//write to a temp file and rename so a crash keeps the previous state
+func (s *Store) saveLocked() error {
+	data, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	f.Close()
+	return os.Rename(tmp, s.path)
+}
+
+// Add registers hook, deliveries start after offset "from"
+func (s *Store) Add(hook Webhook, from uint64) (Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hook.ID = randomHex(8)
+	if hook.Secret == "" {
+		hook.Secret = randomHex(32)
+	}
+	hook.CreatedAt = time.Now().UTC()
+
+	s.state.Webhooks = append(s.state.Webhooks, hook)
+	s.state.Cursors[hook.ID] = from
+	if err := s.saveLocked(); err != nil {
+		return Webhook{}, fmt.Errorf("failed to save webhook: %w", err)
+	}
+	return hook, nil
+}
+
+// Remove drops the webhook with its cursor and dead letters
+func (s *Store) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.state.Webhooks[:0]
+	found := false
+	for _, h := range s.state.Webhooks {
+		if h.ID == id {
+			found = true
+			continue
+		}
+		kept = append(kept, h)
+	}
+	if !found {
+		return ErrWebhookNotFound
+	}
+	s.state.Webhooks = kept
+	delete(s.state.Cursors, id)
+
+	letters := s.state.DeadLetters[:0]
+	for _, d := range s.state.DeadLetters {
+		if d.WebhookID != id {
+			letters = append(letters, d)
+		}
+	}
+	s.state.DeadLetters = letters
+	return s.saveLocked()
+}
+
+func (s *Store) List() []Webhook {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Webhook(nil), s.state.Webhooks...)
+}
+
+func (s *Store) Get(id string) (Webhook, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, h := range s.state.Webhooks {
+		if h.ID == id {
+			return h, true
+		}
+	}
+	return Webhook{}, false
+}
+
+func (s *Store) Cursor(id string) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state.Cursors[id]
+}
+
+// Advance records that every change up to offset was handled for the webhook
+func (s *Store) Advance(id string, offset uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.state.Cursors[id]; !ok {
+		return ErrWebhookNotFound
+	}
+	s.state.Cursors[id] = offset
+	return s.saveLocked()
+}
+
+// AddDeadLetter stores a failed delivery and moves the cursor past it in one write
+func (s *Store) AddDeadLetter(d DeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.state.Cursors[d.WebhookID]; !ok {
+		return ErrWebhookNotFound
+	}
+	d.ID = randomHex(8)
+	s.state.DeadLetters = append(s.state.DeadLetters, d)
+	s.state.Cursors[d.WebhookID] = d.Offset
+	return s.saveLocked()
+}
+
+// DeadLetters lists the failed deliveries of one webhook, or of all when id is empty, oldest first
+func (s *Store) DeadLetters(webhookID string) []DeadLetter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var out []DeadLetter
+	for _, d := range s.state.DeadLetters {
+		if webhookID == "" || d.WebhookID == webhookID {
+			out = append(out, d)
+		}
+	}
+	sort.SliceStable(out, func(a, b int) bool { return out[a].FailedAt.Before(out[b].FailedAt) })
+	return out
+}
+
+func (s *Store) DeadLetter(id string) (DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, d := range s.state.DeadLetters {
+		if d.ID == id {
+			return d, nil
+		}
+	}
+	return DeadLetter{}, ErrDeadLetterNotFound
+}
+
+// ResolveDeadLetter removes a replayed delivery, or on failure records the new attempt
+func (s *Store) ResolveDeadLetter(id string, delivered bool, attemptErr error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, d := range s.state.DeadLetters {
+		if d.ID != id {
+			continue
+		}
+		if delivered {
+			s.state.DeadLetters = append(s.state.DeadLetters[:i], s.state.DeadLetters[i+1:]...)
+		} else {
+			s.state.DeadLetters[i].Attempts++
+			s.state.DeadLetters[i].FailedAt = time.Now().UTC()
+			if attemptErr != nil {
+				s.state.DeadLetters[i].LastError = attemptErr.Error()
+			}
+		}
+		return s.saveLocked()
+	}
+	return ErrDeadLetterNotFound
+}
@@ -0,0 +1,101 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+	DeliveryHeader  = "X-Webhook-Delivery"
+	EventHeader     = "X-Webhook-Event"
+)
+
+// Webhook is called for every change of a key with Prefix, an empty prefix matches every key
+type Webhook struct {
+	ID        string    `json:"id"`
+	Prefix    string    `json:"prefix"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// DeadLetter is a delivery that failed every attempt, kept until it is replayed
+type DeadLetter struct {
+	ID        string          `json:"id"`
+	WebhookID string          `json:"webhookId"`
+	Offset    uint64          `json:"offset"`
+	Key       string          `json:"key"`
+	Event     string          `json:"event"`
+	Payload   json.RawMessage `json:"payload"`
+	Attempts  int             `json:"attempts"`
+	LastError string          `json:"lastError"`
+	FailedAt  time.Time       `json:"failedAt"`
+}
+
+type Config struct {
+	Dir         string
+	MaxAttempts int
+	BaseBackoff time.Duration //doubled after every failed attempt
+	MaxBackoff  time.Duration
+	Timeout     time.Duration
+}
+
+// ConfigFromEnv reads WEBHOOK_DIR (data/webhooks), WEBHOOK_MAX_ATTEMPTS (5)
+// and WEBHOOK_TIMEOUT (10s)
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Dir:         "data/webhooks",
+		MaxAttempts: 5,
+		BaseBackoff: time.Second,
+		MaxBackoff:  time.Minute,
+		Timeout:     10 * time.Second,
+	}
+
+	if v := os.Getenv("WEBHOOK_DIR"); v != "" {
+		cfg.Dir = v
+	}
+	if v := os.Getenv("WEBHOOK_MAX_ATTEMPTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			log.Printf("[WEBHOOK] Ignoring invalid WEBHOOK_MAX_ATTEMPTS=%s", v)
+		} else {
+			cfg.MaxAttempts = n
+		}
+	}
+	if v := os.Getenv("WEBHOOK_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Printf("[WEBHOOK] Ignoring invalid WEBHOOK_TIMEOUT=%s", v)
+		} else {
+			cfg.Timeout = d
+		}
+	}
+	return cfg
+}
+
+// Sign is the hex HMAC-SHA256 of "timestamp.body", receivers recompute it with
+// the shared secret and reject old timestamps to stop replays
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}